@@ -18,6 +18,7 @@ import (
 
 	"kero-kero/internal/config"
 	"kero-kero/internal/handlers"
+	"kero-kero/internal/models"
 	"kero-kero/internal/repository"
 	"kero-kero/internal/routes"
 	mw "kero-kero/internal/server/middleware"
@@ -55,7 +56,10 @@ func main() {
 	// Repositorio de instancias
 	instanceRepo := repository.NewInstanceRepository(db)
 	webhookRepo := repository.NewWebhookRepository(redisClient)
+	webhookLogRepo := repository.NewWebhookLogRepository(db)
 	msgRepo := repository.NewMessageRepository(db)
+	ratePolicyRepo := repository.NewRatePolicyRepository(redisClient)
+	idempotencyRepo := repository.NewIdempotencyRepository(redisClient)
 
 	// Inicializar contenedor de WhatsApp
 	var waContainer *sqlstore.Container
@@ -89,8 +93,29 @@ func main() {
 	// Servicios de negocio
 	authService := services.NewAuthService(cfg.Security.JWTSecret, cfg.Security.APIKey)
 	webhookService := services.NewWebhookService(webhookRepo)
+	webhookService.SetDeliveryPolicy(services.WebhookDeliveryPolicy{
+		Workers:       cfg.Webhook.Workers,
+		MaxAttempts:   cfg.Webhook.MaxAttempts,
+		BackoffBase:   cfg.Webhook.BackoffBase,
+		BackoffMax:    cfg.Webhook.BackoffMax,
+		Timeout:       cfg.Webhook.Timeout,
+		DeadLetterMax: cfg.Webhook.DeadLetterMax,
+		LogRetention:  cfg.Webhook.LogRetention,
+
+		CircuitThreshold: cfg.Webhook.CircuitThreshold,
+		ProbeInterval:    cfg.Webhook.ProbeInterval,
+	})
+	webhookService.SetDeliveryLog(webhookLogRepo)
+	if cfg.Webhook.GlobalURL != "" {
+		if err := webhookService.SetEnvGlobalWebhook(cfg.Webhook.GlobalURL, cfg.Webhook.GlobalEvents, cfg.Webhook.GlobalSecret); err != nil {
+			log.Fatal().Err(err).Msg("WEBHOOK_GLOBAL_URL inválida")
+		}
+	}
 	instanceService := services.NewInstanceService(waManager, instanceRepo, redisClient, webhookService)
 	messageService := services.NewMessageService(waManager, msgRepo)
+	ratePolicyService := services.NewRatePolicyService(ratePolicyRepo, models.RatePolicy{PerMinute: cfg.Sending.RatePerMinute})
+	messageService.SetRatePolicy(ratePolicyService)
+	idempotencyService := services.NewIdempotencyService(idempotencyRepo, cfg.Sending.IdempotencyTTL)
 	groupService := services.NewGroupService(waManager)
 	contactService := services.NewContactService(waManager)
 	presenceService := services.NewPresenceService(waManager) // Nuevo servicio de presencia
@@ -100,6 +125,15 @@ func main() {
 	statusService := services.NewStatusService(waManager)
 	callService := services.NewCallService(waManager, redisClient)
 	wsService := services.NewWebSocketService()
+	wsService.SetAllowedOrigins(cfg.CORS.AllowedOrigins)
+	wsService.SetCommandServices(messageService, presenceService, chatService)
+	if cfg.WebSocket.EventLogSize > 0 {
+		wsService.SetEventLog(repository.NewEventStreamRepository(redisClient, int64(cfg.WebSocket.EventLogSize), cfg.WebSocket.EventLogTTL))
+	}
+	if cfg.WebSocket.EventBus == "redis" {
+		wsService.SetEventBus(repository.NewRedisEventBus(redisClient))
+	}
+	webhookService.SetEventNotifier(wsService)
 	syncService := services.NewSyncService(waManager, msgRepo, chatService)
 	newsletterService := services.NewNewsletterService(waManager, messageService)
 	businessService := services.NewBusinessService(waManager, redisClient)
@@ -111,8 +145,15 @@ func main() {
 	// Iniciar Scheduler de automatización
 	automationService.StartScheduler()
 
+	// Dispatcher de webhooks (entrega con reintentos)
+	webhookService.Start()
+	defer webhookService.Stop()
+
 	// Servicio de Cola (Workers)
 	queueService := services.NewQueueService(redisClient, messageService)
+	queueService.SetWorkers(cfg.Queue.Workers)
+	queueService.SetWebhookService(webhookService)
+	queueService.SetEventNotifier(wsService)
 	queueService.Start()
 	defer queueService.Stop()
 
@@ -120,6 +161,7 @@ func main() {
 	waManager.SetWebhookService(webhookService)
 	waManager.SetWebSocketService(wsService)
 	waManager.SetAutomationService(automationService)
+	waManager.SetEventDedupTTL(cfg.WhatsApp.EventDedupTTL)
 
 	// Handlers HTTP
 	authHandler := handlers.NewAuthHandler(authService)
@@ -137,6 +179,9 @@ func main() {
 	syncHandler := handlers.NewSyncHandler(syncService)
 	newsletterHandler := handlers.NewNewsletterHandler(newsletterService)
 	businessHandler := handlers.NewBusinessHandler(businessService)
+	realtimeHandler := handlers.NewRealtimeHandler(wsService)
+	queueHandler := handlers.NewQueueHandler(queueService)
+	ratePolicyHandler := handlers.NewRatePolicyHandler(ratePolicyService)
 
 	// Router
 	r := chi.NewRouter()
@@ -204,8 +249,15 @@ func main() {
 		json.NewEncoder(w).Encode(systemInfo)
 	})
 
-	// WebSocket endpoint por instancia
-	r.Get("/instances/{instanceID}/ws", wsService.HandleConnection)
+	// Streams de eventos por instancia: WebSocket y Server-Sent Events
+	// (API key o JWT en ?token=, headers o Sec-WebSocket-Protocol)
+	r.Group(func(r chi.Router) {
+		if cfg.Security.APIKey != "" {
+			r.Use(mw.StreamAuth(authService))
+		}
+		r.Get("/instances/{instanceID}/ws", wsService.HandleConnection)
+		r.Get("/instances/{instanceID}/events", wsService.HandleSSE)
+	})
 
 	// Rutas de autenticación (públicas, sin API Key)
 	routes.RegisterAuthRoutes(r, authHandler)
@@ -216,7 +268,11 @@ func main() {
 			r.Use(mw.Auth(cfg.Security.APIKey, authService))
 		}
 		routes.SetupInstanceRoutes(r, instanceHandler)
-		routes.SetupMessageRoutes(r, messageHandler)
+		r.Group(func(r chi.Router) {
+			// Reintentos con la misma Idempotency-Key reciben la primera respuesta sin reenviar
+			r.Use(mw.Idempotency(idempotencyService))
+			routes.SetupMessageRoutes(r, messageHandler)
+		})
 		routes.SetupGroupRoutes(r, groupHandler)
 		routes.SetupContactRoutes(r, contactHandler)
 		routes.RegisterPresenceRoutes(r, presenceHandler) // Nuevo: rutas de presencia
@@ -229,6 +285,9 @@ func main() {
 		routes.SetupSyncRoutes(r, syncHandler)
 		routes.SetupNewsletterRoutes(r, newsletterHandler)
 		routes.SetupBusinessRoutes(r, businessHandler)
+		routes.SetupRealtimeRoutes(r, realtimeHandler)
+		routes.SetupQueueRoutes(r, queueHandler)
+		routes.SetupRatePolicyRoutes(r, ratePolicyHandler)
 	})
 
 	// Servidor HTTP
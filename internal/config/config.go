@@ -12,13 +12,17 @@ import (
 
 // Config contiene toda la configuración de la aplicación
 type Config struct {
-	App      AppConfig
-	Database DatabaseConfig
-	Redis    RedisConfig
-	Security SecurityConfig
-	CORS     CORSConfig
-	Logging  LoggingConfig
-	WhatsApp WhatsAppConfig
+	App       AppConfig
+	Database  DatabaseConfig
+	Redis     RedisConfig
+	Security  SecurityConfig
+	CORS      CORSConfig
+	Logging   LoggingConfig
+	WhatsApp  WhatsAppConfig
+	Webhook   WebhookConfig
+	WebSocket WebSocketConfig
+	Sending   SendingConfig
+	Queue     QueueConfig
 }
 
 type AppConfig struct {
@@ -70,6 +74,41 @@ type LoggingConfig struct {
 type WhatsAppConfig struct {
 	QRTimeout         time.Duration
 	ReconnectInterval time.Duration
+	EventDedupTTL     time.Duration // Cuánto se recuerda un evento entrante para descartar duplicados
+}
+
+type WebhookConfig struct {
+	Workers       int
+	MaxAttempts   int
+	BackoffBase   time.Duration
+	BackoffMax    time.Duration
+	Timeout       time.Duration
+	DeadLetterMax int
+	LogRetention  time.Duration
+
+	// Circuit breaker por suscripción
+	CircuitThreshold int
+	ProbeInterval    time.Duration
+
+	// Webhook global: recibe los eventos de todas las instancias
+	GlobalURL    string
+	GlobalEvents []string
+	GlobalSecret string
+}
+
+type WebSocketConfig struct {
+	EventLogSize int           // Eventos que se conservan por instancia para reanudar conexiones (0 = deshabilitado)
+	EventLogTTL  time.Duration // Tiempo sin actividad tras el que se descarta el log de una instancia
+	EventBus     string        // redis: reparte los eventos entre réplicas; none: solo clientes locales
+}
+
+type SendingConfig struct {
+	RatePerMinute  int           // Límite por minuto de la cola en las instancias sin política de envío propia (0 = sin límite)
+	IdempotencyTTL time.Duration // Cuánto se guarda la respuesta de cada Idempotency-Key
+}
+
+type QueueConfig struct {
+	Workers int // Workers de la cola de envío asíncrono por réplica (se puede cambiar en caliente por API)
 }
 
 // Load carga la configuración desde variables de entorno
@@ -122,6 +161,33 @@ func Load() (*Config, error) {
 		WhatsApp: WhatsAppConfig{
 			QRTimeout:         time.Duration(getEnvInt("WA_QR_TIMEOUT", 60)) * time.Second,
 			ReconnectInterval: time.Duration(getEnvInt("WA_RECONNECT_INTERVAL", 5)) * time.Second,
+			EventDedupTTL:     time.Duration(getEnvInt("WA_EVENT_DEDUP_TTL", 600)) * time.Second,
+		},
+		Webhook: WebhookConfig{
+			Workers:          getEnvInt("WEBHOOK_WORKERS", 4),
+			MaxAttempts:      getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+			BackoffBase:      time.Duration(getEnvInt("WEBHOOK_BACKOFF_BASE", 2)) * time.Second,
+			BackoffMax:       time.Duration(getEnvInt("WEBHOOK_BACKOFF_MAX", 600)) * time.Second,
+			Timeout:          time.Duration(getEnvInt("WEBHOOK_TIMEOUT", 10)) * time.Second,
+			DeadLetterMax:    getEnvInt("WEBHOOK_DEAD_LETTER_MAX", 1000),
+			LogRetention:     time.Duration(getEnvInt("WEBHOOK_LOG_RETENTION_HOURS", 168)) * time.Hour,
+			CircuitThreshold: getEnvInt("WEBHOOK_CIRCUIT_THRESHOLD", 5),
+			ProbeInterval:    time.Duration(getEnvInt("WEBHOOK_CIRCUIT_PROBE_INTERVAL", 60)) * time.Second,
+			GlobalURL:        getEnv("WEBHOOK_GLOBAL_URL", ""),
+			GlobalEvents:     strings.Split(getEnv("WEBHOOK_GLOBAL_EVENTS", "message,status,receipt"), ","),
+			GlobalSecret:     getEnv("WEBHOOK_GLOBAL_SECRET", ""),
+		},
+		WebSocket: WebSocketConfig{
+			EventLogSize: getEnvInt("WS_EVENT_LOG_SIZE", 1000),
+			EventLogTTL:  time.Duration(getEnvInt("WS_EVENT_LOG_TTL", 86400)) * time.Second,
+			EventBus:     getEnv("WS_EVENT_BUS", "redis"),
+		},
+		Sending: SendingConfig{
+			RatePerMinute:  getEnvInt("SEND_RATE_PER_MINUTE", 20),
+			IdempotencyTTL: time.Duration(getEnvInt("IDEMPOTENCY_TTL_HOURS", 24)) * time.Hour,
+		},
+		Queue: QueueConfig{
+			Workers: getEnvInt("QUEUE_WORKERS", 3),
 		},
 	}
 
@@ -143,6 +209,10 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("DB_DRIVER must be 'sqlite' or 'postgres', got: %s", c.Database.Driver)
 	}
 
+	if c.WebSocket.EventBus != "redis" && c.WebSocket.EventBus != "none" {
+		return fmt.Errorf("WS_EVENT_BUS must be 'redis' or 'none', got: %s", c.WebSocket.EventBus)
+	}
+
 	if c.Database.Driver == "postgres" {
 		if c.Database.Host == "" || c.Database.User == "" || c.Database.Name == "" {
 			return fmt.Errorf("PostgreSQL requires DB_HOST, DB_USER, and DB_NAME")
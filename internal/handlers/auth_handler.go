@@ -22,7 +22,8 @@ func NewAuthHandler(authService *services.AuthService) *AuthHandler {
 
 // LoginRequest representa la solicitud de login desde el cliente
 type LoginRequest struct {
-	APIKey string `json:"api_key"`
+	APIKey    string   `json:"api_key"`
+	Instances []string `json:"instances,omitempty"` // Opcional: restringe el token a estas instancias
 }
 
 // Login maneja la autenticación y genera un token JWT
@@ -43,7 +44,7 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Realizar login
-	response, err := h.authService.Login(req.APIKey)
+	response, err := h.authService.Login(req.APIKey, req.Instances...)
 	if err != nil {
 		errors.WriteJSON(w, errors.ErrUnauthorized.WithDetails("Credenciales inválidas"))
 		return
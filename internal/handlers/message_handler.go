@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
@@ -26,6 +29,64 @@ func NewMessageHandler(service *services.MessageService, queueService *services.
 	}
 }
 
+// enqueueIfAsync encola la petición si se solicitó envío asíncrono (X-Async: true) y responde
+// 202 con el ID de la cola. Retorna false si la petición se debe atender de forma síncrona.
+func (h *MessageHandler) enqueueIfAsync(w http.ResponseWriter, r *http.Request, instanceID string, msgType models.MessageType, req interface{}) bool {
+	if r.Header.Get("X-Async") != "true" {
+		return false
+	}
+
+	opts, appErr := queueOptions(r)
+	if appErr != nil {
+		errors.WriteJSON(w, appErr)
+		return true
+	}
+
+	msgID, err := h.queueService.EnqueueMessageWithOptions(r.Context(), instanceID, msgType, req, opts)
+	if err != nil {
+		if appErr, ok := err.(*errors.AppError); ok {
+			errors.WriteJSON(w, appErr)
+		} else {
+			errors.WriteJSON(w, errors.ErrInternalServer.WithDetails("Error encolando mensaje: "+err.Error()))
+		}
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"message_id": msgID,
+		"status":     "queued",
+	})
+	return true
+}
+
+// queueOptions lee la prioridad (X-Priority) y el vencimiento (X-Expires-At en RFC 3339 o X-TTL
+// en segundos) de un envío asíncrono
+func queueOptions(r *http.Request) (models.QueueOptions, *errors.AppError) {
+	opts := models.QueueOptions{Priority: strings.ToLower(r.Header.Get("X-Priority"))}
+
+	expiresAt, ttl := r.Header.Get("X-Expires-At"), r.Header.Get("X-TTL")
+	switch {
+	case expiresAt != "" && ttl != "":
+		return opts, errors.ErrBadRequest.WithDetails("Use X-Expires-At o X-TTL, no ambos")
+	case expiresAt != "":
+		t, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return opts, errors.ErrBadRequest.WithDetails("X-Expires-At debe tener formato RFC 3339")
+		}
+		opts.ExpiresAt = t
+	case ttl != "":
+		seconds, err := strconv.Atoi(ttl)
+		if err != nil || seconds <= 0 {
+			return opts, errors.ErrBadRequest.WithDetails("X-TTL debe ser un número de segundos mayor que 0")
+		}
+		opts.ExpiresAt = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+	return opts, nil
+}
+
 // SendText maneja POST /instances/{instanceID}/messages/text
 func (h *MessageHandler) SendText(w http.ResponseWriter, r *http.Request) {
 	instanceID := chi.URLParam(r, "instanceID")
@@ -42,20 +103,7 @@ func (h *MessageHandler) SendText(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verificar si se solicitó envío asíncrono
-	if r.Header.Get("X-Async") == "true" {
-		msgID, err := h.queueService.EnqueueMessage(r.Context(), instanceID, models.MessageTypeText, req)
-		if err != nil {
-			errors.WriteJSON(w, errors.ErrInternalServer.WithDetails("Error encolando mensaje: "+err.Error()))
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusAccepted)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success":    true,
-			"message_id": msgID,
-			"status":     "queued",
-		})
+	if h.enqueueIfAsync(w, r, instanceID, models.MessageTypeText, req) {
 		return
 	}
 
@@ -84,19 +132,7 @@ func (h *MessageHandler) SendImage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verificar si se solicitó envío asíncrono
-	if r.Header.Get("X-Async") == "true" {
-		msgID, err := h.queueService.EnqueueMessage(r.Context(), instanceID, models.MessageTypeImage, req)
-		if err != nil {
-			errors.WriteJSON(w, errors.ErrInternalServer.WithDetails("Error encolando mensaje: "+err.Error()))
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusAccepted)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success":    true,
-			"message_id": msgID,
-			"status":     "queued",
-		})
+	if h.enqueueIfAsync(w, r, instanceID, models.MessageTypeImage, req) {
 		return
 	}
 
@@ -125,19 +161,7 @@ func (h *MessageHandler) SendVideo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verificar si se solicitó envío asíncrono
-	if r.Header.Get("X-Async") == "true" {
-		msgID, err := h.queueService.EnqueueMessage(r.Context(), instanceID, models.MessageTypeVideo, req)
-		if err != nil {
-			errors.WriteJSON(w, errors.ErrInternalServer.WithDetails("Error encolando mensaje: "+err.Error()))
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusAccepted)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success":    true,
-			"message_id": msgID,
-			"status":     "queued",
-		})
+	if h.enqueueIfAsync(w, r, instanceID, models.MessageTypeVideo, req) {
 		return
 	}
 
@@ -166,19 +190,7 @@ func (h *MessageHandler) SendAudio(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verificar si se solicitó envío asíncrono
-	if r.Header.Get("X-Async") == "true" {
-		msgID, err := h.queueService.EnqueueMessage(r.Context(), instanceID, models.MessageTypeAudio, req)
-		if err != nil {
-			errors.WriteJSON(w, errors.ErrInternalServer.WithDetails("Error encolando mensaje: "+err.Error()))
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusAccepted)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success":    true,
-			"message_id": msgID,
-			"status":     "queued",
-		})
+	if h.enqueueIfAsync(w, r, instanceID, models.MessageTypeAudio, req) {
 		return
 	}
 
@@ -207,19 +219,7 @@ func (h *MessageHandler) SendDocument(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verificar si se solicitó envío asíncrono
-	if r.Header.Get("X-Async") == "true" {
-		msgID, err := h.queueService.EnqueueMessage(r.Context(), instanceID, models.MessageTypeDocument, req)
-		if err != nil {
-			errors.WriteJSON(w, errors.ErrInternalServer.WithDetails("Error encolando mensaje: "+err.Error()))
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusAccepted)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success":    true,
-			"message_id": msgID,
-			"status":     "queued",
-		})
+	if h.enqueueIfAsync(w, r, instanceID, models.MessageTypeDocument, req) {
 		return
 	}
 
@@ -253,19 +253,7 @@ func (h *MessageHandler) SendLocation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verificar si se solicitó envío asíncrono
-	if r.Header.Get("X-Async") == "true" {
-		msgID, err := h.queueService.EnqueueMessage(r.Context(), instanceID, models.MessageTypeLocation, req)
-		if err != nil {
-			errors.WriteJSON(w, errors.ErrInternalServer.WithDetails("Error encolando mensaje: "+err.Error()))
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusAccepted)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success":    true,
-			"message_id": msgID,
-			"status":     "queued",
-		})
+	if h.enqueueIfAsync(w, r, instanceID, models.MessageTypeLocation, req) {
 		return
 	}
 
@@ -298,6 +286,11 @@ func (h *MessageHandler) SendContact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Verificar si se solicitó envío asíncrono
+	if h.enqueueIfAsync(w, r, instanceID, models.MessageTypeContact, req) {
+		return
+	}
+
 	response, err := h.service.SendContact(r.Context(), instanceID, &req)
 	if err != nil {
 		if appErr, ok := err.(*errors.AppError); ok {
@@ -321,6 +314,11 @@ func (h *MessageHandler) React(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Verificar si se solicitó envío asíncrono
+	if h.enqueueIfAsync(w, r, instanceID, models.MessageTypeReaction, req) {
+		return
+	}
+
 	resp, err := h.service.ReactToMessage(r.Context(), instanceID, &req)
 	if err != nil {
 		if appErr, ok := err.(*errors.AppError); ok {
@@ -344,6 +342,11 @@ func (h *MessageHandler) Revoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Verificar si se solicitó envío asíncrono
+	if h.enqueueIfAsync(w, r, instanceID, models.MessageTypeRevoke, req) {
+		return
+	}
+
 	resp, err := h.service.RevokeMessage(r.Context(), instanceID, &req)
 	if err != nil {
 		if appErr, ok := err.(*errors.AppError); ok {
@@ -400,6 +403,11 @@ func (h *MessageHandler) CreatePoll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Verificar si se solicitó envío asíncrono
+	if h.enqueueIfAsync(w, r, instanceID, models.MessageTypePoll, req) {
+		return
+	}
+
 	resp, err := h.service.CreatePoll(r.Context(), instanceID, &req)
 	if err != nil {
 		if appErr, ok := err.(*errors.AppError); ok {
@@ -423,6 +431,11 @@ func (h *MessageHandler) VotePoll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Verificar si se solicitó envío asíncrono
+	if h.enqueueIfAsync(w, r, instanceID, models.MessageTypePollVote, req) {
+		return
+	}
+
 	resp, err := h.service.VotePoll(r.Context(), instanceID, &req)
 	if err != nil {
 		if appErr, ok := err.(*errors.AppError); ok {
@@ -452,6 +465,11 @@ func (h *MessageHandler) SendTextWithTyping(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Verificar si se solicitó envío asíncrono
+	if h.enqueueIfAsync(w, r, instanceID, models.MessageTypeTextWithTyping, req) {
+		return
+	}
+
 	response, err := h.service.SendTextWithTyping(r.Context(), instanceID, &req)
 	if err != nil {
 		if appErr, ok := err.(*errors.AppError); ok {
@@ -481,6 +499,11 @@ func (h *MessageHandler) MarkAsRead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Verificar si se solicitó envío asíncrono
+	if h.enqueueIfAsync(w, r, instanceID, models.MessageTypeMarkRead, req) {
+		return
+	}
+
 	response, err := h.service.MarkAsRead(r.Context(), instanceID, &req)
 	if err != nil {
 		if appErr, ok := err.(*errors.AppError); ok {
@@ -510,6 +533,11 @@ func (h *MessageHandler) Edit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Verificar si se solicitó envío asíncrono
+	if h.enqueueIfAsync(w, r, instanceID, models.MessageTypeEdit, req) {
+		return
+	}
+
 	response, err := h.service.EditMessage(r.Context(), instanceID, &req)
 	if err != nil {
 		if appErr, ok := err.(*errors.AppError); ok {
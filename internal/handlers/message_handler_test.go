@@ -0,0 +1,56 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"kero-kero/internal/handlers"
+	"kero-kero/internal/models"
+	"kero-kero/internal/repository"
+	"kero-kero/internal/routes"
+	"kero-kero/internal/services"
+	"kero-kero/internal/testutil"
+)
+
+func TestMessageRoutes_AsyncContact(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	queueService := services.NewQueueService(&repository.RedisClient{Client: redisClient}, nil)
+	r := chi.NewRouter()
+	routes.SetupMessageRoutes(r, handlers.NewMessageHandler(nil, queueService))
+
+	body, err := json.Marshal(models.SendContactRequest{
+		Phone:       "5215512345678",
+		DisplayName: "Ana",
+		VCard:       "BEGIN:VCARD\nVERSION:3.0\nFN:Ana\nTEL:+5215511111111\nEND:VCARD",
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/instances/ventas/messages/contact", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Async", "true")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var response struct {
+		Success   bool   `json:"success"`
+		MessageID string `json:"message_id"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.True(t, response.Success)
+
+	item, err := queueService.GetQueueItem(req.Context(), response.MessageID)
+	require.NoError(t, err)
+	assert.Equal(t, models.MessageTypeContact, item.Type)
+	assert.Equal(t, "ventas", item.InstanceID)
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"kero-kero/internal/models"
+	"kero-kero/internal/services"
+	"kero-kero/pkg/errors"
+)
+
+// QueueHandler expone el estado de las colas de envío asíncrono
+type QueueHandler struct {
+	service *services.QueueService
+}
+
+func NewQueueHandler(service *services.QueueService) *QueueHandler {
+	return &QueueHandler{service: service}
+}
+
+// Stats maneja GET /queue/stats
+func (h *QueueHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	depths, err := h.service.QueueDepths(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	var total int64
+	for _, depth := range depths {
+		total += depth
+	}
+
+	delayed, err := h.service.DelayedRetries(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"instances": depths,
+		"total":     total,
+		"delayed":   delayed,
+	})
+}
+
+// Workers maneja GET /queue/workers
+func (h *QueueHandler) Workers(w http.ResponseWriter, r *http.Request) {
+	workers, err := h.service.Workers(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    workers,
+	})
+}
+
+// ScaleWorkers maneja PUT /queue/workers
+func (h *QueueHandler) ScaleWorkers(w http.ResponseWriter, r *http.Request) {
+	var req models.SetQueueWorkersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.WriteJSON(w, errors.ErrBadRequest.WithDetails("JSON inválido"))
+		return
+	}
+
+	workers, err := h.service.ScaleWorkers(r.Context(), req.Workers)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    workers,
+	})
+}
+
+// ResetWorkers maneja DELETE /queue/workers
+func (h *QueueHandler) ResetWorkers(w http.ResponseWriter, r *http.Request) {
+	workers, err := h.service.ResetWorkers(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    workers,
+	})
+}
+
+// Depth maneja GET /instances/{instanceID}/queue/depth
+func (h *QueueHandler) Depth(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+
+	lanes, err := h.service.QueueLaneDepths(r.Context(), instanceID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	var depth int64
+	for _, n := range lanes {
+		depth += n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"instance_id": instanceID,
+		"depth":       depth,
+		"priorities":  lanes,
+	})
+}
+
+// List maneja GET /instances/{instanceID}/queue
+func (h *QueueHandler) List(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+	query := r.URL.Query()
+
+	var statuses []string
+	if status := query.Get("status"); status != "" {
+		statuses = strings.Split(status, ",")
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	items, total, err := h.service.ListQueue(r.Context(), instanceID, statuses, limit, offset)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    items,
+		"total":   total,
+	})
+}
+
+// Get maneja GET /queue/{msgID}
+func (h *QueueHandler) Get(w http.ResponseWriter, r *http.Request) {
+	item, err := h.service.GetQueueItem(r.Context(), chi.URLParam(r, "msgID"))
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    item,
+	})
+}
+
+// Cancel maneja DELETE /queue/{msgID}
+func (h *QueueHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	item, err := h.service.CancelQueueItem(r.Context(), chi.URLParam(r, "msgID"))
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    item,
+	})
+}
+
+// ListDeadLetters maneja GET /instances/{instanceID}/queue/dead-letters
+func (h *QueueHandler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	messages, err := h.service.ListDeadLetters(r.Context(), instanceID, limit)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    messages,
+		"total":   len(messages),
+	})
+}
+
+// PurgeDeadLetters maneja DELETE /instances/{instanceID}/queue/dead-letters
+func (h *QueueHandler) PurgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+
+	if err := h.service.PurgeDeadLetters(r.Context(), instanceID); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// RequeueDeadLetters maneja POST /instances/{instanceID}/queue/dead-letters/requeue
+func (h *QueueHandler) RequeueDeadLetters(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+
+	var req models.RequeueDeadLettersRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errors.WriteJSON(w, errors.ErrBadRequest.WithDetails("JSON inválido"))
+			return
+		}
+	}
+
+	n, err := h.service.RequeueDeadLetters(r.Context(), instanceID, req.IDs)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"requeued": n,
+	})
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"kero-kero/internal/models"
+	"kero-kero/internal/services"
+	"kero-kero/pkg/errors"
+)
+
+// RatePolicyHandler administra la política de envío de cada instancia
+type RatePolicyHandler struct {
+	service *services.RatePolicyService
+}
+
+func NewRatePolicyHandler(service *services.RatePolicyService) *RatePolicyHandler {
+	return &RatePolicyHandler{service: service}
+}
+
+// Get maneja GET /instances/{instanceID}/rate-policy
+func (h *RatePolicyHandler) Get(w http.ResponseWriter, r *http.Request) {
+	policy, err := h.service.GetPolicy(r.Context(), chi.URLParam(r, "instanceID"))
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    policy,
+	})
+}
+
+// Set maneja PUT /instances/{instanceID}/rate-policy
+func (h *RatePolicyHandler) Set(w http.ResponseWriter, r *http.Request) {
+	var policy models.RatePolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		errors.WriteJSON(w, errors.ErrBadRequest.WithDetails("JSON inválido"))
+		return
+	}
+
+	saved, err := h.service.SetPolicy(r.Context(), chi.URLParam(r, "instanceID"), &policy)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    saved,
+	})
+}
+
+// Delete maneja DELETE /instances/{instanceID}/rate-policy
+func (h *RatePolicyHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.DeletePolicy(r.Context(), chi.URLParam(r, "instanceID")); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
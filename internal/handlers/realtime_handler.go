@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"kero-kero/internal/services"
+)
+
+// RealtimeHandler expone el estado de las conexiones WebSocket y SSE
+type RealtimeHandler struct {
+	service *services.WebSocketService
+}
+
+func NewRealtimeHandler(service *services.WebSocketService) *RealtimeHandler {
+	return &RealtimeHandler{service: service}
+}
+
+// Stats maneja GET /realtime/stats
+func (h *RealtimeHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.service.Stats())
+}
@@ -3,6 +3,8 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
@@ -19,7 +21,8 @@ func NewWebhookHandler(service *services.WebhookService) *WebhookHandler {
 	return &WebhookHandler{service: service}
 }
 
-func (h *WebhookHandler) SetWebhook(w http.ResponseWriter, r *http.Request) {
+// CreateWebhook maneja POST /instances/{instanceID}/webhook
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
 	instanceID := chi.URLParam(r, "instanceID")
 
 	var config models.WebhookConfig
@@ -29,7 +32,42 @@ func (h *WebhookHandler) SetWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	config.InstanceID = instanceID
-	if err := h.service.SetWebhook(r.Context(), &config); err != nil {
+	if err := h.service.CreateWebhook(r.Context(), &config); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    config,
+	})
+}
+
+// ListWebhooks maneja GET /instances/{instanceID}/webhook
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+
+	configs, err := h.service.ListWebhooks(r.Context(), instanceID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    configs,
+		"total":   len(configs),
+	})
+}
+
+// DeleteWebhooks maneja DELETE /instances/{instanceID}/webhook
+func (h *WebhookHandler) DeleteWebhooks(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+
+	if err := h.service.DeleteWebhooks(r.Context(), instanceID); err != nil {
 		handleError(w, err)
 		return
 	}
@@ -38,10 +76,12 @@ func (h *WebhookHandler) SetWebhook(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
+// GetWebhook maneja GET /instances/{instanceID}/webhook/{webhookID}
 func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
 	instanceID := chi.URLParam(r, "instanceID")
+	webhookID := chi.URLParam(r, "webhookID")
 
-	config, err := h.service.GetWebhook(r.Context(), instanceID)
+	config, err := h.service.GetWebhook(r.Context(), instanceID, webhookID)
 	if err != nil {
 		handleError(w, err)
 		return
@@ -51,10 +91,290 @@ func (h *WebhookHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(config)
 }
 
+// UpdateWebhook maneja PUT /instances/{instanceID}/webhook/{webhookID}
+func (h *WebhookHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+	webhookID := chi.URLParam(r, "webhookID")
+
+	var req models.UpdateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.WriteJSON(w, errors.ErrBadRequest.WithDetails("JSON inválido"))
+		return
+	}
+
+	config, err := h.service.UpdateWebhook(r.Context(), instanceID, webhookID, &req)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    config,
+	})
+}
+
+// RotateWebhookSecret maneja POST /instances/{instanceID}/webhook/{webhookID}/rotate-secret
+func (h *WebhookHandler) RotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+	webhookID := chi.URLParam(r, "webhookID")
+
+	var req models.RotateWebhookSecretRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errors.WriteJSON(w, errors.ErrBadRequest.WithDetails("JSON inválido"))
+			return
+		}
+	}
+
+	config, err := h.service.RotateWebhookSecret(r.Context(), instanceID, webhookID, &req)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    config,
+	})
+}
+
+// DeleteWebhook maneja DELETE /instances/{instanceID}/webhook/{webhookID}
 func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
 	instanceID := chi.URLParam(r, "instanceID")
+	webhookID := chi.URLParam(r, "webhookID")
+
+	if err := h.service.DeleteWebhook(r.Context(), instanceID, webhookID); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// ListDeadLetters maneja GET /instances/{instanceID}/webhook/dead-letters
+func (h *WebhookHandler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	deliveries, err := h.service.ListDeadLetters(r.Context(), instanceID, limit)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    deliveries,
+		"total":   len(deliveries),
+	})
+}
+
+// PurgeDeadLetters maneja DELETE /instances/{instanceID}/webhook/dead-letters
+func (h *WebhookHandler) PurgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+
+	if err := h.service.PurgeDeadLetters(r.Context(), instanceID); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// ListDeliveries maneja GET /instances/{instanceID}/webhook/deliveries
+// Filtros opcionales: webhook_id, event, status, from, to (RFC3339), limit, offset
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+	query := r.URL.Query()
+
+	filter := models.WebhookDeliveryFilter{
+		InstanceID: instanceID,
+		WebhookID:  query.Get("webhook_id"),
+		Event:      query.Get("event"),
+		Status:     query.Get("status"),
+	}
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+	filter.Offset, _ = strconv.Atoi(query.Get("offset"))
+
+	var err error
+	if filter.From, err = parseTimeParam(query.Get("from")); err != nil {
+		errors.WriteJSON(w, errors.ErrBadRequest.WithDetails("from debe tener formato RFC3339"))
+		return
+	}
+	if filter.To, err = parseTimeParam(query.Get("to")); err != nil {
+		errors.WriteJSON(w, errors.ErrBadRequest.WithDetails("to debe tener formato RFC3339"))
+		return
+	}
+
+	entries, err := h.service.ListDeliveries(r.Context(), filter)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    entries,
+		"total":   len(entries),
+	})
+}
+
+// GetDelivery maneja GET /instances/{instanceID}/webhook/deliveries/{logID}
+func (h *WebhookHandler) GetDelivery(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+	logID := chi.URLParam(r, "logID")
+
+	entry, err := h.service.GetDelivery(r.Context(), instanceID, logID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    entry,
+	})
+}
+
+// ReplayDelivery maneja POST /instances/{instanceID}/webhook/deliveries/{logID}/replay
+func (h *WebhookHandler) ReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+	logID := chi.URLParam(r, "logID")
+
+	delivery, err := h.service.ReplayDelivery(r.Context(), instanceID, logID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    delivery,
+	})
+}
+
+// ReplayDeliveries maneja POST /instances/{instanceID}/webhook/deliveries/replay
+// Reenvía todas las entregas fallidas en el rango indicado
+func (h *WebhookHandler) ReplayDeliveries(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+
+	var req models.ReplayWebhookDeliveriesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.WriteJSON(w, errors.ErrBadRequest.WithDetails("JSON inválido"))
+		return
+	}
+
+	replayed, err := h.service.ReplayFailed(r.Context(), instanceID, &req)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"replayed": replayed,
+	})
+}
+
+// parseTimeParam interpreta un parámetro de fecha RFC3339 (vacío = sin filtro)
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// CreateGlobalWebhook maneja POST /webhooks/global
+func (h *WebhookHandler) CreateGlobalWebhook(w http.ResponseWriter, r *http.Request) {
+	var config models.WebhookConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		errors.WriteJSON(w, errors.ErrBadRequest.WithDetails("JSON inválido"))
+		return
+	}
+
+	if err := h.service.CreateGlobalWebhook(r.Context(), &config); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    config,
+	})
+}
+
+// ListGlobalWebhooks maneja GET /webhooks/global
+func (h *WebhookHandler) ListGlobalWebhooks(w http.ResponseWriter, r *http.Request) {
+	configs, err := h.service.ListGlobalWebhooks(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    configs,
+		"total":   len(configs),
+	})
+}
+
+// GetGlobalWebhook maneja GET /webhooks/global/{webhookID}
+func (h *WebhookHandler) GetGlobalWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookID := chi.URLParam(r, "webhookID")
+
+	config, err := h.service.GetGlobalWebhook(r.Context(), webhookID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// UpdateGlobalWebhook maneja PUT /webhooks/global/{webhookID}
+func (h *WebhookHandler) UpdateGlobalWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookID := chi.URLParam(r, "webhookID")
+
+	var req models.UpdateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.WriteJSON(w, errors.ErrBadRequest.WithDetails("JSON inválido"))
+		return
+	}
+
+	config, err := h.service.UpdateGlobalWebhook(r.Context(), webhookID, &req)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    config,
+	})
+}
+
+// DeleteGlobalWebhook maneja DELETE /webhooks/global/{webhookID}
+func (h *WebhookHandler) DeleteGlobalWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookID := chi.URLParam(r, "webhookID")
 
-	if err := h.service.DeleteWebhook(r.Context(), instanceID); err != nil {
+	if err := h.service.DeleteGlobalWebhook(r.Context(), webhookID); err != nil {
 		handleError(w, err)
 		return
 	}
@@ -18,7 +18,7 @@ import (
 	"kero-kero/internal/testutil"
 )
 
-func TestWebhookHandler_SetWebhook(t *testing.T) {
+func TestWebhookHandler_CreateWebhook(t *testing.T) {
 	// Setup
 	mr, redisClient := testutil.NewMockRedis(t)
 	defer testutil.CleanupRedis(t, mr, redisClient)
@@ -48,14 +48,19 @@ func TestWebhookHandler_SetWebhook(t *testing.T) {
 		req = req.WithContext(ctx)
 
 		w := httptest.NewRecorder()
-		handler.SetWebhook(w, req)
+		handler.CreateWebhook(w, req)
 
-		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, http.StatusCreated, w.Code)
 
-		var response map[string]bool
+		var response struct {
+			Success bool                 `json:"success"`
+			Data    models.WebhookConfig `json:"data"`
+		}
 		err = json.NewDecoder(w.Body).Decode(&response)
 		require.NoError(t, err)
-		assert.True(t, response["success"])
+		assert.True(t, response.Success)
+		assert.NotEmpty(t, response.Data.ID)
+		assert.Equal(t, "test-instance", response.Data.InstanceID)
 	})
 
 	t.Run("error con JSON inválido", func(t *testing.T) {
@@ -68,13 +73,13 @@ func TestWebhookHandler_SetWebhook(t *testing.T) {
 		req = req.WithContext(ctx)
 
 		w := httptest.NewRecorder()
-		handler.SetWebhook(w, req)
+		handler.CreateWebhook(w, req)
 
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 }
 
-func TestWebhookHandler_GetWebhook(t *testing.T) {
+func TestWebhookHandler_ListWebhooks(t *testing.T) {
 	mr, redisClient := testutil.NewMockRedis(t)
 	defer testutil.CleanupRedis(t, mr, redisClient)
 
@@ -83,14 +88,15 @@ func TestWebhookHandler_GetWebhook(t *testing.T) {
 	service := services.NewWebhookService(webhookRepo)
 	handler := NewWebhookHandler(service)
 
-	// Crear webhook primero
-	config := &models.WebhookConfig{
-		InstanceID: "test-instance",
-		URL:        "https://example.com/webhook",
-		Events:     []string{"message"},
+	// Crear webhooks primero
+	for _, url := range []string{"https://example.com/webhook", "https://example.com/other"} {
+		err := service.CreateWebhook(context.Background(), &models.WebhookConfig{
+			InstanceID: "test-instance",
+			URL:        url,
+			Events:     []string{"message"},
+		})
+		require.NoError(t, err)
 	}
-	err := service.SetWebhook(context.Background(), config)
-	require.NoError(t, err)
 
 	req := httptest.NewRequest("GET", "/instances/test-instance/webhook", nil)
 	rctx := chi.NewRouteContext()
@@ -99,14 +105,70 @@ func TestWebhookHandler_GetWebhook(t *testing.T) {
 	req = req.WithContext(ctx)
 
 	w := httptest.NewRecorder()
-	handler.GetWebhook(w, req)
+	handler.ListWebhooks(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	var response models.WebhookConfig
-	err = json.NewDecoder(w.Body).Decode(&response)
+	var response struct {
+		Data  []models.WebhookConfig `json:"data"`
+		Total int                    `json:"total"`
+	}
+	err := json.NewDecoder(w.Body).Decode(&response)
 	require.NoError(t, err)
-	assert.Equal(t, config.URL, response.URL)
+	assert.Equal(t, 2, response.Total)
+	assert.Equal(t, "https://example.com/webhook", response.Data[0].URL)
+}
+
+func TestWebhookHandler_GetWebhook(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	redisRepo := &repository.RedisClient{Client: redisClient}
+	webhookRepo := repository.NewWebhookRepository(redisRepo)
+	service := services.NewWebhookService(webhookRepo)
+	handler := NewWebhookHandler(service)
+
+	// Crear webhook primero
+	config := &models.WebhookConfig{
+		InstanceID: "test-instance",
+		URL:        "https://example.com/webhook",
+		Events:     []string{"message"},
+	}
+	err := service.CreateWebhook(context.Background(), config)
+	require.NoError(t, err)
+
+	t.Run("obtener suscripción existente", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/instances/test-instance/webhook/"+config.ID, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("instanceID", "test-instance")
+		rctx.URLParams.Add("webhookID", config.ID)
+		ctx := context.WithValue(req.Context(), chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		w := httptest.NewRecorder()
+		handler.GetWebhook(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response models.WebhookConfig
+		err = json.NewDecoder(w.Body).Decode(&response)
+		require.NoError(t, err)
+		assert.Equal(t, config.URL, response.URL)
+	})
+
+	t.Run("404 si la suscripción no existe", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/instances/test-instance/webhook/missing", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("instanceID", "test-instance")
+		rctx.URLParams.Add("webhookID", "missing")
+		ctx := context.WithValue(req.Context(), chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		w := httptest.NewRecorder()
+		handler.GetWebhook(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
 }
 
 func TestWebhookHandler_DeleteWebhook(t *testing.T) {
@@ -124,12 +186,13 @@ func TestWebhookHandler_DeleteWebhook(t *testing.T) {
 		URL:        "https://example.com/webhook",
 		Events:     []string{"message"},
 	}
-	err := service.SetWebhook(context.Background(), config)
+	err := service.CreateWebhook(context.Background(), config)
 	require.NoError(t, err)
 
-	req := httptest.NewRequest("DELETE", "/instances/test-instance/webhook", nil)
+	req := httptest.NewRequest("DELETE", "/instances/test-instance/webhook/"+config.ID, nil)
 	rctx := chi.NewRouteContext()
 	rctx.URLParams.Add("instanceID", "test-instance")
+	rctx.URLParams.Add("webhookID", config.ID)
 	ctx := context.WithValue(req.Context(), chi.RouteCtxKey, rctx)
 	req = req.WithContext(ctx)
 
@@ -142,4 +205,7 @@ func TestWebhookHandler_DeleteWebhook(t *testing.T) {
 	err = json.NewDecoder(w.Body).Decode(&response)
 	require.NoError(t, err)
 	assert.True(t, response["success"])
+
+	_, err = service.GetWebhook(context.Background(), "test-instance", config.ID)
+	assert.Error(t, err)
 }
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Estados de una clave de idempotencia
+const (
+	IdempotencyProcessing = "processing" // La primera petición con la clave todavía se está atendiendo
+	IdempotencyCompleted  = "completed"  // Hay una respuesta guardada para repetir
+)
+
+// IdempotencyRecord respuesta guardada para una clave Idempotency-Key de una instancia
+type IdempotencyRecord struct {
+	Status      string    `json:"status"`
+	Fingerprint string    `json:"fingerprint"` // Hash del método, la ruta y el cuerpo de la primera petición
+	StatusCode  int       `json:"status_code,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Body        []byte    `json:"body,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -29,6 +29,14 @@ const (
 	MessageTypeLocation MessageType = "location"
 	MessageTypeContact  MessageType = "contact"
 	MessageTypeReaction MessageType = "reaction"
+
+	// Acciones que también se pueden encolar con X-Async
+	MessageTypeTextWithTyping MessageType = "text_with_typing"
+	MessageTypePoll           MessageType = "poll"
+	MessageTypePollVote       MessageType = "poll_vote"
+	MessageTypeRevoke         MessageType = "revoke"
+	MessageTypeEdit           MessageType = "edit"
+	MessageTypeMarkRead       MessageType = "mark_read"
 )
 
 // MessageStatus representa los estados de un mensaje
@@ -90,6 +90,15 @@ func TestWebhookEvent(t *testing.T) {
 	assert.NotNil(t, event.Data)
 }
 
+func TestNewEventID(t *testing.T) {
+	id := NewEventID("test-instance", "message", "3EB0ABC")
+
+	assert.Len(t, id, 32)
+	assert.Equal(t, id, NewEventID("test-instance", "message", "3EB0ABC"))
+	assert.NotEqual(t, id, NewEventID("test-instance", "receipt", "3EB0ABC"))
+	assert.NotEqual(t, id, NewEventID("other-instance", "message", "3EB0ABC"))
+}
+
 func TestMessageEvent(t *testing.T) {
 	msg := MessageEvent{
 		MessageID:   "msg-123",
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // QueuedMessage representa un mensaje encolado en Redis
 type QueuedMessage struct {
 	ID         string      `json:"id"`
@@ -8,8 +10,109 @@ type QueuedMessage struct {
 	Payload    interface{} `json:"payload"`
 	CreatedAt  int64       `json:"created_at"`
 	Attempts   int         `json:"attempts"`
+	Priority   string      `json:"priority,omitempty"`   // high, normal (default) o bulk
+	ExpiresAt  int64       `json:"expires_at,omitempty"` // Unix; si vence antes de enviarse se descarta
+
+	// Intentos fallidos; se conservan al pasar a dead-letter y al re-encolar desde allí
+	History   []QueueAttempt `json:"history,omitempty"`
+	LastError string         `json:"last_error,omitempty"`
+	FailedAt  int64          `json:"failed_at,omitempty"` // Momento en que pasó a dead-letter
+}
+
+// Expired indica si el mensaje venció sin enviarse
+func (m *QueuedMessage) Expired(now time.Time) bool {
+	return m.ExpiresAt > 0 && now.Unix() >= m.ExpiresAt
+}
+
+// Prioridades de la cola: los workers atienden high antes que normal y normal antes que bulk
+const (
+	QueuePriorityHigh   = "high"   // OTP y notificaciones transaccionales
+	QueuePriorityNormal = "normal" // Default
+	QueuePriorityBulk   = "bulk"   // Campañas y envíos masivos
+)
+
+// QueuePriorityOrDefault prioridad efectiva: vacía equivale a normal
+func QueuePriorityOrDefault(priority string) string {
+	if priority == "" {
+		return QueuePriorityNormal
+	}
+	return priority
+}
+
+// QueueOptions opciones de un envío asíncrono (headers X-Priority, X-Expires-At y X-TTL)
+type QueueOptions struct {
+	Priority  string
+	ExpiresAt time.Time // Cero = no vence
+}
+
+// QueueAttempt intento de envío fallido de un mensaje de la cola
+type QueueAttempt struct {
+	Attempt int    `json:"attempt"`
+	Error   string `json:"error"`
+	At      int64  `json:"at"`
 }
 
 // QueueMessagePayloads wrappers para serializar diferentes tipos de request
 // Nota: Usamos interface{} en QueuedMessage.Payload, y al deserializar
 // checkeamos el Type para convertir al request concreto.
+
+// Estados de un mensaje de la cola de envío asíncrono
+const (
+	QueueStatusPending    = "pending"    // En la cola de su instancia (o esperando un reintento)
+	QueueStatusProcessing = "processing" // Un worker lo está enviando
+	QueueStatusSent       = "sent"       // Enviado; WhatsAppMessageID tiene el ID del mensaje
+	QueueStatusFailed     = "failed"     // Agotó los reintentos
+	QueueStatusCancelled  = "cancelled"  // Cancelado antes de enviarse
+	QueueStatusExpired    = "expired"    // Venció (expires_at) antes de enviarse y se descartó
+)
+
+// QueueItem estado de un mensaje encolado con X-Async
+type QueueItem struct {
+	ID                string      `json:"id"`
+	InstanceID        string      `json:"instance_id"`
+	Type              MessageType `json:"type"`
+	Status            string      `json:"status"`
+	Priority          string      `json:"priority"`
+	Attempts          int         `json:"attempts"` // Intentos de envío realizados
+	LastError         string      `json:"last_error,omitempty"`
+	WhatsAppMessageID string      `json:"whatsapp_message_id,omitempty"`
+	ExpiresAt         *time.Time  `json:"expires_at,omitempty"`
+	CreatedAt         time.Time   `json:"created_at"`
+	UpdatedAt         time.Time   `json:"updated_at"`
+}
+
+// Eventos de webhook de la cola de envío asíncrono
+const (
+	QueueEventSent    = "queue.sent"    // El mensaje se envió; incluye el ID de WhatsApp
+	QueueEventFailed  = "queue.failed"  // El mensaje agotó sus reintentos y pasó a dead-letter
+	QueueEventExpired = "queue.expired" // El mensaje venció antes de enviarse y se descartó
+)
+
+// QueueEvent datos de los eventos de webhook de la cola
+type QueueEvent struct {
+	ID                string         `json:"id"` // ID devuelto al encolar (msg_...)
+	Type              MessageType    `json:"type"`
+	Status            string         `json:"status"`
+	Attempts          int            `json:"attempts"`
+	LastError         string         `json:"last_error,omitempty"`
+	History           []QueueAttempt `json:"history,omitempty"`
+	WhatsAppMessageID string         `json:"whatsapp_message_id,omitempty"`
+}
+
+// RequeueDeadLettersRequest re-encola mensajes de la dead-letter de una instancia
+type RequeueDeadLettersRequest struct {
+	IDs []string `json:"ids,omitempty"` // Opcional: sin IDs se re-encolan todos
+}
+
+// QueueWorkers workers que atienden la cola de envío en cada réplica
+type QueueWorkers struct {
+	Workers  int  `json:"workers"`  // Workers por réplica que se aplican
+	Default  int  `json:"default"`  // Valor de la configuración (QUEUE_WORKERS)
+	Override bool `json:"override"` // Fijado por API; se aplica a todas las réplicas
+	Running  int  `json:"running"`  // Workers activos en la réplica que respondió
+}
+
+// SetQueueWorkersRequest cambia los workers por réplica en caliente
+type SetQueueWorkersRequest struct {
+	Workers int `json:"workers"`
+}
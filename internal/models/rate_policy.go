@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// RatePolicy política de envío de una instancia. Se aplica a los envíos síncronos y a los de la
+// cola (X-Async). Un límite en 0 no se aplica.
+type RatePolicy struct {
+	PerMinute  int          `json:"per_minute"`
+	PerHour    int          `json:"per_hour"`
+	PerDay     int          `json:"per_day"`            // El día empieza a medianoche en la zona horaria de la instancia
+	Burst      int          `json:"burst"`              // Envíos seguidos permitidos; luego se espacian al ritmo del límite más corto
+	Timezone   string       `json:"timezone,omitempty"` // Zona IANA (America/Mexico_City); default UTC
+	QuietHours []QuietHours `json:"quiet_hours,omitempty"`
+	UpdatedAt  *time.Time   `json:"updated_at,omitempty"`
+}
+
+// QuietHours ventana diaria en la que la instancia no envía mensajes.
+// Si End es anterior a Start la ventana cruza la medianoche (22:00 a 07:00).
+type QuietHours struct {
+	Start string   `json:"start"`          // HH:MM
+	End   string   `json:"end"`            // HH:MM
+	Days  []string `json:"days,omitempty"` // mon, tue, wed, thu, fri, sat, sun; vacío = todos los días
+}
@@ -1,26 +1,185 @@
 package models
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
 
-// WebhookConfig configuración de webhook para una instancia
+// WebhookConfig suscripción de webhook de una instancia.
+// Una instancia puede tener varias, cada una con su URL y filtro de eventos.
 type WebhookConfig struct {
-	InstanceID string    `json:"instance_id"`
-	URL        string    `json:"url" validate:"required,url"`
-	Events     []string  `json:"events" validate:"required"` // message, status, receipt, etc.
-	Secret     string    `json:"secret,omitempty"`           // Para firmar requests
-	Enabled    bool      `json:"enabled"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID         string           `json:"id"`
+	InstanceID string           `json:"instance_id"`
+	URL        string           `json:"url" validate:"required,url"`
+	Events     []string         `json:"events" validate:"required"` // message, status, receipt, etc.
+	Secret     string           `json:"secret,omitempty"`           // Para firmar requests
+	Enabled    bool             `json:"enabled"`
+	Global     bool             `json:"global,omitempty"` // Recibe los eventos de todas las instancias
+	Filters    *WebhookFilters  `json:"filters,omitempty"`
+	Format     string           `json:"format,omitempty"`   // native (default), slack, chatwoot, form, template
+	Template   *WebhookTemplate `json:"template,omitempty"` // Requerido con format "template"
+	Batch      *WebhookBatch    `json:"batch,omitempty"`    // Agrupa los eventos en un array por petición
+	CreatedAt  time.Time        `json:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+
+	// Secreto anterior, se sigue firmando con él hasta que vence la rotación
+	PreviousSecret          string     `json:"previous_secret,omitempty"`
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+
+	// Estado del circuit breaker; se guarda aparte y solo se completa al consultar la suscripción
+	Circuit *WebhookCircuit `json:"circuit,omitempty"`
+}
+
+// Formatos del cuerpo que se envía a una suscripción
+const (
+	WebhookFormatNative   = "native"   // WebhookEvent en JSON
+	WebhookFormatSlack    = "slack"    // Incoming webhook de Slack (text + blocks)
+	WebhookFormatChatwoot = "chatwoot" // Estilo de los webhooks message_created de Chatwoot
+	WebhookFormatForm     = "form"     // application/x-www-form-urlencoded con claves planas (data.text)
+	WebhookFormatTemplate = "template" // Plantilla text/template definida por el usuario
+)
+
+// WebhookTemplate plantillas text/template para el cuerpo y los headers.
+// Reciben el evento como mapa: {{.event}}, {{.instance_id}}, {{.data.text}}.
+type WebhookTemplate struct {
+	Body    string            `json:"body"`
+	Headers map[string]string `json:"headers,omitempty"` // Valor de cada header; también son plantillas
+}
+
+// WebhookBatch modo de entrega por lotes de una suscripción.
+// El lote se envía al llegar a MaxSize eventos o cuando el primero lleva MaxLingerMs esperando.
+type WebhookBatch struct {
+	MaxSize     int `json:"max_size"`
+	MaxLingerMs int `json:"max_linger_ms"`
+}
+
+// WebhookEventBatch nombre del evento de una entrega que agrupa varios eventos
+const WebhookEventBatch = "batch"
+
+// WebhookFilters filtros declarativos de una suscripción, aplicados a los eventos "message".
+// Un campo vacío no filtra; las listas aceptan cualquiera de sus valores.
+type WebhookFilters struct {
+	ChatTypes    []string `json:"chat_types,omitempty"`    // private, group, channel, status
+	ChatJIDs     []string `json:"chat_jids,omitempty"`     // JIDs o números de los chats
+	SenderJIDs   []string `json:"sender_jids,omitempty"`   // JIDs o números de los remitentes
+	MessageTypes []string `json:"message_types,omitempty"` // text, image, video, audio, document, location
+	IsFromMe     *bool    `json:"is_from_me,omitempty"`    // true: solo enviados por la instancia; false: solo recibidos
+	IncludeMedia *bool    `json:"include_media,omitempty"` // false: se omite media_data y se envía media_url (default true)
+}
+
+// Estados del circuit breaker de una suscripción
+const (
+	WebhookCircuitClosed = "closed" // Las entregas fluyen normalmente
+	WebhookCircuitOpen   = "open"   // El receptor está caído: las entregas esperan y se sondea periódicamente
+)
+
+// WebhookCircuit estado del circuit breaker de una suscripción de webhook
+type WebhookCircuit struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	NextProbeAt         *time.Time `json:"next_probe_at,omitempty"` // Próximo intento de sondeo mientras está abierto
+}
+
+// RotateWebhookSecretRequest rotación del secreto de una suscripción
+type RotateWebhookSecretRequest struct {
+	Secret      string `json:"secret,omitempty"`       // Nuevo secreto; si se omite se genera uno
+	GracePeriod int    `json:"grace_period,omitempty"` // Segundos que sigue activo el secreto anterior (default 24h)
+}
+
+// UpdateWebhookRequest actualización parcial de una suscripción de webhook
+type UpdateWebhookRequest struct {
+	URL      *string          `json:"url,omitempty"`
+	Events   []string         `json:"events,omitempty"`
+	Secret   *string          `json:"secret,omitempty"`
+	Enabled  *bool            `json:"enabled,omitempty"`
+	Filters  *WebhookFilters  `json:"filters,omitempty"` // Reemplaza los filtros; {} los elimina
+	Format   *string          `json:"format,omitempty"`
+	Template *WebhookTemplate `json:"template,omitempty"`
+	Batch    *WebhookBatch    `json:"batch,omitempty"` // {} desactiva el modo por lotes
 }
 
 // WebhookEvent evento que se envía al webhook
 type WebhookEvent struct {
+	ID         string      `json:"id,omitempty"` // Estable entre reenvíos del mismo evento (ver NewEventID)
 	InstanceID string      `json:"instance_id"`
 	Event      string      `json:"event"`
 	Timestamp  int64       `json:"timestamp"`
 	Data       interface{} `json:"data"`
 }
 
+// NewEventID genera un ID de evento determinista a partir de la instancia, el tipo de evento
+// y el ID de origen en WhatsApp, para que los receptores puedan descartar duplicados.
+func NewEventID(instanceID, event, sourceID string) string {
+	sum := sha256.Sum256([]byte(instanceID + "\x00" + event + "\x00" + sourceID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// WebhookDelivery entrega persistida de un evento de webhook.
+// Se guarda en Redis hasta que el receptor la confirma o se agotan los reintentos.
+type WebhookDelivery struct {
+	ID            string          `json:"id"`
+	InstanceID    string          `json:"instance_id"`
+	WebhookID     string          `json:"webhook_id"`
+	Global        bool            `json:"global,omitempty"` // WebhookID es una suscripción global
+	Event         string          `json:"event"`
+	EventID       string          `json:"event_id,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"last_error,omitempty"`
+	LastStatus    int             `json:"last_status,omitempty"` // Último código HTTP recibido
+	CreatedAt     int64           `json:"created_at"`
+	NextAttemptAt int64           `json:"next_attempt_at,omitempty"`
+	FailedAt      int64           `json:"failed_at,omitempty"` // Momento en que pasó a dead-letter
+}
+
+// Estados de un intento registrado en el historial de entregas
+const (
+	WebhookDeliveryDelivered = "delivered" // El receptor respondió 2xx
+	WebhookDeliveryRetrying  = "retrying"  // Falló y se programó un reintento
+	WebhookDeliveryFailed    = "failed"    // Falló definitivamente (pasó a dead-letter)
+)
+
+// WebhookDeliveryLog intento de entrega registrado en el historial de la instancia
+type WebhookDeliveryLog struct {
+	ID         string          `json:"id"`
+	DeliveryID string          `json:"delivery_id"` // Entrega a la que pertenece el intento
+	InstanceID string          `json:"instance_id"`
+	WebhookID  string          `json:"webhook_id"`
+	Global     bool            `json:"global,omitempty"`
+	Event      string          `json:"event"`
+	URL        string          `json:"url"`
+	Payload    json.RawMessage `json:"payload"`
+	Status     string          `json:"status"`                // delivered, retrying, failed
+	StatusCode int             `json:"status_code,omitempty"` // Código HTTP recibido (0 si no hubo respuesta)
+	LatencyMs  int64           `json:"latency_ms"`
+	Error      string          `json:"error,omitempty"`
+	Attempt    int             `json:"attempt"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// WebhookDeliveryFilter filtros para consultar el historial de entregas
+type WebhookDeliveryFilter struct {
+	InstanceID string
+	WebhookID  string
+	Event      string
+	Status     string
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+}
+
+// ReplayWebhookDeliveriesRequest reenvío de las entregas fallidas en un rango de tiempo
+type ReplayWebhookDeliveriesRequest struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	WebhookID string    `json:"webhook_id,omitempty"` // Opcional: solo una suscripción
+}
+
 // MessageEvent datos de un mensaje recibido
 type MessageEvent struct {
 	MessageID       string `json:"message_id"`
@@ -216,6 +216,32 @@ func (d *Database) getSQLiteMigrations() []migration {
 			name: "add_push_name_to_messages",
 			sql:  `ALTER TABLE messages ADD COLUMN push_name TEXT`,
 		},
+		{
+			name: "create_webhook_deliveries",
+			sql: `CREATE TABLE IF NOT EXISTS webhook_deliveries (
+				id TEXT PRIMARY KEY,
+				delivery_id TEXT NOT NULL,
+				instance_id TEXT NOT NULL,
+				webhook_id TEXT NOT NULL,
+				event TEXT NOT NULL,
+				url TEXT,
+				payload TEXT,
+				status TEXT NOT NULL,
+				status_code INTEGER DEFAULT 0,
+				latency_ms INTEGER DEFAULT 0,
+				error TEXT,
+				attempt INTEGER DEFAULT 0,
+				created_at BIGINT NOT NULL
+			)`,
+		},
+		{
+			name: "create_webhook_deliveries_index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_instance ON webhook_deliveries (instance_id, created_at)`,
+		},
+		{
+			name: "add_global_to_webhook_deliveries",
+			sql:  `ALTER TABLE webhook_deliveries ADD COLUMN is_global BOOLEAN DEFAULT FALSE`,
+		},
 	}
 }
 
@@ -283,6 +309,32 @@ func (d *Database) getPostgresMigrations() []migration {
 			name: "add_push_name_to_messages",
 			sql:  `ALTER TABLE messages ADD COLUMN IF NOT EXISTS push_name TEXT`,
 		},
+		{
+			name: "create_webhook_deliveries",
+			sql: `CREATE TABLE IF NOT EXISTS webhook_deliveries (
+				id TEXT PRIMARY KEY,
+				delivery_id TEXT NOT NULL,
+				instance_id TEXT NOT NULL,
+				webhook_id TEXT NOT NULL,
+				event TEXT NOT NULL,
+				url TEXT,
+				payload TEXT,
+				status TEXT NOT NULL,
+				status_code INTEGER DEFAULT 0,
+				latency_ms INTEGER DEFAULT 0,
+				error TEXT,
+				attempt INTEGER DEFAULT 0,
+				created_at BIGINT NOT NULL
+			)`,
+		},
+		{
+			name: "create_webhook_deliveries_index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_instance ON webhook_deliveries (instance_id, created_at)`,
+		},
+		{
+			name: "add_global_to_webhook_deliveries",
+			sql:  `ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS is_global BOOLEAN DEFAULT FALSE`,
+		},
 	}
 }
 
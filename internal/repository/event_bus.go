@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// EventBusChannel canal de pub/sub por el que las réplicas comparten los eventos en tiempo real
+const EventBusChannel = "events:bus"
+
+// RedisEventBus reparte los eventos entre todas las réplicas con Redis pub/sub
+type RedisEventBus struct {
+	redis   *RedisClient
+	channel string
+}
+
+func NewRedisEventBus(redis *RedisClient) *RedisEventBus {
+	return &RedisEventBus{redis: redis, channel: EventBusChannel}
+}
+
+// Publish envía un evento a todas las réplicas suscritas
+func (b *RedisEventBus) Publish(ctx context.Context, data []byte) error {
+	return b.redis.Client.Publish(ctx, b.channel, data).Err()
+}
+
+// Subscribe entrega a handler cada evento publicado hasta que se cancele ctx.
+// go-redis restablece la suscripción si se pierde la conexión.
+func (b *RedisEventBus) Subscribe(ctx context.Context, handler func(data []byte)) error {
+	pubsub := b.redis.Client.Subscribe(ctx, b.channel)
+	defer pubsub.Close()
+
+	// Esperar la confirmación para no perder eventos publicados justo después de arrancar
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	log.Info().Str("channel", b.channel).Msg("Suscrito al bus de eventos")
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			handler([]byte(msg.Payload))
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
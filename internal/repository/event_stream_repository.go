@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// EventStreamRepository log acotado de los eventos emitidos por cada instancia, guardado en un
+// Redis Stream. Cada entrada usa como ID <seq>-0, con seq creciente por instancia, para que los
+// clientes que se reconectan puedan pedir lo que se perdieron.
+type EventStreamRepository struct {
+	redis  *RedisClient
+	maxLen int64
+	ttl    time.Duration
+}
+
+func NewEventStreamRepository(redis *RedisClient, maxLen int64, ttl time.Duration) *EventStreamRepository {
+	return &EventStreamRepository{redis: redis, maxLen: maxLen, ttl: ttl}
+}
+
+// StreamEvent evento guardado en el log de una instancia
+type StreamEvent struct {
+	Seq  int64
+	Data []byte
+}
+
+func eventStreamKey(instanceID string) string {
+	return "events:" + instanceID
+}
+
+// El contador no expira: si se reiniciara, los clientes con un last_event_id alto no verían eventos nuevos
+func eventSeqKey(instanceID string) string {
+	return "events:seq:" + instanceID
+}
+
+// appendEventScript asigna el siguiente número de secuencia y agrega el evento en una sola operación,
+// así el orden del stream coincide con el de los números aunque publiquen varios procesos
+var appendEventScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[2])
+redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[2], seq .. '-0', 'data', ARGV[1])
+if tonumber(ARGV[3]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return seq
+`)
+
+// Append agrega un evento al log de la instancia y devuelve su número de secuencia
+func (r *EventStreamRepository) Append(ctx context.Context, instanceID string, data []byte) (int64, error) {
+	keys := []string{eventStreamKey(instanceID), eventSeqKey(instanceID)}
+	return appendEventScript.Run(ctx, r.redis.Client, keys, data, r.maxLen, r.ttl.Milliseconds()).Int64()
+}
+
+// Since devuelve en orden los eventos con secuencia mayor a afterSeq que sigan en el log
+func (r *EventStreamRepository) Since(ctx context.Context, instanceID string, afterSeq int64) ([]StreamEvent, error) {
+	start := strconv.FormatInt(afterSeq+1, 10) + "-0"
+	entries, err := r.redis.Client.XRange(ctx, eventStreamKey(instanceID), start, "+").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]StreamEvent, 0, len(entries))
+	for _, entry := range entries {
+		seqStr, _, _ := strings.Cut(entry.ID, "-")
+		seq, err := strconv.ParseInt(seqStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		data, _ := entry.Values["data"].(string)
+		events = append(events, StreamEvent{Seq: seq, Data: []byte(data)})
+	}
+	return events, nil
+}
+
+// LastSeq devuelve el último número de secuencia asignado a la instancia (0 si no hay eventos)
+func (r *EventStreamRepository) LastSeq(ctx context.Context, instanceID string) (int64, error) {
+	seq, err := r.redis.Client.Get(ctx, eventSeqKey(instanceID)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return seq, err
+}
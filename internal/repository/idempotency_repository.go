@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"kero-kero/internal/models"
+)
+
+// IdempotencyRepository respuestas guardadas por clave Idempotency-Key
+type IdempotencyRepository struct {
+	redis *RedisClient
+}
+
+func NewIdempotencyRepository(redis *RedisClient) *IdempotencyRepository {
+	return &IdempotencyRepository{redis: redis}
+}
+
+func idempotencyKey(instanceID, key string) string {
+	return "idempotency:" + instanceID + ":" + key
+}
+
+// reserveScript guarda el registro solo si la clave no existe; si existe retorna el guardado
+var reserveScript = redis.NewScript(`
+local existing = redis.call('GET', KEYS[1])
+if existing then
+	return existing
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return false
+`)
+
+// Reserve registra la clave como en curso durante ttl. Si ya existía no la modifica y retorna
+// el registro guardado; nil significa que la reserva es de quien llama.
+func (r *IdempotencyRepository) Reserve(ctx context.Context, instanceID, key string, record *models.IdempotencyRecord, ttl time.Duration) (*models.IdempotencyRecord, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := reserveScript.Run(ctx, r.redis.Client, []string{idempotencyKey(instanceID, key)}, data, ttl.Milliseconds()).Text()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored models.IdempotencyRecord
+	if err := json.Unmarshal([]byte(existing), &stored); err != nil {
+		return nil, fmt.Errorf("registro de idempotencia corrupto: %w", err)
+	}
+	return &stored, nil
+}
+
+// Save guarda la respuesta de la clave durante ttl
+func (r *IdempotencyRepository) Save(ctx context.Context, instanceID, key string, record *models.IdempotencyRecord, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return r.redis.Client.Set(ctx, idempotencyKey(instanceID, key), data, ttl).Err()
+}
+
+// Release libera la clave para que la siguiente petición con ella se procese de nuevo
+func (r *IdempotencyRepository) Release(ctx context.Context, instanceID, key string) error {
+	return r.redis.Client.Del(ctx, idempotencyKey(instanceID, key)).Err()
+}
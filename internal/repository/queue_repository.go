@@ -0,0 +1,715 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"kero-kero/internal/models"
+)
+
+const (
+	queueLegacyKey  = "queue:messages"  // Cola global anterior; se reparte por instancia al arrancar
+	queuePrefix     = "queue:messages:" // Cola FIFO de cada instancia (prioridad normal)
+	queueRingKey    = "queue:ring"      // Instancias con mensajes pendientes, en orden de turno
+	queueActiveKey  = "queue:active"    // Mismas instancias que el anillo, para no repetirlas
+	queueItemPrefix = "queue:item:"     // Hash con el estado de cada mensaje
+	queueIndexKey   = "queue:items:"    // ZSET por instancia con sus mensajes, por fecha de alta
+
+	queueProcessingPrefix = "queue:processing:" // Lista de procesamiento de cada worker
+	queueLeasePrefix      = "queue:lease:"      // Lease de cada worker; si vence, su lista quedó huérfana
+	queueDeadPrefix       = "queue:dead:"       // Dead-letter de cada instancia, los más recientes primero
+	queuePausedPrefix     = "queue:paused:"     // Pausa de la cola de una instancia (límite de envío)
+	queueDelayedKey       = "queue:delayed"     // ZSET de reintentos programados: ID del mensaje por vencimiento (ms)
+	queueWorkersKey       = "queue:workers"     // Workers por réplica fijados por API; sin valor se usa la configuración
+)
+
+// queueLane cola de una prioridad: la lista de cada instancia y su anillo de turnos
+type queueLane struct {
+	prefix string
+	ring   string
+	active string
+}
+
+// queueLanes carriles de prioridad en el orden en que los atienden los workers.
+// La prioridad normal conserva las claves anteriores a los carriles.
+var queueLanes = []struct {
+	priority string
+	lane     queueLane
+}{
+	{models.QueuePriorityHigh, queueLane{"queue:high:", "queue:ring:high", "queue:active:high"}},
+	{models.QueuePriorityNormal, queueLane{queuePrefix, queueRingKey, queueActiveKey}},
+	{models.QueuePriorityBulk, queueLane{"queue:bulk:", "queue:ring:bulk", "queue:active:bulk"}},
+}
+
+// laneFor carril de una prioridad; las desconocidas o vacías van al normal
+func laneFor(priority string) queueLane {
+	for _, l := range queueLanes {
+		if l.priority == priority {
+			return l.lane
+		}
+	}
+	return queueLane{queuePrefix, queueRingKey, queueActiveKey}
+}
+
+// QueueItemTTL tiempo que se conserva el estado de un mensaje desde su última actualización
+const QueueItemTTL = 7 * 24 * time.Hour
+
+// ErrQueueItemCancelled el mensaje fue cancelado y no se vuelve a encolar
+var ErrQueueItemCancelled = errors.New("mensaje cancelado")
+
+// QueueRepository colas de envío asíncrono. Cada instancia tiene su propia cola y los workers
+// las atienden por turnos (round-robin), así una ráfaga de una instancia no frena al resto.
+type QueueRepository struct {
+	redis *RedisClient
+}
+
+func NewQueueRepository(redis *RedisClient) *QueueRepository {
+	return &QueueRepository{redis: redis}
+}
+
+func queueItemKey(msgID string) string {
+	return queueItemPrefix + msgID
+}
+
+func queueIndex(instanceID string) string {
+	return queueIndexKey + instanceID
+}
+
+// QueueProcessingKey lista de procesamiento de un worker (pop confiable).
+// Incluye la réplica para que los workers de distintas réplicas no compartan lista.
+func QueueProcessingKey(nodeID string, workerID int) string {
+	return fmt.Sprintf("%s%s:%d", queueProcessingPrefix, nodeID, workerID)
+}
+
+// leaseKey lease del worker dueño de una lista de procesamiento
+func leaseKey(processingKey string) string {
+	return queueLeasePrefix + strings.TrimPrefix(processingKey, queueProcessingPrefix)
+}
+
+// enqueueScript agrega el mensaje a la cola de la instancia y, si no estaba en el anillo, la suma al final.
+// También deja el estado en pending; un mensaje cancelado no se vuelve a encolar (retorna -1).
+var enqueueScript = redis.NewScript(`
+if redis.call('HGET', KEYS[4], 'status') == 'cancelled' then
+	return -1
+end
+redis.call('RPUSH', KEYS[1], ARGV[2])
+if redis.call('SADD', KEYS[2], ARGV[1]) == 1 then
+	redis.call('RPUSH', KEYS[3], ARGV[1])
+end
+redis.call('HSET', KEYS[4], 'instance_id', ARGV[1], 'type', ARGV[4], 'status', 'pending', 'data', ARGV[2], 'updated_at', ARGV[5], 'priority', ARGV[7])
+redis.call('HSETNX', KEYS[4], 'created_at', ARGV[5])
+if ARGV[8] ~= '0' then
+	redis.call('HSET', KEYS[4], 'expires_at', ARGV[8])
+end
+redis.call('PEXPIRE', KEYS[4], ARGV[6])
+redis.call('ZADD', KEYS[5], 'NX', ARGV[5], ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[5], '-inf', tonumber(ARGV[5]) - tonumber(ARGV[6]))
+redis.call('PEXPIRE', KEYS[5], ARGV[6])
+return redis.call('LLEN', KEYS[1])
+`)
+
+// dequeueScript recorre los carriles en orden de prioridad. En cada uno toma el turno de la primera
+// instancia del anillo, mueve su siguiente mensaje a la lista de procesamiento y, si le quedan
+// mensajes, la devuelve al final del anillo. Las instancias con la cola pausada pasan su turno.
+// KEYS: lista de procesamiento y, por carril, anillo e instancias activas.
+// ARGV: prefijo de pausa y, por carril, prefijo de las colas.
+var dequeueScript = redis.NewScript(`
+for lane = 1, (#KEYS - 1) / 2 do
+	local ring, active, prefix = KEYS[lane * 2], KEYS[lane * 2 + 1], ARGV[lane + 1]
+	local turns = redis.call('LLEN', ring)
+	for i = 1, turns do
+		local instance = redis.call('LPOP', ring)
+		if not instance then
+			break
+		end
+		if redis.call('EXISTS', ARGV[1] .. instance) == 1 then
+			redis.call('RPUSH', ring, instance)
+		else
+			local queue = prefix .. instance
+			local item = redis.call('LPOP', queue)
+			if item then
+				redis.call('RPUSH', KEYS[1], item)
+				if redis.call('LLEN', queue) > 0 then
+					redis.call('RPUSH', ring, instance)
+				else
+					redis.call('SREM', active, instance)
+				end
+				return item
+			end
+			redis.call('SREM', active, instance)
+		end
+	end
+end
+return false
+`)
+
+// Enqueue agrega un mensaje al final de la cola de su instancia en el carril de su prioridad y
+// devuelve la profundidad resultante del carril.
+// Retorna ErrQueueItemCancelled si el mensaje fue cancelado mientras esperaba un reintento.
+func (r *QueueRepository) Enqueue(ctx context.Context, msg *models.QueuedMessage) (int64, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	lane := laneFor(msg.Priority)
+	keys := []string{lane.prefix + msg.InstanceID, lane.active, lane.ring, queueItemKey(msg.ID), queueIndex(msg.InstanceID)}
+	depth, err := enqueueScript.Run(ctx, r.redis.Client, keys,
+		msg.InstanceID, data, msg.ID, string(msg.Type), time.Now().UnixMilli(), QueueItemTTL.Milliseconds(),
+		models.QueuePriorityOrDefault(msg.Priority), msg.ExpiresAt*1000,
+	).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if depth < 0 {
+		return 0, ErrQueueItemCancelled
+	}
+	return depth, nil
+}
+
+// Dequeue toma el siguiente mensaje según la prioridad y el turno de las instancias y lo mueve a
+// processingKey. Retorna redis.Nil si no hay mensajes pendientes.
+func (r *QueueRepository) Dequeue(ctx context.Context, processingKey string) (string, error) {
+	keys := []string{processingKey}
+	args := []interface{}{queuePausedPrefix}
+	for _, l := range queueLanes {
+		keys = append(keys, l.lane.ring, l.lane.active)
+		args = append(args, l.lane.prefix)
+	}
+	return dequeueScript.Run(ctx, r.redis.Client, keys, args...).Text()
+}
+
+// deferScript devuelve el mensaje al frente de la cola (carril) de su instancia y pausa la instancia.
+// Un mensaje cancelado no se vuelve a encolar (retorna -1).
+var deferScript = redis.NewScript(`
+if redis.call('HGET', KEYS[4], 'status') == 'cancelled' then
+	return -1
+end
+redis.call('LPUSH', KEYS[1], ARGV[2])
+if redis.call('SADD', KEYS[2], ARGV[1]) == 1 then
+	redis.call('RPUSH', KEYS[3], ARGV[1])
+end
+redis.call('HSET', KEYS[4], 'data', ARGV[2])
+redis.call('SET', KEYS[5], 1, 'PX', ARGV[3])
+return 1
+`)
+
+// Defer devuelve un mensaje al frente de la cola de su instancia y la pausa durante wait,
+// para respetar el límite de envío sin ocupar a los workers esperando.
+func (r *QueueRepository) Defer(ctx context.Context, msg *models.QueuedMessage, wait time.Duration) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	lane := laneFor(msg.Priority)
+	keys := []string{lane.prefix + msg.InstanceID, lane.active, lane.ring, queueItemKey(msg.ID), queuePausedPrefix + msg.InstanceID}
+	n, err := deferScript.Run(ctx, r.redis.Client, keys, msg.InstanceID, data, wait.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return ErrQueueItemCancelled
+	}
+	return nil
+}
+
+// scheduleScript guarda los datos del reintento en el hash del mensaje y lo programa en el conjunto
+// de diferidos. Un mensaje cancelado no se vuelve a programar (retorna -1).
+var scheduleScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'status') == 'cancelled' then
+	return -1
+end
+redis.call('HSET', KEYS[1], 'data', ARGV[2])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+return 1
+`)
+
+// Schedule programa el reintento de un mensaje: espera en el conjunto de diferidos hasta dueAt y
+// luego PromoteDue lo devuelve a la cola de su instancia.
+// Retorna ErrQueueItemCancelled si el mensaje fue cancelado.
+func (r *QueueRepository) Schedule(ctx context.Context, msg *models.QueuedMessage, dueAt time.Time) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{queueItemKey(msg.ID), queueDelayedKey}
+	n, err := scheduleScript.Run(ctx, r.redis.Client, keys, msg.ID, data, dueAt.UnixMilli()).Int()
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return ErrQueueItemCancelled
+	}
+	return nil
+}
+
+// promoteScript mueve al final de la cola de su instancia (en el carril de su prioridad) los
+// reintentos vencidos. Los que ya no están pendientes (cancelados o vencidos) se descartan.
+// KEYS: conjunto de diferidos. ARGV: ahora, límite, prefijo de los hashes, prioridad por defecto
+// y, por carril, prioridad, prefijo de las colas, anillo e instancias activas.
+var promoteScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+local moved = 0
+for _, id in ipairs(due) do
+	redis.call('ZREM', KEYS[1], id)
+	local item = redis.call('HMGET', ARGV[3] .. id, 'status', 'instance_id', 'priority', 'data')
+	local status, instance, priority, data = item[1], item[2], item[3], item[4]
+	if status == 'pending' and instance and data then
+		if not priority then
+			priority = ARGV[4]
+		end
+		for i = 5, #ARGV, 4 do
+			if ARGV[i] == priority then
+				redis.call('RPUSH', ARGV[i + 1] .. instance, data)
+				if redis.call('SADD', ARGV[i + 3], instance) == 1 then
+					redis.call('RPUSH', ARGV[i + 2], instance)
+				end
+				moved = moved + 1
+			end
+		end
+	end
+end
+return moved
+`)
+
+// PromoteDue devuelve a su cola hasta limit reintentos cuyo vencimiento ya pasó y retorna
+// cuántos movió. Es atómico, así que varias réplicas pueden ejecutarlo a la vez.
+func (r *QueueRepository) PromoteDue(ctx context.Context, now time.Time, limit int) (int, error) {
+	args := []interface{}{now.UnixMilli(), limit, queueItemPrefix, models.QueuePriorityNormal}
+	for _, l := range queueLanes {
+		args = append(args, l.priority, l.lane.prefix, l.lane.ring, l.lane.active)
+	}
+	return promoteScript.Run(ctx, r.redis.Client, []string{queueDelayedKey}, args...).Int()
+}
+
+// DelayedCount cuenta los reintentos programados que todavía no vuelven a su cola
+func (r *QueueRepository) DelayedCount(ctx context.Context) (int64, error) {
+	return r.redis.Client.ZCard(ctx, queueDelayedKey).Result()
+}
+
+// Ack quita un mensaje de la lista de procesamiento
+func (r *QueueRepository) Ack(ctx context.Context, processingKey, data string) error {
+	return r.redis.AckMessage(ctx, processingKey, data)
+}
+
+// Depths devuelve los mensajes pendientes (de todas las prioridades) de cada instancia con cola activa
+func (r *QueueRepository) Depths(ctx context.Context) (map[string]int64, error) {
+	activeKeys := make([]string, len(queueLanes))
+	for i, l := range queueLanes {
+		activeKeys[i] = l.lane.active
+	}
+	instances, err := r.redis.Client.SUnion(ctx, activeKeys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	depths := make(map[string]int64, len(instances))
+	for _, instanceID := range instances {
+		lanes, err := r.LaneDepths(ctx, instanceID)
+		if err != nil {
+			return nil, err
+		}
+		var total int64
+		for _, n := range lanes {
+			total += n
+		}
+		if total > 0 {
+			depths[instanceID] = total
+		}
+	}
+	return depths, nil
+}
+
+// Depth devuelve los mensajes pendientes de una instancia
+func (r *QueueRepository) Depth(ctx context.Context, instanceID string) (int64, error) {
+	lanes, err := r.LaneDepths(ctx, instanceID)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, n := range lanes {
+		total += n
+	}
+	return total, nil
+}
+
+// LaneDepths devuelve los mensajes pendientes de una instancia por prioridad
+func (r *QueueRepository) LaneDepths(ctx context.Context, instanceID string) (map[string]int64, error) {
+	pipe := r.redis.Client.Pipeline()
+	cmds := make(map[string]*redis.IntCmd, len(queueLanes))
+	for _, l := range queueLanes {
+		cmds[l.priority] = pipe.LLen(ctx, l.lane.prefix+instanceID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	depths := make(map[string]int64, len(cmds))
+	for priority, cmd := range cmds {
+		depths[priority] = cmd.Val()
+	}
+	return depths, nil
+}
+
+// MigrateLegacy reparte los mensajes de la cola global anterior en las colas por instancia
+func (r *QueueRepository) MigrateLegacy(ctx context.Context) (int, error) {
+	count := 0
+	for {
+		data, err := r.redis.Client.LPop(ctx, queueLegacyKey).Result()
+		if err == redis.Nil {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		var msg models.QueuedMessage
+		if err := json.Unmarshal([]byte(data), &msg); err != nil || msg.InstanceID == "" {
+			continue
+		}
+		if _, err := r.Enqueue(ctx, &msg); err != nil && err != ErrQueueItemCancelled {
+			return count, err
+		}
+		count++
+	}
+}
+
+// --- Workers ---
+
+// WorkerCount devuelve los workers por réplica fijados por API, o 0 si se usa la configuración
+func (r *QueueRepository) WorkerCount(ctx context.Context) (int, error) {
+	n, err := r.redis.Client.Get(ctx, queueWorkersKey).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return n, err
+}
+
+// SetWorkerCount fija los workers por réplica para todas las réplicas
+func (r *QueueRepository) SetWorkerCount(ctx context.Context, n int) error {
+	return r.redis.Client.Set(ctx, queueWorkersKey, n, 0).Err()
+}
+
+// ClearWorkerCount quita el valor fijado por API; las réplicas vuelven a su configuración
+func (r *QueueRepository) ClearWorkerCount(ctx context.Context) error {
+	return r.redis.Client.Del(ctx, queueWorkersKey).Err()
+}
+
+// --- Leases de los workers ---
+
+// RenewLease marca como vivo al worker dueño de processingKey durante ttl
+func (r *QueueRepository) RenewLease(ctx context.Context, processingKey string, ttl time.Duration) error {
+	return r.redis.Client.Set(ctx, leaseKey(processingKey), 1, ttl).Err()
+}
+
+// ReleaseLease elimina el lease de un worker que se detuvo
+func (r *QueueRepository) ReleaseLease(ctx context.Context, processingKey string) error {
+	return r.redis.Client.Del(ctx, leaseKey(processingKey)).Err()
+}
+
+// OrphanedProcessing busca las listas de procesamiento cuyo worker ya no renueva su lease.
+// Incluye las listas de versiones anteriores (queue:processing:<n>), que no tienen lease.
+func (r *QueueRepository) OrphanedProcessing(ctx context.Context) ([]string, error) {
+	var keys []string
+	iter := r.redis.Client.Scan(ctx, 0, queueProcessingPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	pipe := r.redis.Client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Exists(ctx, leaseKey(key))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	var orphaned []string
+	for i, cmd := range cmds {
+		if cmd.Val() == 0 {
+			orphaned = append(orphaned, keys[i])
+		}
+	}
+	return orphaned, nil
+}
+
+// PopOrphan extrae el siguiente mensaje de una lista de procesamiento huérfana.
+// Retorna redis.Nil cuando la lista quedó vacía.
+func (r *QueueRepository) PopOrphan(ctx context.Context, processingKey string) (string, error) {
+	return r.redis.Client.LPop(ctx, processingKey).Result()
+}
+
+// --- Estado de los mensajes ---
+
+// startProcessingScript pasa el mensaje a processing salvo que haya sido cancelado (retorna 0).
+// Los mensajes sin estado (encolados por versiones anteriores) se procesan igual.
+var startProcessingScript = redis.NewScript(`
+local status = redis.call('HGET', KEYS[1], 'status')
+if not status then
+	return 1
+end
+if status == 'cancelled' then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'status', 'processing', 'attempts', ARGV[1], 'updated_at', ARGV[2])
+return 1
+`)
+
+// StartProcessing marca el mensaje como en envío con el número de intento.
+// Retorna false si fue cancelado y no debe enviarse.
+func (r *QueueRepository) StartProcessing(ctx context.Context, msgID string, attempt int) (bool, error) {
+	ok, err := startProcessingScript.Run(ctx, r.redis.Client, []string{queueItemKey(msgID)},
+		attempt, time.Now().UnixMilli(),
+	).Int()
+	return ok == 1, err
+}
+
+// updateItemScript cambia el estado de un mensaje existente que no haya sido cancelado
+var updateItemScript = redis.NewScript(`
+local status = redis.call('HGET', KEYS[1], 'status')
+if not status or status == 'cancelled' then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'status', ARGV[1], 'updated_at', ARGV[2])
+if ARGV[3] ~= '' then
+	redis.call('HSET', KEYS[1], 'last_error', ARGV[3])
+end
+if ARGV[4] ~= '' then
+	redis.call('HSET', KEYS[1], 'wa_message_id', ARGV[4])
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return 1
+`)
+
+// UpdateStatus registra el nuevo estado de un mensaje; lastError y waMessageID vacíos no cambian
+func (r *QueueRepository) UpdateStatus(ctx context.Context, msgID, status, lastError, waMessageID string) error {
+	_, err := r.updateStatus(ctx, msgID, status, lastError, waMessageID)
+	return err
+}
+
+// Expire marca como vencido un mensaje que no llegó a enviarse.
+// Retorna false si el mensaje ya no existe o fue cancelado.
+func (r *QueueRepository) Expire(ctx context.Context, msgID, reason string) (bool, error) {
+	return r.updateStatus(ctx, msgID, models.QueueStatusExpired, reason, "")
+}
+
+func (r *QueueRepository) updateStatus(ctx context.Context, msgID, status, lastError, waMessageID string) (bool, error) {
+	n, err := updateItemScript.Run(ctx, r.redis.Client, []string{queueItemKey(msgID)},
+		status, time.Now().UnixMilli(), lastError, waMessageID, QueueItemTTL.Milliseconds(),
+	).Int()
+	return n == 1, err
+}
+
+// cancelScript quita de la cola (o de los reintentos programados) un mensaje pendiente y lo marca cancelado.
+// Retorna el estado en que quedó el mensaje, o nil si no existe.
+// KEYS: hash del mensaje, conjunto de diferidos y, por carril, instancias activas y anillo.
+// ARGV: ahora, ID del mensaje y, por carril, prefijo.
+var cancelScript = redis.NewScript(`
+local item = redis.call('HMGET', KEYS[1], 'status', 'instance_id', 'data')
+local status, instance, data = item[1], item[2], item[3]
+if not status then
+	return false
+end
+if status ~= 'pending' then
+	return status
+end
+redis.call('ZREM', KEYS[2], ARGV[2])
+if data then
+	for lane = 1, (#KEYS - 2) / 2 do
+		local queue = ARGV[lane + 2] .. instance
+		if redis.call('LREM', queue, 1, data) > 0 and redis.call('LLEN', queue) == 0 then
+			redis.call('SREM', KEYS[lane * 2 + 1], instance)
+			redis.call('LREM', KEYS[lane * 2 + 2], 0, instance)
+		end
+	end
+end
+redis.call('HSET', KEYS[1], 'status', 'cancelled', 'updated_at', ARGV[1])
+return 'cancelled'
+`)
+
+// Cancel cancela un mensaje pendiente. Retorna el estado final del mensaje
+// (cancelled, o el estado que impidió cancelarlo) o redis.Nil si no existe.
+func (r *QueueRepository) Cancel(ctx context.Context, msgID string) (string, error) {
+	keys := []string{queueItemKey(msgID), queueDelayedKey}
+	args := []interface{}{time.Now().UnixMilli(), msgID}
+	for _, l := range queueLanes {
+		keys = append(keys, l.lane.active, l.lane.ring)
+		args = append(args, l.lane.prefix)
+	}
+	return cancelScript.Run(ctx, r.redis.Client, keys, args...).Text()
+}
+
+// Item obtiene el estado de un mensaje; nil si no existe o ya venció
+func (r *QueueRepository) Item(ctx context.Context, msgID string) (*models.QueueItem, error) {
+	vals, err := r.redis.Client.HGetAll(ctx, queueItemKey(msgID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseQueueItem(msgID, vals), nil
+}
+
+// List obtiene los mensajes de una instancia (los más recientes primero) filtrados por estado.
+// Retorna la página pedida y el total de mensajes que cumplen el filtro.
+func (r *QueueRepository) List(ctx context.Context, instanceID string, statuses []string, limit, offset int) ([]*models.QueueItem, int, error) {
+	ids, err := r.redis.Client.ZRevRange(ctx, queueIndex(instanceID), 0, -1).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pipe := r.redis.Client.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	for i, id := range ids {
+		cmds[i] = pipe.HGetAll(ctx, queueItemKey(id))
+	}
+	if len(ids) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	wanted := make(map[string]bool, len(statuses))
+	for _, status := range statuses {
+		wanted[status] = true
+	}
+
+	var items []*models.QueueItem
+	var expired []interface{}
+	total := 0
+	for i, cmd := range cmds {
+		item := parseQueueItem(ids[i], cmd.Val())
+		if item == nil {
+			expired = append(expired, ids[i])
+			continue
+		}
+		if len(wanted) > 0 && !wanted[item.Status] {
+			continue
+		}
+		if total >= offset && len(items) < limit {
+			items = append(items, item)
+		}
+		total++
+	}
+
+	// El índice puede apuntar a mensajes cuyo estado ya venció
+	if len(expired) > 0 {
+		r.redis.Client.ZRem(ctx, queueIndex(instanceID), expired...)
+	}
+	return items, total, nil
+}
+
+func parseQueueItem(msgID string, vals map[string]string) *models.QueueItem {
+	if vals["status"] == "" {
+		return nil
+	}
+
+	item := &models.QueueItem{
+		ID:                msgID,
+		InstanceID:        vals["instance_id"],
+		Type:              models.MessageType(vals["type"]),
+		Status:            vals["status"],
+		Priority:          models.QueuePriorityOrDefault(vals["priority"]),
+		LastError:         vals["last_error"],
+		WhatsAppMessageID: vals["wa_message_id"],
+	}
+	item.ExpiresAt = unixMilliField(vals["expires_at"])
+	item.Attempts, _ = strconv.Atoi(vals["attempts"])
+	if t := unixMilliField(vals["created_at"]); t != nil {
+		item.CreatedAt = *t
+	}
+	if t := unixMilliField(vals["updated_at"]); t != nil {
+		item.UpdatedAt = *t
+	}
+	return item
+}
+
+// --- Dead-letter ---
+
+// PushDeadLetter guarda un mensaje que agotó sus reintentos, conservando como máximo maxLen entradas
+func (r *QueueRepository) PushDeadLetter(ctx context.Context, msg *models.QueuedMessage, maxLen int) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	key := queueDeadPrefix + msg.InstanceID
+	pipe := r.redis.Client.TxPipeline()
+	pipe.LPush(ctx, key, data)
+	if maxLen > 0 {
+		pipe.LTrim(ctx, key, 0, int64(maxLen-1))
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// ListDeadLetters obtiene los mensajes fallidos de una instancia (los más recientes primero)
+func (r *QueueRepository) ListDeadLetters(ctx context.Context, instanceID string, limit int) ([]models.QueuedMessage, error) {
+	vals, err := r.redis.Client.LRange(ctx, queueDeadPrefix+instanceID, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]models.QueuedMessage, 0, len(vals))
+	for _, val := range vals {
+		var msg models.QueuedMessage
+		if err := json.Unmarshal([]byte(val), &msg); err != nil {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// TakeDeadLetters extrae de la dead-letter los mensajes con los IDs indicados (todos si ids está vacío).
+// Cada mensaje se extrae una sola vez aunque dos operadores lo pidan a la vez.
+func (r *QueueRepository) TakeDeadLetters(ctx context.Context, instanceID string, ids []string) ([]models.QueuedMessage, error) {
+	key := queueDeadPrefix + instanceID
+	vals, err := r.redis.Client.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	var taken []models.QueuedMessage
+	for _, val := range vals {
+		var msg models.QueuedMessage
+		if err := json.Unmarshal([]byte(val), &msg); err != nil {
+			continue
+		}
+		if len(wanted) > 0 && !wanted[msg.ID] {
+			continue
+		}
+		removed, err := r.redis.Client.LRem(ctx, key, 1, val).Result()
+		if err != nil {
+			return taken, err
+		}
+		if removed == 1 {
+			taken = append(taken, msg)
+		}
+	}
+	return taken, nil
+}
+
+// PurgeDeadLetters elimina todos los mensajes fallidos de una instancia
+func (r *QueueRepository) PurgeDeadLetters(ctx context.Context, instanceID string) error {
+	return r.redis.Client.Del(ctx, queueDeadPrefix+instanceID).Err()
+}
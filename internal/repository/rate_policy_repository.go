@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"kero-kero/internal/models"
+)
+
+// RatePolicyRepository políticas de envío por instancia y sus contadores
+type RatePolicyRepository struct {
+	redis *RedisClient
+}
+
+func NewRatePolicyRepository(redis *RedisClient) *RatePolicyRepository {
+	return &RatePolicyRepository{redis: redis}
+}
+
+func ratePolicyKey(instanceID string) string {
+	return "ratepolicy:" + instanceID
+}
+
+// Get obtiene la política de una instancia; nil si no tiene una propia
+func (r *RatePolicyRepository) Get(ctx context.Context, instanceID string) (*models.RatePolicy, error) {
+	data, err := r.redis.Client.Get(ctx, ratePolicyKey(instanceID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var policy models.RatePolicy
+	if err := json.Unmarshal([]byte(data), &policy); err != nil {
+		return nil, fmt.Errorf("política de envío corrupta: %w", err)
+	}
+	return &policy, nil
+}
+
+// Set guarda la política de una instancia
+func (r *RatePolicyRepository) Set(ctx context.Context, instanceID string, policy *models.RatePolicy) error {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return r.redis.Client.Set(ctx, ratePolicyKey(instanceID), data, 0).Err()
+}
+
+// Delete elimina la política de una instancia (vuelve a la política por defecto)
+func (r *RatePolicyRepository) Delete(ctx context.Context, instanceID string) error {
+	return r.redis.Client.Del(ctx, ratePolicyKey(instanceID)).Err()
+}
+
+// RateWindow ventana fija de un límite de envío (minuto, hora o día en curso)
+type RateWindow struct {
+	Name  string // Sufijo de la clave del contador: m, h, d
+	Start time.Time
+	End   time.Time
+	Limit int
+}
+
+// acquireScript consume un envío si ninguna ventana llegó a su límite y el bucket de ráfaga
+// tiene saldo. Si no, no consume nada y retorna los milisegundos hasta que vuelva a haber cupo.
+// KEYS: contador de cada ventana y, al final, el bucket de ráfaga.
+// ARGV: ahora (ms), ráfaga, reposición del bucket (envíos por ms) y, por ventana, límite y ms hasta su fin.
+var acquireScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local rate = tonumber(ARGV[3])
+local windows = #KEYS - 1
+local wait = 0
+
+for i = 1, windows do
+	local limit = tonumber(ARGV[2 + i * 2])
+	local used = tonumber(redis.call('GET', KEYS[i]) or '0')
+	if used >= limit then
+		wait = math.max(wait, tonumber(ARGV[3 + i * 2]))
+	end
+end
+
+local bucket = KEYS[windows + 1]
+local tokens = burst
+if burst > 0 then
+	local state = redis.call('HMGET', bucket, 'tokens', 'ts')
+	if state[1] then
+		tokens = math.min(burst, tonumber(state[1]) + (now - tonumber(state[2])) * rate)
+	end
+	if tokens < 1 then
+		wait = math.max(wait, math.ceil((1 - tokens) / rate))
+	end
+end
+
+if wait > 0 then
+	return wait
+end
+
+for i = 1, windows do
+	redis.call('INCR', KEYS[i])
+	redis.call('PEXPIRE', KEYS[i], ARGV[3 + i * 2])
+end
+if burst > 0 then
+	redis.call('HSET', bucket, 'tokens', tostring(tokens - 1), 'ts', ARGV[1])
+	redis.call('PEXPIRE', bucket, math.ceil(burst / rate) + 1000)
+end
+return 0
+`)
+
+// Acquire registra un envío de la instancia si lo permiten las ventanas y la ráfaga.
+// Retorna 0 si se permitió o la espera hasta que haya cupo. burst en 0 desactiva la ráfaga;
+// refill es el ritmo al que se repone (envíos por segundo).
+func (r *RatePolicyRepository) Acquire(ctx context.Context, instanceID string, windows []RateWindow, burst int, refill float64, now time.Time) (time.Duration, error) {
+	keys := make([]string, 0, len(windows)+1)
+	args := []interface{}{now.UnixMilli(), burst, strconv.FormatFloat(refill/1000, 'g', -1, 64)}
+	for _, w := range windows {
+		keys = append(keys, fmt.Sprintf("ratelimit:%s:%s:%d", instanceID, w.Name, w.Start.Unix()))
+		remaining := w.End.Sub(now).Milliseconds()
+		if remaining < 1 {
+			remaining = 1
+		}
+		args = append(args, w.Limit, remaining)
+	}
+	keys = append(keys, "ratelimit:"+instanceID+":burst")
+
+	wait, err := acquireScript.Run(ctx, r.redis.Client, keys, args...).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
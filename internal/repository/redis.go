@@ -219,6 +219,13 @@ func (r *RedisClient) CheckRateLimit(ctx context.Context, instanceID string, lim
 	return val <= int64(limit), nil
 }
 
+// MarkEventSeen registra un evento entrante en el seen-set de corta duración.
+// Retorna false si ya se había visto dentro de ttl (es un duplicado).
+func (r *RedisClient) MarkEventSeen(ctx context.Context, instanceID, eventType, sourceID string, ttl time.Duration) (bool, error) {
+	key := fmt.Sprintf("seen:%s:%s:%s", instanceID, eventType, sourceID)
+	return r.Client.SetNX(ctx, key, 1, ttl).Result()
+}
+
 // SetAutoLabelRules almacena las reglas de etiquetado automático
 func (r *RedisClient) SetAutoLabelRules(ctx context.Context, instanceID string, rulesJSON string) error {
 	key := fmt.Sprintf("autolabel_rules:%s", instanceID)
@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"kero-kero/internal/models"
+)
+
+// WebhookLogRepository guarda el historial de intentos de entrega de webhooks.
+// created_at se guarda en milisegundos Unix para poder filtrar igual en SQLite y PostgreSQL.
+type WebhookLogRepository struct {
+	db *Database
+}
+
+// NewWebhookLogRepository crea un nuevo repositorio de historial de webhooks
+func NewWebhookLogRepository(db *Database) *WebhookLogRepository {
+	return &WebhookLogRepository{db: db}
+}
+
+// Create registra un intento de entrega
+func (r *WebhookLogRepository) Create(ctx context.Context, entry *models.WebhookDeliveryLog) error {
+	query := `
+		INSERT INTO webhook_deliveries (id, delivery_id, instance_id, webhook_id, is_global, event, url, payload, status, status_code, latency_ms, error, attempt, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	`
+
+	_, err := r.db.DB.ExecContext(ctx, query,
+		entry.ID,
+		entry.DeliveryID,
+		entry.InstanceID,
+		entry.WebhookID,
+		entry.Global,
+		entry.Event,
+		entry.URL,
+		string(entry.Payload),
+		entry.Status,
+		entry.StatusCode,
+		entry.LatencyMs,
+		entry.Error,
+		entry.Attempt,
+		entry.CreatedAt.UnixMilli(),
+	)
+	if err != nil {
+		return fmt.Errorf("error guardando entrega de webhook: %w", err)
+	}
+	return nil
+}
+
+// List obtiene los intentos de entrega de una instancia, del más reciente al más antiguo
+func (r *WebhookLogRepository) List(ctx context.Context, filter models.WebhookDeliveryFilter) ([]models.WebhookDeliveryLog, error) {
+	conditions := []string{"instance_id = $1"}
+	args := []interface{}{filter.InstanceID}
+
+	addCondition := func(column string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf("%s $%d", column, len(args)))
+	}
+
+	if filter.WebhookID != "" {
+		addCondition("webhook_id =", filter.WebhookID)
+	}
+	if filter.Event != "" {
+		addCondition("event =", filter.Event)
+	}
+	if filter.Status != "" {
+		addCondition("status =", filter.Status)
+	}
+	if !filter.From.IsZero() {
+		addCondition("created_at >=", filter.From.UnixMilli())
+	}
+	if !filter.To.IsZero() {
+		addCondition("created_at <=", filter.To.UnixMilli())
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	query := fmt.Sprintf(`
+		SELECT id, delivery_id, instance_id, webhook_id, COALESCE(is_global, FALSE), event, url, payload, status, status_code, latency_ms, error, attempt, created_at
+		FROM webhook_deliveries
+		WHERE %s
+		ORDER BY created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, strings.Join(conditions, " AND "), len(args)-1, len(args))
+
+	rows, err := r.db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error consultando entregas de webhook: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.WebhookDeliveryLog{}
+	for rows.Next() {
+		entry, err := scanWebhookDeliveryLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// GetByID obtiene un intento de entrega. Retorna nil si no existe.
+func (r *WebhookLogRepository) GetByID(ctx context.Context, instanceID, id string) (*models.WebhookDeliveryLog, error) {
+	query := `
+		SELECT id, delivery_id, instance_id, webhook_id, COALESCE(is_global, FALSE), event, url, payload, status, status_code, latency_ms, error, attempt, created_at
+		FROM webhook_deliveries
+		WHERE instance_id = $1 AND id = $2
+	`
+
+	entry, err := scanWebhookDeliveryLog(r.db.DB.QueryRowContext(ctx, query, instanceID, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// DeleteOlderThan elimina los intentos anteriores a la fecha indicada
+func (r *WebhookLogRepository) DeleteOlderThan(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.DB.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE created_at < $1`, before.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("error eliminando entregas de webhook antiguas: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWebhookDeliveryLog(row rowScanner) (*models.WebhookDeliveryLog, error) {
+	var entry models.WebhookDeliveryLog
+	var url, payload, errMsg sql.NullString
+	var createdAt int64
+
+	err := row.Scan(
+		&entry.ID,
+		&entry.DeliveryID,
+		&entry.InstanceID,
+		&entry.WebhookID,
+		&entry.Global,
+		&entry.Event,
+		&url,
+		&payload,
+		&entry.Status,
+		&entry.StatusCode,
+		&entry.LatencyMs,
+		&errMsg,
+		&entry.Attempt,
+		&createdAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error escaneando entrega de webhook: %w", err)
+	}
+
+	entry.URL = url.String
+	entry.Error = errMsg.String
+	if payload.Valid && payload.String != "" {
+		entry.Payload = []byte(payload.String)
+	}
+	entry.CreatedAt = time.UnixMilli(createdAt)
+
+	return &entry, nil
+}
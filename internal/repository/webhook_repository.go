@@ -3,11 +3,23 @@ package repository
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/redis/go-redis/v9"
+
 	"kero-kero/internal/models"
 )
 
+const (
+	webhookQueueKey      = "webhook:queue"
+	webhookProcessingKey = "webhook:processing"
+	webhookRetryKey      = "webhook:retry"
+)
+
 type WebhookRepository struct {
 	redis *RedisClient
 }
@@ -16,26 +28,84 @@ func NewWebhookRepository(redis *RedisClient) *WebhookRepository {
 	return &WebhookRepository{redis: redis}
 }
 
-// Set guarda la configuración de webhook para una instancia
+// DefaultWebhookID identifica la suscripción creada con la API de un solo webhook por instancia
+const DefaultWebhookID = "default"
+
+func webhooksKey(instanceID string) string {
+	return "webhooks:" + instanceID
+}
+
+// globalWebhooksKey hash con las suscripciones globales (reciben eventos de todas las instancias)
+const globalWebhooksKey = "global_webhooks"
+
+// Set guarda una suscripción de webhook de una instancia
 func (r *WebhookRepository) Set(ctx context.Context, config *models.WebhookConfig) error {
+	if config.ID == "" {
+		config.ID = DefaultWebhookID
+	}
+	return r.save(ctx, webhooksKey(config.InstanceID), config)
+}
+
+// Get obtiene una suscripción de webhook por su ID
+func (r *WebhookRepository) Get(ctx context.Context, instanceID, webhookID string) (*models.WebhookConfig, error) {
+	if err := r.migrateLegacy(ctx, instanceID); err != nil {
+		return nil, err
+	}
+	if webhookID == "" {
+		webhookID = DefaultWebhookID
+	}
+	return r.load(ctx, webhooksKey(instanceID), webhookID)
+}
+
+// List obtiene todas las suscripciones de webhook de una instancia, ordenadas por fecha de creación
+func (r *WebhookRepository) List(ctx context.Context, instanceID string) ([]*models.WebhookConfig, error) {
+	if err := r.migrateLegacy(ctx, instanceID); err != nil {
+		return nil, err
+	}
+	return r.loadAll(ctx, webhooksKey(instanceID))
+}
+
+// SetGlobal guarda una suscripción global
+func (r *WebhookRepository) SetGlobal(ctx context.Context, config *models.WebhookConfig) error {
+	config.Global = true
+	config.InstanceID = ""
+	return r.save(ctx, globalWebhooksKey, config)
+}
+
+// GetGlobal obtiene una suscripción global por su ID
+func (r *WebhookRepository) GetGlobal(ctx context.Context, webhookID string) (*models.WebhookConfig, error) {
+	return r.load(ctx, globalWebhooksKey, webhookID)
+}
+
+// ListGlobal obtiene las suscripciones globales, ordenadas por fecha de creación
+func (r *WebhookRepository) ListGlobal(ctx context.Context) ([]*models.WebhookConfig, error) {
+	return r.loadAll(ctx, globalWebhooksKey)
+}
+
+// DeleteGlobal elimina una suscripción global. Retorna redis.Nil si no existía.
+func (r *WebhookRepository) DeleteGlobal(ctx context.Context, webhookID string) error {
+	return r.deleteField(ctx, globalWebhooksKey, webhookID)
+}
+
+func (r *WebhookRepository) save(ctx context.Context, key string, config *models.WebhookConfig) error {
 	config.UpdatedAt = time.Now()
 	if config.CreatedAt.IsZero() {
 		config.CreatedAt = time.Now()
 	}
 
-	data, err := json.Marshal(config)
+	// El estado del circuito vive en su propia clave
+	stored := *config
+	stored.Circuit = nil
+	data, err := json.Marshal(stored)
 	if err != nil {
 		return err
 	}
 
-	key := "webhook:" + config.InstanceID
-	return r.redis.Client.Set(ctx, key, data, 0).Err()
+	return r.redis.Client.HSet(ctx, key, config.ID, data).Err()
 }
 
-// Get obtiene la configuración de webhook de una instancia
-func (r *WebhookRepository) Get(ctx context.Context, instanceID string) (*models.WebhookConfig, error) {
-	key := "webhook:" + instanceID
-	data, err := r.redis.Client.Get(ctx, key).Bytes()
+func (r *WebhookRepository) load(ctx context.Context, key, webhookID string) (*models.WebhookConfig, error) {
+	data, err := r.redis.Client.HGet(ctx, key, webhookID).Bytes()
 	if err != nil {
 		return nil, err
 	}
@@ -48,8 +118,432 @@ func (r *WebhookRepository) Get(ctx context.Context, instanceID string) (*models
 	return &config, nil
 }
 
-// Delete elimina la configuración de webhook
-func (r *WebhookRepository) Delete(ctx context.Context, instanceID string) error {
-	key := "webhook:" + instanceID
-	return r.redis.Client.Del(ctx, key).Err()
+func (r *WebhookRepository) loadAll(ctx context.Context, key string) ([]*models.WebhookConfig, error) {
+	vals, err := r.redis.Client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make([]*models.WebhookConfig, 0, len(vals))
+	for _, val := range vals {
+		var config models.WebhookConfig
+		if err := json.Unmarshal([]byte(val), &config); err != nil {
+			continue
+		}
+		configs = append(configs, &config)
+	}
+
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].CreatedAt.Before(configs[j].CreatedAt)
+	})
+	return configs, nil
+}
+
+func (r *WebhookRepository) deleteField(ctx context.Context, key, webhookID string) error {
+	n, err := r.redis.Client.HDel(ctx, key, webhookID).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return redis.Nil
+	}
+	return nil
+}
+
+// Delete elimina una suscripción de webhook. Retorna redis.Nil si no existía.
+func (r *WebhookRepository) Delete(ctx context.Context, instanceID, webhookID string) error {
+	return r.deleteField(ctx, webhooksKey(instanceID), webhookID)
+}
+
+// DeleteAll elimina todas las suscripciones de webhook de una instancia
+func (r *WebhookRepository) DeleteAll(ctx context.Context, instanceID string) error {
+	return r.redis.Client.Del(ctx, webhooksKey(instanceID), "webhook:"+instanceID).Err()
+}
+
+// migrateLegacy convierte la configuración antigua de un solo webhook ("webhook:<id>")
+// en la suscripción "default" de la nueva estructura.
+func (r *WebhookRepository) migrateLegacy(ctx context.Context, instanceID string) error {
+	legacyKey := "webhook:" + instanceID
+	data, err := r.redis.Client.Get(ctx, legacyKey).Bytes()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var config models.WebhookConfig
+	if err := json.Unmarshal(data, &config); err == nil {
+		config.ID = DefaultWebhookID
+		if migrated, err := json.Marshal(config); err == nil {
+			r.redis.Client.HSetNX(ctx, webhooksKey(instanceID), config.ID, migrated)
+		}
+	}
+	return r.redis.Client.Del(ctx, legacyKey).Err()
+}
+
+// --- Cola de entregas ---
+
+// EnqueueDelivery añade una entrega a la cola de despacho
+func (r *WebhookRepository) EnqueueDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return r.redis.Client.LPush(ctx, webhookQueueKey, data).Err()
+}
+
+// DequeueDelivery toma la siguiente entrega y la mueve a la lista de procesamiento de forma atómica.
+// Retorna el valor crudo para poder hacer ACK después.
+func (r *WebhookRepository) DequeueDelivery(ctx context.Context, timeout time.Duration) (*models.WebhookDelivery, string, error) {
+	raw, err := r.redis.DequeueMessageReliable(ctx, webhookQueueKey, webhookProcessingKey, timeout)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var delivery models.WebhookDelivery
+	if err := json.Unmarshal([]byte(raw), &delivery); err != nil {
+		return nil, raw, fmt.Errorf("entrega de webhook corrupta: %w", err)
+	}
+	return &delivery, raw, nil
+}
+
+// AckDelivery elimina una entrega de la lista de procesamiento
+func (r *WebhookRepository) AckDelivery(ctx context.Context, raw string) error {
+	return r.redis.AckMessage(ctx, webhookProcessingKey, raw)
+}
+
+// RecoverProcessing devuelve a la cola las entregas que quedaron a medias (p. ej. tras un crash).
+// La entrega es "al menos una vez": el receptor puede recibir duplicados.
+func (r *WebhookRepository) RecoverProcessing(ctx context.Context) (int, error) {
+	count := 0
+	for {
+		err := r.redis.Client.RPopLPush(ctx, webhookProcessingKey, webhookQueueKey).Err()
+		if err == redis.Nil {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// ScheduleRetry programa una entrega para reintentarse en el momento indicado
+func (r *WebhookRepository) ScheduleRetry(ctx context.Context, delivery *models.WebhookDelivery, at time.Time) error {
+	delivery.NextAttemptAt = at.Unix()
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return r.redis.Client.ZAdd(ctx, webhookRetryKey, redis.Z{
+		Score:  float64(at.UnixMilli()),
+		Member: data,
+	}).Err()
+}
+
+// promoteRetriesScript mueve a la cola las entregas cuyo reintento ya venció.
+// Se hace en Lua para que dos dispatchers no promuevan la misma entrega.
+var promoteRetriesScript = redis.NewScript(`
+	local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[2])
+	for _, member in ipairs(due) do
+		redis.call("ZREM", KEYS[1], member)
+		redis.call("LPUSH", KEYS[2], member)
+	end
+	return #due
+`)
+
+// PromoteDueRetries mueve a la cola principal los reintentos vencidos
+func (r *WebhookRepository) PromoteDueRetries(ctx context.Context, now time.Time, limit int) (int, error) {
+	n, err := promoteRetriesScript.Run(ctx, r.redis.Client,
+		[]string{webhookRetryKey, webhookQueueKey},
+		strconv.FormatInt(now.UnixMilli(), 10), limit,
+	).Int()
+	return n, err
+}
+
+// --- Lotes ---
+
+const webhookBatchesKey = "webhook:batches" // ZSET de lotes pendientes, con el momento en que vencen
+
+// BatchKey lista con los eventos pendientes del lote de una suscripción en una instancia
+func BatchKey(instanceID, webhookID string, global bool) string {
+	scope := "i"
+	if global {
+		scope = "g"
+	}
+	return "webhook:batch:" + instanceID + ":" + scope + ":" + webhookID
+}
+
+// ParseBatchKey extrae la suscripción de una clave de lote
+func ParseBatchKey(key string) (instanceID, webhookID string, global bool, err error) {
+	// Se separa desde el final: el ID de la instancia podría contener ":"
+	rest, ok := strings.CutPrefix(key, "webhook:batch:")
+	scopeEnd := strings.LastIndex(rest, ":")
+	if !ok || scopeEnd < 2 || rest[scopeEnd-2] != ':' {
+		return "", "", false, fmt.Errorf("clave de lote inválida: %s", key)
+	}
+	return rest[:scopeEnd-2], rest[scopeEnd+1:], rest[scopeEnd-1] == 'g', nil
+}
+
+// appendBatchScript añade un evento al lote y registra su vencimiento si es el primero
+var appendBatchScript = redis.NewScript(`
+	local n = redis.call("RPUSH", KEYS[1], ARGV[1])
+	redis.call("ZADD", KEYS[2], "NX", ARGV[2], KEYS[1])
+	return n
+`)
+
+// AppendBatch añade una entrega al lote de su suscripción. Retorna cuántos eventos tiene el lote.
+func (r *WebhookRepository) AppendBatch(ctx context.Context, delivery *models.WebhookDelivery, dueAt time.Time) (int, error) {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return 0, err
+	}
+	return appendBatchScript.Run(ctx, r.redis.Client,
+		[]string{BatchKey(delivery.InstanceID, delivery.WebhookID, delivery.Global), webhookBatchesKey},
+		data, dueAt.UnixMilli(),
+	).Int()
+}
+
+// takeBatchScript extrae hasta ARGV[1] eventos del lote. Si quedan más, el lote sigue vencido.
+var takeBatchScript = redis.NewScript(`
+	local items = redis.call("LRANGE", KEYS[1], 0, tonumber(ARGV[1]) - 1)
+	redis.call("LTRIM", KEYS[1], #items, -1)
+	if redis.call("LLEN", KEYS[1]) == 0 then
+		redis.call("ZREM", KEYS[2], KEYS[1])
+	end
+	return items
+`)
+
+// TakeBatch extrae de forma atómica hasta max entregas del lote
+func (r *WebhookRepository) TakeBatch(ctx context.Context, key string, max int) ([]models.WebhookDelivery, error) {
+	vals, err := takeBatchScript.Run(ctx, r.redis.Client, []string{key, webhookBatchesKey}, max).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0, len(vals))
+	for _, val := range vals {
+		var delivery models.WebhookDelivery
+		if err := json.Unmarshal([]byte(val), &delivery); err != nil {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// PeekBatch lee hasta max entregas del principio del lote sin sacarlas. Se devuelven crudas para
+// confirmarlas con CommitBatch.
+func (r *WebhookRepository) PeekBatch(ctx context.Context, key string, max int) ([]string, error) {
+	return r.redis.Client.LRange(ctx, key, 0, int64(max-1)).Result()
+}
+
+// commitBatchScript saca del lote los eventos leídos y encola la entrega que los agrupa en un solo
+// paso. Si el principio del lote ya no coincide (otra réplica lo tomó) no hace nada y retorna 0.
+// KEYS: lote, lotes pendientes y cola de entregas. ARGV: entrega ("" si no hay) y los eventos leídos.
+var commitBatchScript = redis.NewScript(`
+	local n = #ARGV - 1
+	local head = redis.call("LRANGE", KEYS[1], 0, n - 1)
+	if #head ~= n then
+		return 0
+	end
+	for i = 1, n do
+		if head[i] ~= ARGV[i + 1] then
+			return 0
+		end
+	end
+	-- Un error de Redis no deshace lo ya escrito: la entrega se encola antes de tocar el lote
+	if ARGV[1] ~= "" then
+		redis.call("LPUSH", KEYS[3], ARGV[1])
+	end
+	redis.call("LTRIM", KEYS[1], n, -1)
+	if redis.call("LLEN", KEYS[1]) == 0 then
+		redis.call("ZREM", KEYS[2], KEYS[1])
+	end
+	return 1
+`)
+
+// CommitBatch saca del lote los eventos leídos con PeekBatch y encola la entrega del lote de forma
+// atómica: si falla, los eventos siguen en el lote. Con delivery nil solo los descarta.
+// Retorna false si otra réplica ya tomó esos eventos.
+func (r *WebhookRepository) CommitBatch(ctx context.Context, key string, items []string, delivery *models.WebhookDelivery) (bool, error) {
+	data := ""
+	if delivery != nil {
+		raw, err := json.Marshal(delivery)
+		if err != nil {
+			return false, err
+		}
+		data = string(raw)
+	}
+
+	args := make([]interface{}, 0, len(items)+1)
+	args = append(args, data)
+	for _, item := range items {
+		args = append(args, item)
+	}
+	n, err := commitBatchScript.Run(ctx, r.redis.Client, []string{key, webhookBatchesKey, webhookQueueKey}, args...).Int()
+	return n == 1, err
+}
+
+// DueBatches obtiene las claves de los lotes cuyo tiempo de espera ya venció
+func (r *WebhookRepository) DueBatches(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	return r.redis.Client.ZRangeByScore(ctx, webhookBatchesKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+}
+
+// --- Dead-letter ---
+
+// PushDeadLetter guarda una entrega que agotó sus reintentos, conservando como máximo maxLen entradas
+func (r *WebhookRepository) PushDeadLetter(ctx context.Context, delivery *models.WebhookDelivery, maxLen int) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	key := "webhook:dead:" + delivery.InstanceID
+	pipe := r.redis.Client.TxPipeline()
+	pipe.LPush(ctx, key, data)
+	if maxLen > 0 {
+		pipe.LTrim(ctx, key, 0, int64(maxLen-1))
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// ListDeadLetters obtiene las entregas fallidas de una instancia (las más recientes primero)
+func (r *WebhookRepository) ListDeadLetters(ctx context.Context, instanceID string, limit int) ([]models.WebhookDelivery, error) {
+	key := "webhook:dead:" + instanceID
+	vals, err := r.redis.Client.LRange(ctx, key, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0, len(vals))
+	for _, val := range vals {
+		var delivery models.WebhookDelivery
+		if err := json.Unmarshal([]byte(val), &delivery); err != nil {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// PurgeDeadLetters elimina todas las entregas fallidas de una instancia
+func (r *WebhookRepository) PurgeDeadLetters(ctx context.Context, instanceID string) error {
+	return r.redis.Client.Del(ctx, "webhook:dead:"+instanceID).Err()
+}
+
+// --- Circuit breaker ---
+
+// circuitKey hash con el estado del circuit breaker de una suscripción
+func circuitKey(instanceID, webhookID string, global bool) string {
+	if global {
+		return "webhook:circuit:global:" + webhookID
+	}
+	return "webhook:circuit:" + instanceID + ":" + webhookID
+}
+
+// GetCircuit obtiene el estado del circuit breaker de una suscripción (cerrado si no hay fallos)
+func (r *WebhookRepository) GetCircuit(ctx context.Context, instanceID, webhookID string, global bool) (*models.WebhookCircuit, error) {
+	vals, err := r.redis.Client.HGetAll(ctx, circuitKey(instanceID, webhookID, global)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	circuit := &models.WebhookCircuit{State: models.WebhookCircuitClosed}
+	if state := vals["state"]; state != "" {
+		circuit.State = state
+	}
+	circuit.ConsecutiveFailures, _ = strconv.Atoi(vals["failures"])
+	circuit.LastError = vals["last_error"]
+	circuit.OpenedAt = unixMilliField(vals["opened_at"])
+	circuit.NextProbeAt = unixMilliField(vals["next_probe_at"])
+	return circuit, nil
+}
+
+func unixMilliField(value string) *time.Time {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ms == 0 {
+		return nil
+	}
+	t := time.UnixMilli(ms)
+	return &t
+}
+
+// circuitFailureScript suma un fallo consecutivo y abre el circuito al llegar al umbral.
+// Si ya estaba abierto (falló un sondeo) solo reprograma el siguiente sondeo.
+// Retorna 1 si el circuito se acaba de abrir.
+var circuitFailureScript = redis.NewScript(`
+	local failures = redis.call("HINCRBY", KEYS[1], "failures", 1)
+	redis.call("HSET", KEYS[1], "last_error", ARGV[1])
+	if redis.call("HGET", KEYS[1], "state") == "open" then
+		redis.call("HSET", KEYS[1], "next_probe_at", ARGV[3])
+		return 0
+	end
+	if failures >= tonumber(ARGV[4]) then
+		redis.call("HSET", KEYS[1], "state", "open", "opened_at", ARGV[2], "next_probe_at", ARGV[3])
+		return 1
+	end
+	return 0
+`)
+
+// RecordCircuitFailure registra un fallo de entrega. Retorna true si el circuito se abrió con este fallo.
+func (r *WebhookRepository) RecordCircuitFailure(ctx context.Context, instanceID, webhookID string, global bool, lastErr string, threshold int, nextProbe time.Time) (bool, error) {
+	opened, err := circuitFailureScript.Run(ctx, r.redis.Client,
+		[]string{circuitKey(instanceID, webhookID, global)},
+		lastErr, time.Now().UnixMilli(), nextProbe.UnixMilli(), threshold,
+	).Int()
+	return opened == 1, err
+}
+
+// circuitSuccessScript cierra el circuito. Retorna 1 si estaba abierto.
+var circuitSuccessScript = redis.NewScript(`
+	local state = redis.call("HGET", KEYS[1], "state")
+	redis.call("DEL", KEYS[1])
+	if state == "open" then
+		return 1
+	end
+	return 0
+`)
+
+// RecordCircuitSuccess registra una entrega exitosa. Retorna true si el circuito estaba abierto y se cerró.
+func (r *WebhookRepository) RecordCircuitSuccess(ctx context.Context, instanceID, webhookID string, global bool) (bool, error) {
+	recovered, err := circuitSuccessScript.Run(ctx, r.redis.Client,
+		[]string{circuitKey(instanceID, webhookID, global)},
+	).Int()
+	return recovered == 1, err
+}
+
+// claimProbeScript reserva el sondeo de un circuito abierto cuyo turno ya llegó,
+// moviendo el siguiente sondeo para que otro worker no envíe a la vez.
+var claimProbeScript = redis.NewScript(`
+	if redis.call("HGET", KEYS[1], "state") ~= "open" then
+		return 0
+	end
+	local next = tonumber(redis.call("HGET", KEYS[1], "next_probe_at") or "0")
+	if next > tonumber(ARGV[1]) then
+		return 0
+	end
+	redis.call("HSET", KEYS[1], "next_probe_at", ARGV[2])
+	return 1
+`)
+
+// ClaimCircuitProbe reserva el siguiente sondeo de un circuito abierto.
+// Retorna true si el llamador debe intentar la entrega como sondeo.
+func (r *WebhookRepository) ClaimCircuitProbe(ctx context.Context, instanceID, webhookID string, global bool, now, nextProbe time.Time) (bool, error) {
+	claimed, err := claimProbeScript.Run(ctx, r.redis.Client,
+		[]string{circuitKey(instanceID, webhookID, global)},
+		now.UnixMilli(), nextProbe.UnixMilli(),
+	).Int()
+	return claimed == 1, err
+}
+
+// ResetCircuit elimina el estado del circuit breaker de una suscripción
+func (r *WebhookRepository) ResetCircuit(ctx context.Context, instanceID, webhookID string, global bool) error {
+	return r.redis.Client.Del(ctx, circuitKey(instanceID, webhookID, global)).Err()
 }
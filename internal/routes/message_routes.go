@@ -17,6 +17,7 @@ func SetupMessageRoutes(r chi.Router, handler *handlers.MessageHandler) {
 		r.Post("/audio", handler.SendAudio)
 		r.Post("/document", handler.SendDocument)
 		r.Post("/location", handler.SendLocation)
+		r.Post("/contact", handler.SendContact)
 
 		// Interacciones
 		r.Post("/react", handler.React)
@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"kero-kero/internal/handlers"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func SetupQueueRoutes(r chi.Router, handler *handlers.QueueHandler) {
+	r.Get("/queue/stats", handler.Stats) // Mensajes pendientes por instancia
+
+	// Workers por réplica; solo con la API key o un token sin restricción de instancias
+	r.Get("/queue/workers", handler.Workers)
+	r.Put("/queue/workers", handler.ScaleWorkers)
+	r.Delete("/queue/workers", handler.ResetWorkers) // Vuelve a QUEUE_WORKERS
+
+	r.Get("/queue/{msgID}", handler.Get)
+	r.Delete("/queue/{msgID}", handler.Cancel) // Solo mientras sigue pendiente
+
+	r.Get("/instances/{instanceID}/queue", handler.List)
+	r.Get("/instances/{instanceID}/queue/depth", handler.Depth) // Mensajes pendientes de una instancia
+
+	// Mensajes que agotaron sus reintentos
+	r.Get("/instances/{instanceID}/queue/dead-letters", handler.ListDeadLetters)
+	r.Delete("/instances/{instanceID}/queue/dead-letters", handler.PurgeDeadLetters)
+	r.Post("/instances/{instanceID}/queue/dead-letters/requeue", handler.RequeueDeadLetters)
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"kero-kero/internal/handlers"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func SetupRatePolicyRoutes(r chi.Router, handler *handlers.RatePolicyHandler) {
+	r.Get("/instances/{instanceID}/rate-policy", handler.Get)
+	r.Put("/instances/{instanceID}/rate-policy", handler.Set)
+	r.Delete("/instances/{instanceID}/rate-policy", handler.Delete) // Vuelve a la política por defecto
+}
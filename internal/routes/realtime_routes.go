@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"kero-kero/internal/handlers"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func SetupRealtimeRoutes(r chi.Router, handler *handlers.RealtimeHandler) {
+	r.Get("/realtime/stats", handler.Stats) // Conexiones abiertas en esta réplica
+}
@@ -8,8 +8,32 @@ import (
 
 func SetupWebhookRoutes(r chi.Router, handler *handlers.WebhookHandler) {
 	r.Route("/instances/{instanceID}/webhook", func(r chi.Router) {
-		r.Post("/", handler.SetWebhook)
-		r.Get("/", handler.GetWebhook)
-		r.Delete("/", handler.DeleteWebhook)
+		r.Post("/", handler.CreateWebhook)
+		r.Get("/", handler.ListWebhooks)
+		r.Delete("/", handler.DeleteWebhooks)
+
+		// Entregas que agotaron sus reintentos
+		r.Get("/dead-letters", handler.ListDeadLetters)
+		r.Delete("/dead-letters", handler.PurgeDeadLetters)
+
+		// Historial de entregas y reenvío
+		r.Get("/deliveries", handler.ListDeliveries)
+		r.Post("/deliveries/replay", handler.ReplayDeliveries)
+		r.Get("/deliveries/{logID}", handler.GetDelivery)
+		r.Post("/deliveries/{logID}/replay", handler.ReplayDelivery)
+
+		// Suscripción individual
+		r.Get("/{webhookID}", handler.GetWebhook)
+		r.Put("/{webhookID}", handler.UpdateWebhook)
+		r.Delete("/{webhookID}", handler.DeleteWebhook)
+		r.Post("/{webhookID}/rotate-secret", handler.RotateWebhookSecret)
+	})
+	// Webhooks globales: reciben los eventos de todas las instancias
+	r.Route("/webhooks/global", func(r chi.Router) {
+		r.Post("/", handler.CreateGlobalWebhook)
+		r.Get("/", handler.ListGlobalWebhooks)
+		r.Get("/{webhookID}", handler.GetGlobalWebhook)
+		r.Put("/{webhookID}", handler.UpdateGlobalWebhook)
+		r.Delete("/{webhookID}", handler.DeleteGlobalWebhook)
 	})
 }
@@ -4,6 +4,9 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+
 	"kero-kero/internal/services"
 	"kero-kero/pkg/errors"
 )
@@ -28,9 +31,13 @@ func Auth(apiKey string, authService *services.AuthService) func(next http.Handl
 				token := strings.TrimPrefix(authHeader, "Bearer ")
 
 				// Validar token JWT
-				_, err := authService.ValidateToken(token)
+				claims, err := authService.ValidateToken(token)
 				if err == nil {
-					// Token JWT válido
+					// Token JWT válido; si está restringido a instancias, solo puede usar esas rutas
+					if !claims.CanAccessInstance(instanceFromPath(r.URL.Path)) {
+						errors.WriteJSON(w, errors.ErrForbidden.WithDetails("El token no tiene acceso a esta instancia"))
+						return
+					}
 					next.ServeHTTP(w, r)
 					return
 				}
@@ -55,6 +62,64 @@ func Auth(apiKey string, authService *services.AuthService) func(next http.Handl
 	}
 }
 
+// StreamAuth autentica los streams de eventos de una instancia (/ws y /events).
+// Ni el handshake WebSocket ni EventSource permiten headers propios en el navegador, por lo que
+// la API key o el JWT se aceptan también en el query (?token=) o como valor de Sec-WebSocket-Protocol.
+func StreamAuth(authService *services.AuthService) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := streamToken(r)
+			if token == "" {
+				errors.WriteJSON(w, errors.ErrUnauthorized.WithDetails("Autenticación inválida o faltante"))
+				return
+			}
+
+			claims, err := authService.Authenticate(token)
+			if err != nil {
+				errors.WriteJSON(w, errors.ErrUnauthorized.WithDetails("Autenticación inválida o faltante"))
+				return
+			}
+
+			if !claims.CanAccessInstance(chi.URLParam(r, "instanceID")) {
+				errors.WriteJSON(w, errors.ErrForbidden.WithDetails("El token no tiene acceso a esta instancia"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// streamToken obtiene la credencial del stream: query token, Authorization, X-API-Key
+// o el primer subprotocolo que no sea el de la API
+func streamToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	for _, protocol := range websocket.Subprotocols(r) {
+		if protocol != services.WebSocketSubprotocol {
+			return protocol
+		}
+	}
+	return ""
+}
+
+// instanceFromPath extrae el ID de instancia de las rutas /instances/{instanceID}/...
+func instanceFromPath(path string) string {
+	rest, ok := strings.CutPrefix(path, "/instances/")
+	if !ok {
+		return ""
+	}
+	instanceID, _, _ := strings.Cut(rest, "/")
+	return instanceID
+}
+
 // APIKeyAuth es un alias para mantener compatibilidad con código existente
 // DEPRECATED: Usar Auth en su lugar
 func APIKeyAuth(apiKey string) func(next http.Handler) http.Handler {
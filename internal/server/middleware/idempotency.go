@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"kero-kero/internal/services"
+	"kero-kero/pkg/errors"
+)
+
+// idempotencyMaxBody respuestas más grandes no se guardan (p. ej. descargas de media)
+const idempotencyMaxBody = 1 << 20
+
+// Idempotency atiende el header Idempotency-Key: la primera respuesta exitosa de cada clave se
+// guarda y los reintentos con la misma clave la reciben de nuevo sin repetir el envío.
+// Las respuestas con error liberan la clave para que el cliente pueda reintentar.
+func Idempotency(service *services.IdempotencyService) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > services.IdempotencyKeyMaxLen {
+				errors.WriteJSON(w, errors.ErrBadRequest.WithDetails("Idempotency-Key demasiado larga"))
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				errors.WriteJSON(w, errors.ErrBadRequest.WithDetails("No se pudo leer el cuerpo"))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			instanceID := chi.URLParam(r, "instanceID")
+			fingerprint := requestFingerprint(r, body)
+
+			stored, err := service.Begin(r.Context(), instanceID, key, fingerprint)
+			if err != nil {
+				if appErr, ok := err.(*errors.AppError); ok {
+					errors.WriteJSON(w, appErr)
+				} else {
+					errors.WriteJSON(w, errors.ErrInternalServer)
+				}
+				return
+			}
+			if stored != nil {
+				if stored.ContentType != "" {
+					w.Header().Set("Content-Type", stored.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.StatusCode)
+				w.Write(stored.Body)
+				return
+			}
+
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			// El contexto de la petición puede estar cancelado por el timeout; la respuesta se guarda igual
+			ctx := context.WithoutCancel(r.Context())
+			completed := false
+			defer func() {
+				if !completed {
+					service.Release(ctx, instanceID, key)
+				}
+			}()
+
+			next.ServeHTTP(rec, r)
+
+			if rec.status >= 200 && rec.status < 300 && !rec.overflow {
+				service.Complete(ctx, instanceID, key, fingerprint, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes())
+				completed = true
+			}
+		})
+	}
+}
+
+// requestFingerprint identifica la petición para detectar una clave reutilizada con otro contenido
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n" + r.Header.Get("X-Async") + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder escribe la respuesta al cliente y conserva una copia para guardarla
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	overflow    bool
+	wroteHeader bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	if !rec.overflow {
+		if rec.body.Len()+len(p) > idempotencyMaxBody {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(p)
+		}
+	}
+	return rec.ResponseWriter.Write(p)
+}
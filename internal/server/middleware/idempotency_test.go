@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+
+	"kero-kero/internal/repository"
+	"kero-kero/internal/services"
+	"kero-kero/internal/testutil"
+	"kero-kero/pkg/errors"
+)
+
+func TestIdempotency(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	service := services.NewIdempotencyService(repository.NewIdempotencyRepository(&repository.RedisClient{Client: redisClient}), time.Hour)
+
+	sends := 0
+	fail := false
+	r := chi.NewRouter()
+	r.Use(Idempotency(service))
+	r.Post("/instances/{instanceID}/messages/text", func(w http.ResponseWriter, r *http.Request) {
+		sends++
+		if fail {
+			errors.WriteJSON(w, errors.ErrNotAuthenticated)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "send": sends})
+	})
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/instances/ventas/messages/text", bytes.NewBufferString(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("el reintento recibe la primera respuesta", func(t *testing.T) {
+		first := send("k1", `{"phone":"1","message":"hola"}`)
+		assert.Equal(t, http.StatusOK, first.Code)
+
+		retry := send("k1", `{"phone":"1","message":"hola"}`)
+		assert.Equal(t, http.StatusOK, retry.Code)
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, 1, sends)
+	})
+
+	t.Run("otro cuerpo con la misma clave", func(t *testing.T) {
+		w := send("k1", `{"phone":"1","message":"adiós"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, 1, sends)
+	})
+
+	t.Run("sin clave no se deduplica", func(t *testing.T) {
+		send("", `{"phone":"1","message":"hola"}`)
+		send("", `{"phone":"1","message":"hola"}`)
+		assert.Equal(t, 3, sends)
+	})
+
+	t.Run("un error libera la clave", func(t *testing.T) {
+		fail = true
+		assert.Equal(t, http.StatusUnauthorized, send("k2", `{}`).Code)
+		fail = false
+		w := send("k2", `{}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, 5, sends)
+	})
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -26,10 +27,24 @@ func NewAuthService(jwtSecret, apiKey string) *AuthService {
 
 // JWTClaims representa los claims del token JWT
 type JWTClaims struct {
-	Subject   string `json:"sub"`           // Usuario o identificador
-	IssuedAt  int64  `json:"iat"`           // Tiempo de emisión
-	ExpiresAt int64  `json:"exp"`           // Tiempo de expiración
-	Type      string `json:"type,omitempty"`// Tipo de token (dashboard, api, etc)
+	Subject   string   `json:"sub"`                 // Usuario o identificador
+	IssuedAt  int64    `json:"iat"`                 // Tiempo de emisión
+	ExpiresAt int64    `json:"exp"`                 // Tiempo de expiración
+	Type      string   `json:"type,omitempty"`      // Tipo de token (dashboard, api, etc)
+	Instances []string `json:"instances,omitempty"` // Instancias permitidas; vacío da acceso a todas
+}
+
+// CanAccessInstance indica si el token puede operar sobre la instancia
+func (c *JWTClaims) CanAccessInstance(instanceID string) bool {
+	if len(c.Instances) == 0 {
+		return true
+	}
+	for _, id := range c.Instances {
+		if id == instanceID {
+			return true
+		}
+	}
+	return false
 }
 
 // LoginRequest representa la solicitud de login
@@ -45,8 +60,9 @@ type LoginResponse struct {
 }
 
 // Login valida las credenciales y genera un token JWT
-// Por ahora solo valida la API_KEY, pero se puede extender para usuario/contraseña
-func (s *AuthService) Login(apiKey string) (*LoginResponse, error) {
+// Por ahora solo valida la API_KEY, pero se puede extender para usuario/contraseña.
+// Si se indican instancias, el token solo da acceso a ellas.
+func (s *AuthService) Login(apiKey string, instances ...string) (*LoginResponse, error) {
 	// Validar que la API key sea correcta
 	if apiKey != s.apiKey {
 		return nil, fmt.Errorf("credenciales inválidas")
@@ -60,6 +76,7 @@ func (s *AuthService) Login(apiKey string) (*LoginResponse, error) {
 		IssuedAt:  time.Now().Unix(),
 		ExpiresAt: expiresAt,
 		Type:      "dashboard",
+		Instances: instances,
 	}
 
 	token, err := s.generateJWT(claims)
@@ -110,6 +127,19 @@ func (s *AuthService) ValidateToken(token string) (*JWTClaims, error) {
 	return &claims, nil
 }
 
+// Authenticate valida una credencial que puede ser la API key o un token JWT.
+// La API key equivale a un token sin restricción de instancias.
+func (s *AuthService) Authenticate(credential string) (*JWTClaims, error) {
+	credential = strings.TrimPrefix(credential, "Bearer ")
+	if credential == "" {
+		return nil, fmt.Errorf("credencial vacía")
+	}
+	if s.apiKey != "" && subtle.ConstantTimeCompare([]byte(credential), []byte(s.apiKey)) == 1 {
+		return &JWTClaims{Subject: "api_key", Type: "api_key"}, nil
+	}
+	return s.ValidateToken(credential)
+}
+
 // generateJWT genera un token JWT con los claims proporcionados
 func (s *AuthService) generateJWT(claims JWTClaims) (string, error) {
 	// Header del JWT
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthService_Authenticate(t *testing.T) {
+	auth := NewAuthService("secreto", "api-key")
+
+	t.Run("api key sin restricción", func(t *testing.T) {
+		claims, err := auth.Authenticate("api-key")
+		require.NoError(t, err)
+		assert.True(t, claims.CanAccessInstance("ventas"))
+	})
+
+	t.Run("token limitado a instancias", func(t *testing.T) {
+		resp, err := auth.Login("api-key", "ventas")
+		require.NoError(t, err)
+
+		claims, err := auth.Authenticate("Bearer " + resp.Token)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ventas"}, claims.Instances)
+		assert.True(t, claims.CanAccessInstance("ventas"))
+		assert.False(t, claims.CanAccessInstance("soporte"))
+		assert.False(t, claims.CanAccessInstance(""))
+	})
+
+	t.Run("credenciales inválidas", func(t *testing.T) {
+		_, err := auth.Authenticate("otra-key")
+		assert.Error(t, err)
+		_, err = auth.Authenticate("")
+		assert.Error(t, err)
+		_, err = auth.Login("otra-key")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"kero-kero/internal/models"
+	"kero-kero/internal/repository"
+	"kero-kero/pkg/errors"
+)
+
+const (
+	// idempotencyLockTTL cuánto se reserva una clave mientras se atiende la primera petición.
+	// Supera el timeout de las peticiones HTTP (60s) para cubrir los envíos que lo agotan.
+	idempotencyLockTTL = 2 * time.Minute
+	// idempotencyRetryAfter espera sugerida al repetir una clave que sigue en curso
+	idempotencyRetryAfter = 5 * time.Second
+	// IdempotencyKeyMaxLen longitud máxima aceptada para el header Idempotency-Key
+	IdempotencyKeyMaxLen = 255
+)
+
+var (
+	errIdempotencyInProgress = errors.ErrConflict.WithDetails("Ya hay una petición en curso con esta Idempotency-Key").WithRetryAfter(idempotencyRetryAfter)
+	errIdempotencyMismatch   = errors.New(http.StatusUnprocessableEntity, "Idempotency-Key reutilizada con otra petición")
+)
+
+// IdempotencyService guarda la primera respuesta de cada Idempotency-Key para que los reintentos
+// del cliente la reciban de nuevo en lugar de repetir el envío
+type IdempotencyService struct {
+	repo *repository.IdempotencyRepository
+	ttl  time.Duration
+}
+
+func NewIdempotencyService(repo *repository.IdempotencyRepository, ttl time.Duration) *IdempotencyService {
+	return &IdempotencyService{repo: repo, ttl: ttl}
+}
+
+// Begin reserva la clave para la petición. Retorna nil si la petición debe procesarse o la
+// respuesta guardada si ya se atendió. Una clave en curso responde 409 y una clave usada con
+// otra petición (fingerprint distinto) responde 422.
+func (s *IdempotencyService) Begin(ctx context.Context, instanceID, key, fingerprint string) (*models.IdempotencyRecord, error) {
+	record := &models.IdempotencyRecord{
+		Status:      models.IdempotencyProcessing,
+		Fingerprint: fingerprint,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	stored, err := s.repo.Reserve(ctx, instanceID, key, record, idempotencyLockTTL)
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	if stored == nil {
+		return nil, nil
+	}
+	if stored.Fingerprint != fingerprint {
+		return nil, errIdempotencyMismatch
+	}
+	if stored.Status != models.IdempotencyCompleted {
+		return nil, errIdempotencyInProgress
+	}
+	return stored, nil
+}
+
+// Complete guarda la respuesta de la clave para repetirla durante el TTL configurado
+func (s *IdempotencyService) Complete(ctx context.Context, instanceID, key, fingerprint string, statusCode int, contentType string, body []byte) {
+	record := &models.IdempotencyRecord{
+		Status:      models.IdempotencyCompleted,
+		Fingerprint: fingerprint,
+		StatusCode:  statusCode,
+		ContentType: contentType,
+		Body:        body,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if err := s.repo.Save(ctx, instanceID, key, record, s.ttl); err != nil {
+		log.Error().Err(err).Str("instance_id", instanceID).Msg("Error guardando respuesta idempotente")
+	}
+}
+
+// Release libera la clave sin guardar respuesta, para que un reintento vuelva a procesarse
+func (s *IdempotencyService) Release(ctx context.Context, instanceID, key string) {
+	if err := s.repo.Release(ctx, instanceID, key); err != nil {
+		log.Error().Err(err).Str("instance_id", instanceID).Msg("Error liberando Idempotency-Key")
+	}
+}
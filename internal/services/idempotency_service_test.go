@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"kero-kero/internal/repository"
+	"kero-kero/internal/testutil"
+	"kero-kero/pkg/errors"
+)
+
+func TestIdempotencyService(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	s := NewIdempotencyService(repository.NewIdempotencyRepository(&repository.RedisClient{Client: redisClient}), time.Hour)
+
+	// Primera petición: se procesa
+	stored, err := s.Begin(ctx, "ventas", "pedido-42", "fp1")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+
+	// Un reintento mientras sigue en curso recibe 409
+	_, err = s.Begin(ctx, "ventas", "pedido-42", "fp1")
+	require.Error(t, err)
+	assert.Equal(t, http.StatusConflict, err.(*errors.AppError).Code)
+
+	// La misma clave en otra instancia es independiente
+	stored, err = s.Begin(ctx, "soporte", "pedido-42", "fp1")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+
+	s.Complete(ctx, "ventas", "pedido-42", "fp1", http.StatusAccepted, "application/json", []byte(`{"message_id":"msg_1"}`))
+
+	stored, err = s.Begin(ctx, "ventas", "pedido-42", "fp1")
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, http.StatusAccepted, stored.StatusCode)
+	assert.JSONEq(t, `{"message_id":"msg_1"}`, string(stored.Body))
+
+	// Reutilizar la clave con otro contenido es un error del cliente
+	_, err = s.Begin(ctx, "ventas", "pedido-42", "fp2")
+	require.Error(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, err.(*errors.AppError).Code)
+
+	// Una clave liberada vuelve a procesarse
+	s.Release(ctx, "soporte", "pedido-42")
+	stored, err = s.Begin(ctx, "soporte", "pedido-42", "fp1")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+
+	// La respuesta guardada vence con el TTL
+	mr.FastForward(time.Hour + time.Second)
+	stored, err = s.Begin(ctx, "ventas", "pedido-42", "fp2")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+}
@@ -96,15 +96,20 @@ func (s *InstanceService) GetInstanceWithWebhook(ctx context.Context, instanceID
 		"updated_at":        instance.UpdatedAt,
 	}
 
-	// Intentar cargar configuración de webhook desde Redis
-	webhookConfig, err := s.webhookService.GetWebhook(ctx, instanceID)
-	if err == nil && webhookConfig != nil {
-		result["webhook_config"] = map[string]interface{}{
-			"url":     webhookConfig.URL,
-			"events":  webhookConfig.Events,
-			"secret":  webhookConfig.Secret,
-			"enabled": webhookConfig.Enabled,
+	// Intentar cargar las suscripciones de webhook desde Redis
+	webhookConfigs, err := s.webhookService.ListWebhooks(ctx, instanceID)
+	if err == nil && len(webhookConfigs) > 0 {
+		webhooks := make([]map[string]interface{}, 0, len(webhookConfigs))
+		for _, webhookConfig := range webhookConfigs {
+			webhooks = append(webhooks, map[string]interface{}{
+				"id":      webhookConfig.ID,
+				"url":     webhookConfig.URL,
+				"events":  webhookConfig.Events,
+				"secret":  webhookConfig.Secret,
+				"enabled": webhookConfig.Enabled,
+			})
 		}
+		result["webhooks"] = webhooks
 	}
 
 	return result, nil
@@ -28,8 +28,9 @@ import (
 // ... (resto del código)
 
 type MessageService struct {
-	waManager *whatsapp.Manager
-	msgRepo   *repository.MessageRepository
+	waManager  *whatsapp.Manager
+	msgRepo    *repository.MessageRepository
+	ratePolicy *RatePolicyService
 }
 
 func NewMessageService(waManager *whatsapp.Manager, msgRepo *repository.MessageRepository) *MessageService {
@@ -39,6 +40,20 @@ func NewMessageService(waManager *whatsapp.Manager, msgRepo *repository.MessageR
 	}
 }
 
+// SetRatePolicy configura la política de envío que se aplica antes de cada mensaje saliente
+func (s *MessageService) SetRatePolicy(ratePolicy *RatePolicyService) {
+	s.ratePolicy = ratePolicy
+}
+
+// checkSendRate verifica la política de envío de la instancia (429 con retry_after si no se permite).
+// Consume cuota, por eso se llama justo antes de SendMessage: una media que no se pudo descargar no cuenta.
+func (s *MessageService) checkSendRate(ctx context.Context, instanceID string) error {
+	if s.ratePolicy == nil {
+		return nil
+	}
+	return s.ratePolicy.Check(ctx, instanceID)
+}
+
 // SendText envía un mensaje de texto
 func (s *MessageService) SendText(ctx context.Context, instanceID string, req *models.SendTextRequest) (*models.MessageResponse, error) {
 	client := s.waManager.GetClient(instanceID)
@@ -56,6 +71,10 @@ func (s *MessageService) SendText(ctx context.Context, instanceID string, req *m
 		return nil, errors.ErrNotAuthenticated
 	}
 
+	if err := s.checkSendRate(ctx, instanceID); err != nil {
+		return nil, err
+	}
+
 	recipientJID := types.NewJID(cleanPhone, types.DefaultUserServer)
 
 	msg := &waE2E.Message{
@@ -226,6 +245,10 @@ func (s *MessageService) SendImage(ctx context.Context, instanceID string, req *
 		},
 	}
 
+	if err := s.checkSendRate(ctx, instanceID); err != nil {
+		return nil, err
+	}
+
 	resp, err := client.WAClient.SendMessage(ctx, recipientJID, msg)
 	if err != nil {
 		log.Error().
@@ -311,6 +334,10 @@ func (s *MessageService) SendVideo(ctx context.Context, instanceID string, req *
 		},
 	}
 
+	if err := s.checkSendRate(ctx, instanceID); err != nil {
+		return nil, err
+	}
+
 	resp, err := client.WAClient.SendMessage(ctx, recipientJID, msg)
 	if err != nil {
 		log.Error().
@@ -396,6 +423,10 @@ func (s *MessageService) SendAudio(ctx context.Context, instanceID string, req *
 		},
 	}
 
+	if err := s.checkSendRate(ctx, instanceID); err != nil {
+		return nil, err
+	}
+
 	resp, err := client.WAClient.SendMessage(ctx, recipientJID, msg)
 	if err != nil {
 		log.Error().
@@ -482,6 +513,10 @@ func (s *MessageService) SendDocument(ctx context.Context, instanceID string, re
 		},
 	}
 
+	if err := s.checkSendRate(ctx, instanceID); err != nil {
+		return nil, err
+	}
+
 	resp, err := client.WAClient.SendMessage(ctx, recipientJID, msg)
 	if err != nil {
 		log.Error().
@@ -543,6 +578,10 @@ func (s *MessageService) SendLocation(ctx context.Context, instanceID string, re
 		return nil, errors.ErrNotAuthenticated
 	}
 
+	if err := s.checkSendRate(ctx, instanceID); err != nil {
+		return nil, err
+	}
+
 	recipientJID := types.NewJID(cleanPhone, types.DefaultUserServer)
 
 	msg := &waE2E.Message{
@@ -615,6 +654,10 @@ func (s *MessageService) SendContact(ctx context.Context, instanceID string, req
 		return nil, errors.ErrNotAuthenticated
 	}
 
+	if err := s.checkSendRate(ctx, instanceID); err != nil {
+		return nil, err
+	}
+
 	recipientJID := types.NewJID(cleanPhone, types.DefaultUserServer)
 
 	msg := &waE2E.Message{
@@ -696,6 +739,10 @@ func (s *MessageService) ReactToMessage(ctx context.Context, instanceID string,
 		},
 	}
 
+	if err := s.checkSendRate(ctx, instanceID); err != nil {
+		return nil, err
+	}
+
 	resp, err := client.WAClient.SendMessage(ctx, recipientJID, msg)
 	if err != nil {
 		log.Error().
@@ -750,6 +797,10 @@ func (s *MessageService) RevokeMessage(ctx context.Context, instanceID string, r
 		},
 	}
 
+	if err := s.checkSendRate(ctx, instanceID); err != nil {
+		return nil, err
+	}
+
 	resp, err := client.WAClient.SendMessage(ctx, recipientJID, msg)
 	if err != nil {
 		log.Error().
@@ -790,6 +841,10 @@ func (s *MessageService) CreatePoll(ctx context.Context, instanceID string, req
 		return nil, errors.ErrNotAuthenticated
 	}
 
+	if err := s.checkSendRate(ctx, instanceID); err != nil {
+		return nil, err
+	}
+
 	recipientJID := types.NewJID(cleanPhone, types.DefaultUserServer)
 
 	// Aquí es donde el SDK se encarga de todo lo pesado de construir la encuesta.
@@ -851,6 +906,10 @@ func (s *MessageService) VotePoll(ctx context.Context, instanceID string, req *m
 		return nil, errors.ErrInternalServer.WithDetails(fmt.Sprintf("Me fue imposible construir el voto: %v", err))
 	}
 
+	if err := s.checkSendRate(ctx, instanceID); err != nil {
+		return nil, err
+	}
+
 	resp, err := client.WAClient.SendMessage(ctx, recipientJID, msg)
 	if err != nil {
 		log.Error().
@@ -979,6 +1038,10 @@ func (s *MessageService) SendTextWithTyping(ctx context.Context, instanceID stri
 		return nil, errors.ErrNotAuthenticated
 	}
 
+	if err := s.checkSendRate(ctx, instanceID); err != nil {
+		return nil, err
+	}
+
 	recipientJID := types.NewJID(cleanPhone, types.DefaultUserServer)
 
 	// Calcular duración de typing si no se proporcionó
@@ -1131,6 +1194,10 @@ func (s *MessageService) EditMessage(ctx context.Context, instanceID string, req
 		Conversation: proto.String(req.NewText),
 	})
 
+	if err := s.checkSendRate(ctx, instanceID); err != nil {
+		return nil, err
+	}
+
 	resp, err := client.WAClient.SendMessage(ctx, recipientJID, editMsg)
 	if err != nil {
 		log.Error().Err(err).Str("instance_id", instanceID).Str("msg_id", req.MessageID).Msg("Error editando mensaje")
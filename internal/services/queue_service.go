@@ -4,8 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 
 	"kero-kero/internal/models"
@@ -13,30 +17,106 @@ import (
 	"kero-kero/pkg/errors"
 )
 
-// QueueService gestiona la cola de mensajes
+const (
+	queuePollInterval  = 500 * time.Millisecond // Espera de un worker cuando ninguna instancia tiene mensajes pendientes
+	queueMaxRetries    = 3                      // Reintentos de un mensaje antes de marcarlo fallido
+	queueDeadLetterMax = 1000                   // Mensajes fallidos que se conservan por instancia
+	queueRetryBackoff  = 2 * time.Second        // Espera antes de reintentar, multiplicada por el número de intento
+	queuePromoteBatch  = 100                    // Reintentos vencidos que se devuelven a la cola por pasada
+	queueMaxWorkers    = 100                    // Máximo de workers por réplica
+
+	// Cada worker renueva su lease mientras vive. El reaper devuelve a la cola los mensajes
+	// de las listas de procesamiento cuyo lease venció (la réplica se cayó a mitad de un envío).
+	queueLeaseTTL       = 30 * time.Second
+	queueLeaseRenewal   = 10 * time.Second
+	queueReaperInterval = 30 * time.Second
+)
+
+// QueueService gestiona las colas de mensajes. Cada instancia tiene su propia cola y los
+// workers las atienden por turnos, así una ráfaga de una instancia no frena a las demás.
 type QueueService struct {
 	redisClient *repository.RedisClient
+	queueRepo   *repository.QueueRepository
 	msgService  *MessageService
-	workers     int
+	webhookSvc  *WebhookService
+	notifier    WebhookEventNotifier // Eventos de la cola por WebSocket (opcional)
+	workers     int                  // Workers por réplica de la configuración, salvo que se fijen por API
+	nodeID      string               // Identifica a esta réplica en las listas de procesamiento
 	stopChan    chan struct{}
+
+	mu           sync.Mutex
+	started      bool
+	running      []queueWorker    // Workers activos de esta réplica
+	stopping     map[int]struct{} // Workers detenidos que todavía terminan su envío; conservan su lease
+	nextWorkerID int              // Los IDs no se reutilizan: un worker que se detiene puede seguir terminando su envío
+
+	process func(ctx context.Context, msg *models.QueuedMessage) (string, error) // Envía un mensaje; por defecto processMessage
+}
+
+// queueWorker worker activo y el canal que lo detiene
+type queueWorker struct {
+	id   int
+	stop chan struct{}
 }
 
 // NewQueueService crea un nuevo servicio de colas
 func NewQueueService(redisClient *repository.RedisClient, msgService *MessageService) *QueueService {
-	return &QueueService{
+	s := &QueueService{
 		redisClient: redisClient,
+		queueRepo:   repository.NewQueueRepository(redisClient),
 		msgService:  msgService,
 		workers:     3, // Default 3 workers
+		nodeID:      uuid.New().String(),
 		stopChan:    make(chan struct{}),
+		stopping:    make(map[int]struct{}),
 	}
+	s.process = s.processMessage
+	return s
+}
+
+// SetWebhookService configura el servicio de webhooks para notificar los eventos de la cola
+func (s *QueueService) SetWebhookService(webhookSvc *WebhookService) {
+	s.webhookSvc = webhookSvc
+}
+
+// SetWorkers configura los workers por réplica (QUEUE_WORKERS). Se llama antes de Start;
+// en caliente se cambian con ScaleWorkers.
+func (s *QueueService) SetWorkers(n int) {
+	if n > 0 {
+		s.workers = n
+	}
+}
+
+// SetEventNotifier configura a quién se avisan los eventos de la cola además de los webhooks (p. ej. el WebSocket)
+func (s *QueueService) SetEventNotifier(notifier WebhookEventNotifier) {
+	s.notifier = notifier
 }
 
 // Start inicia los workers
 func (s *QueueService) Start() {
-	log.Info().Int("workers", s.workers).Msg("Iniciando workers de cola de mensajes")
-	for i := 0; i < s.workers; i++ {
-		go s.workerLoop(i)
+	// Mensajes que quedaron en la cola global de versiones anteriores
+	if n, err := s.queueRepo.MigrateLegacy(context.Background()); err != nil {
+		log.Error().Err(err).Msg("Error migrando la cola global de mensajes")
+	} else if n > 0 {
+		log.Info().Int("count", n).Msg("Mensajes de la cola global repartidos por instancia")
+	}
+
+	workers, err := s.desiredWorkers(context.Background())
+	if err != nil {
+		log.Error().Err(err).Msg("Error leyendo los workers de cola fijados por API, se usa la configuración")
+		workers = s.workers
 	}
+
+	// resize toma el lease de cada worker antes de arrancarlo, así el reaper no ve huérfanos a los propios workers
+	log.Info().Int("workers", workers).Msg("Iniciando workers de cola de mensajes")
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+	s.resize(workers)
+
+	go s.leaseLoop()
+	go s.reaperLoop()
+	go s.retryLoop()
 }
 
 // Stop detiene los workers
@@ -46,49 +126,253 @@ func (s *QueueService) Stop() {
 
 // EnqueueMessage encola un mensaje para envío asíncrono
 func (s *QueueService) EnqueueMessage(ctx context.Context, instanceID string, msgType models.MessageType, payload interface{}) (string, error) {
-	msgID := fmt.Sprintf("msg_%d", time.Now().UnixNano())
+	return s.EnqueueMessageWithOptions(ctx, instanceID, msgType, payload, models.QueueOptions{})
+}
+
+// EnqueueMessageWithOptions encola un mensaje con prioridad y vencimiento
+func (s *QueueService) EnqueueMessageWithOptions(ctx context.Context, instanceID string, msgType models.MessageType, payload interface{}, opts models.QueueOptions) (string, error) {
+	switch opts.Priority {
+	case "", models.QueuePriorityHigh, models.QueuePriorityNormal, models.QueuePriorityBulk:
+	default:
+		return "", errors.ErrBadRequest.WithDetails("prioridad inválida (high, normal, bulk)")
+	}
+
+	now := time.Now()
+	msgID := fmt.Sprintf("msg_%d", now.UnixNano())
 	queuedMsg := &models.QueuedMessage{
 		ID:         msgID,
 		InstanceID: instanceID,
 		Type:       msgType,
 		Payload:    payload,
-		CreatedAt:  time.Now().Unix(),
+		CreatedAt:  now.Unix(),
 		Attempts:   0,
+		Priority:   opts.Priority,
 	}
-
-	jsonBytes, err := json.Marshal(queuedMsg)
-	if err != nil {
-		return "", err
+	if !opts.ExpiresAt.IsZero() {
+		if !opts.ExpiresAt.After(now) {
+			return "", errors.ErrBadRequest.WithDetails("expires_at ya pasó")
+		}
+		queuedMsg.ExpiresAt = opts.ExpiresAt.Unix()
 	}
 
-	queueKey := "queue:messages"
-	if err := s.redisClient.EnqueueMessage(ctx, queueKey, string(jsonBytes)); err != nil {
+	if _, err := s.queueRepo.Enqueue(ctx, queuedMsg); err != nil {
 		return "", err
 	}
 
 	return msgID, nil
 }
 
-func (s *QueueService) workerLoop(id int) {
-	queueKey := "queue:messages"
-	processingKey := fmt.Sprintf("queue:processing:%d", id)
+// ListQueue lista los mensajes encolados de una instancia. Sin estados, devuelve los que
+// siguen pendientes, en envío o fallidos.
+func (s *QueueService) ListQueue(ctx context.Context, instanceID string, statuses []string, limit, offset int) ([]*models.QueueItem, int, error) {
+	if len(statuses) == 0 {
+		statuses = []string{models.QueueStatusPending, models.QueueStatusProcessing, models.QueueStatusFailed, models.QueueStatusExpired}
+	}
+	for _, status := range statuses {
+		switch status {
+		case models.QueueStatusPending, models.QueueStatusProcessing, models.QueueStatusSent,
+			models.QueueStatusFailed, models.QueueStatusCancelled, models.QueueStatusExpired:
+		default:
+			return nil, 0, errors.ErrBadRequest.WithDetails("status inválido (pending, processing, sent, failed, cancelled, expired)")
+		}
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	items, total, err := s.queueRepo.List(ctx, instanceID, statuses, limit, offset)
+	if err != nil {
+		return nil, 0, errors.ErrInternalServer.Wrap(err)
+	}
+	if items == nil {
+		items = []*models.QueueItem{}
+	}
+	return items, total, nil
+}
+
+// GetQueueItem obtiene el estado de un mensaje encolado
+func (s *QueueService) GetQueueItem(ctx context.Context, msgID string) (*models.QueueItem, error) {
+	item, err := s.queueRepo.Item(ctx, msgID)
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	if item == nil {
+		return nil, errors.ErrNotFound.WithDetails("Mensaje no encontrado en la cola")
+	}
+	return item, nil
+}
+
+// CancelQueueItem cancela un mensaje que todavía no se envió. Cancelar un mensaje ya cancelado no es un error.
+func (s *QueueService) CancelQueueItem(ctx context.Context, msgID string) (*models.QueueItem, error) {
+	status, err := s.queueRepo.Cancel(ctx, msgID)
+	if err == redis.Nil {
+		return nil, errors.ErrNotFound.WithDetails("Mensaje no encontrado en la cola")
+	}
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	if status != models.QueueStatusCancelled {
+		return nil, errors.ErrConflict.WithDetails(fmt.Sprintf("El mensaje no se puede cancelar en estado %s", status))
+	}
+
+	log.Info().Str("msg_id", msgID).Msg("Mensaje de cola cancelado")
+	return s.GetQueueItem(ctx, msgID)
+}
+
+// QueueDepths devuelve los mensajes pendientes de cada instancia
+func (s *QueueService) QueueDepths(ctx context.Context) (map[string]int64, error) {
+	depths, err := s.queueRepo.Depths(ctx)
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	return depths, nil
+}
+
+// QueueDepth devuelve los mensajes pendientes de una instancia
+func (s *QueueService) QueueDepth(ctx context.Context, instanceID string) (int64, error) {
+	depth, err := s.queueRepo.Depth(ctx, instanceID)
+	if err != nil {
+		return 0, errors.ErrInternalServer.Wrap(err)
+	}
+	return depth, nil
+}
+
+// QueueLaneDepths devuelve los mensajes pendientes de una instancia por prioridad
+func (s *QueueService) QueueLaneDepths(ctx context.Context, instanceID string) (map[string]int64, error) {
+	depths, err := s.queueRepo.LaneDepths(ctx, instanceID)
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	return depths, nil
+}
+
+// DelayedRetries cuenta los mensajes que esperan su próximo reintento
+func (s *QueueService) DelayedRetries(ctx context.Context) (int64, error) {
+	n, err := s.queueRepo.DelayedCount(ctx)
+	if err != nil {
+		return 0, errors.ErrInternalServer.Wrap(err)
+	}
+	return n, nil
+}
+
+// Workers devuelve los workers por réplica y los que están activos en esta réplica
+func (s *QueueService) Workers(ctx context.Context) (*models.QueueWorkers, error) {
+	override, err := s.queueRepo.WorkerCount(ctx)
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+
+	s.mu.Lock()
+	running := len(s.running)
+	s.mu.Unlock()
+
+	workers := &models.QueueWorkers{Workers: s.workers, Default: s.workers, Running: running}
+	if override > 0 {
+		workers.Workers = override
+		workers.Override = true
+	}
+	return workers, nil
+}
+
+// ScaleWorkers fija los workers por réplica. Se aplica de inmediato en esta réplica y las demás
+// lo toman en la siguiente renovación de leases.
+func (s *QueueService) ScaleWorkers(ctx context.Context, n int) (*models.QueueWorkers, error) {
+	if n < 1 || n > queueMaxWorkers {
+		return nil, errors.ErrBadRequest.WithDetails(fmt.Sprintf("workers debe estar entre 1 y %d", queueMaxWorkers))
+	}
+	if err := s.queueRepo.SetWorkerCount(ctx, n); err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	s.resize(n)
+	return s.Workers(ctx)
+}
+
+// ResetWorkers quita el valor fijado por API y vuelve a los workers de la configuración
+func (s *QueueService) ResetWorkers(ctx context.Context) (*models.QueueWorkers, error) {
+	if err := s.queueRepo.ClearWorkerCount(ctx); err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	s.resize(s.workers)
+	return s.Workers(ctx)
+}
+
+// desiredWorkers workers que debe tener esta réplica: los fijados por API o los de la configuración
+func (s *QueueService) desiredWorkers(ctx context.Context) (int, error) {
+	n, err := s.queueRepo.WorkerCount(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return s.workers, nil
+	}
+	return n, nil
+}
+
+// resize arranca o detiene workers hasta tener n. Un worker detenido termina el mensaje que
+// está enviando antes de salir. No hace nada mientras el servicio no se haya iniciado.
+func (s *QueueService) resize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started || n == len(s.running) {
+		return
+	}
+	if len(s.running) > 0 {
+		log.Info().Int("from", len(s.running)).Int("to", n).Msg("Ajustando workers de cola de mensajes")
+	}
+
+	for len(s.running) < n {
+		worker := queueWorker{id: s.nextWorkerID, stop: make(chan struct{})}
+		s.nextWorkerID++
+		s.renewLease(worker.id)
+		s.running = append(s.running, worker)
+		go s.workerLoop(worker.id, worker.stop)
+	}
+	for len(s.running) > n {
+		last := len(s.running) - 1
+		close(s.running[last].stop)
+		s.stopping[s.running[last].id] = struct{}{}
+		s.running = s.running[:last]
+	}
+}
+
+// workerStopped saca al worker de los leases que se renuevan y libera el suyo. Hasta aquí el
+// worker puede seguir enviando un mensaje; si su lease venciera antes, el reaper lo reenviaría.
+func (s *QueueService) workerStopped(id int, processingKey string) {
+	s.mu.Lock()
+	delete(s.stopping, id)
+	s.mu.Unlock()
+
+	s.queueRepo.ReleaseLease(context.Background(), processingKey)
+	log.Debug().Int("worker_id", id).Msg("Worker detenido")
+}
+
+func (s *QueueService) workerLoop(id int, stop <-chan struct{}) {
+	processingKey := repository.QueueProcessingKey(s.nodeID, id)
 	log.Debug().Int("worker_id", id).Msg("Worker iniciado")
 
 	for {
 		select {
 		case <-s.stopChan:
-			log.Debug().Int("worker_id", id).Msg("Worker detenido")
+			s.workerStopped(id, processingKey)
+			return
+		case <-stop:
+			s.workerStopped(id, processingKey)
 			return
 		default:
 			// Usar pop confiable para evitar pérdida de mensajes en crashes
-			data, err := s.redisClient.DequeueMessageReliable(context.Background(), queueKey, processingKey, 2*time.Second)
+			data, err := s.queueRepo.Dequeue(context.Background(), processingKey)
 			if err != nil {
-				// redis.Nil es normal cuando el timeout ocurre y la cola está vacía
-				if err.Error() == "redis: nil" {
+				// redis.Nil: ninguna instancia tiene mensajes pendientes
+				if err == redis.Nil {
+					s.wait(stop, queuePollInterval)
 					continue
 				}
 				log.Error().Err(err).Int("worker_id", id).Msg("Error extrayendo de la cola")
-				time.Sleep(1 * time.Second)
+				s.wait(stop, 1*time.Second)
 				continue
 			}
 
@@ -97,40 +381,73 @@ func (s *QueueService) workerLoop(id int) {
 			}
 
 			// Procesar el mensaje
-			if err := s.processMessage(data); err != nil {
-				if err == errors.ErrRateLimitReached {
-					log.Warn().Int("worker_id", id).Msg("Rate limit alcanzado para la instancia. Re-encolando con delay.")
-					s.handleRateLimitRetry(data)
-				} else {
-					log.Error().Err(err).Int("worker_id", id).Msg("Error procesando mensaje, re-encolando si es posible")
-					s.handleRetry(data)
-				}
-			}
+			s.handleMessage(id, data)
 
 			// Una vez procesado (con éxito o fallido tras reintentos), quitar de la cola de procesamiento
-			if err := s.redisClient.AckMessage(context.Background(), processingKey, data); err != nil {
+			if err := s.queueRepo.Ack(context.Background(), processingKey, data); err != nil {
 				log.Error().Err(err).Int("worker_id", id).Msg("Error haciendo ACK de mensaje")
 			}
 		}
 	}
 }
 
-func (s *QueueService) processMessage(data string) error {
+// handleMessage envía un mensaje extraído de la cola y registra su estado
+func (s *QueueService) handleMessage(workerID int, data string) {
 	var msg models.QueuedMessage
 	if err := json.Unmarshal([]byte(data), &msg); err != nil {
 		log.Error().Err(err).Str("data", data).Msg("Error deserializando mensaje de cola")
-		return nil // No reintentamos error de formato
+		return // No reintentamos error de formato
 	}
 
 	ctx := context.Background()
+	if msg.Expired(time.Now()) {
+		s.expire(&msg)
+		return
+	}
 
-	// Verificar Rate Limit (20 mensajes por minuto por instancia)
-	// He implementado esto aquí para que sea la primera línea de defensa antes de tocar el cliente WA.
-	allowed, err := s.redisClient.CheckRateLimit(ctx, msg.InstanceID, 20, 60*time.Second)
+	proceed, err := s.queueRepo.StartProcessing(ctx, msg.ID, msg.Attempts+1)
 	if err != nil {
-		log.Error().Err(err).Str("instance_id", msg.InstanceID).Msg("Error verificando rate limit")
-	} else if !allowed {
-		return errors.ErrRateLimitReached
+		log.Error().Err(err).Str("msg_id", msg.ID).Msg("Error actualizando estado del mensaje de cola")
+	} else if !proceed {
+		log.Debug().Str("msg_id", msg.ID).Msg("Mensaje de cola cancelado, se descarta")
+		return
+	}
+
+	waMessageID, err := s.process(ctx, &msg)
+	switch {
+	case err == nil:
+		s.setStatus(msg.ID, models.QueueStatusSent, "", waMessageID)
+		s.notifyQueueEvent(msg.InstanceID, models.QueueEventSent, models.QueueEvent{
+			ID:                msg.ID,
+			Type:              msg.Type,
+			Status:            models.QueueStatusSent,
+			Attempts:          msg.Attempts + 1,
+			History:           msg.History,
+			WhatsAppMessageID: waMessageID,
+		})
+	case isSendLimited(err):
+		log.Warn().Int("worker_id", workerID).Str("instance_id", msg.InstanceID).Msg("Límite de envío alcanzado para la instancia. Se pausa su cola.")
+		s.handleRateLimitRetry(&msg, err.(*errors.AppError))
+	case err == errUnknownQueuedType:
+		recordAttempt(&msg, err.Error())
+		s.deadLetter(&msg)
+	default:
+		log.Error().Err(err).Int("worker_id", workerID).Msg("Error procesando mensaje, re-encolando si es posible")
+		s.handleRetry(&msg, err)
+	}
+}
+
+// errUnknownQueuedType tipo de mensaje que el worker no sabe enviar; no se reintenta
+var errUnknownQueuedType = errors.ErrBadRequest.WithDetails("Tipo de mensaje desconocido en cola")
+
+// processMessage envía el mensaje y retorna el ID de WhatsApp del mensaje enviado
+func (s *QueueService) processMessage(ctx context.Context, msg *models.QueuedMessage) (string, error) {
+	// La política propia de la instancia la aplica MessageService (429 con retry_after); la de
+	// por defecto (SEND_RATE_PER_MINUTE) solo limita la cola
+	if s.msgService != nil && s.msgService.ratePolicy != nil {
+		if err := s.msgService.ratePolicy.CheckQueueDefault(ctx, msg.InstanceID); err != nil {
+			return "", err
+		}
 	}
 
 	log.Debug().Str("msg_id", msg.ID).Str("type", string(msg.Type)).Msg("Procesando mensaje de cola")
@@ -138,73 +455,444 @@ func (s *QueueService) processMessage(data string) error {
 	// Convertir payload map[string]interface{} al struct correcto
 	payloadBytes, _ := json.Marshal(msg.Payload)
 
+	var err error
+	var resp *models.MessageResponse
+	var pollResp *models.PollResponse
 	switch msg.Type {
 	case models.MessageTypeText:
 		var req models.SendTextRequest
 		if err = json.Unmarshal(payloadBytes, &req); err == nil {
-			_, err = s.msgService.SendText(ctx, msg.InstanceID, &req)
+			resp, err = s.msgService.SendText(ctx, msg.InstanceID, &req)
 		}
 	case models.MessageTypeImage:
 		var req models.SendMediaRequest
 		if err = json.Unmarshal(payloadBytes, &req); err == nil {
-			_, err = s.msgService.SendImage(ctx, msg.InstanceID, &req)
+			resp, err = s.msgService.SendImage(ctx, msg.InstanceID, &req)
 		}
 	case models.MessageTypeVideo:
 		var req models.SendMediaRequest
 		if err = json.Unmarshal(payloadBytes, &req); err == nil {
-			_, err = s.msgService.SendVideo(ctx, msg.InstanceID, &req)
+			resp, err = s.msgService.SendVideo(ctx, msg.InstanceID, &req)
 		}
 	case models.MessageTypeAudio:
 		var req models.SendMediaRequest
 		if err = json.Unmarshal(payloadBytes, &req); err == nil {
-			_, err = s.msgService.SendAudio(ctx, msg.InstanceID, &req)
+			resp, err = s.msgService.SendAudio(ctx, msg.InstanceID, &req)
 		}
 	case models.MessageTypeDocument:
 		var req models.SendMediaRequest
 		if err = json.Unmarshal(payloadBytes, &req); err == nil {
-			_, err = s.msgService.SendDocument(ctx, msg.InstanceID, &req)
+			resp, err = s.msgService.SendDocument(ctx, msg.InstanceID, &req)
 		}
 	case models.MessageTypeLocation:
 		var req models.SendLocationRequest
 		if err = json.Unmarshal(payloadBytes, &req); err == nil {
-			_, err = s.msgService.SendLocation(ctx, msg.InstanceID, &req)
+			resp, err = s.msgService.SendLocation(ctx, msg.InstanceID, &req)
+		}
+	case models.MessageTypeContact:
+		var req models.SendContactRequest
+		if err = json.Unmarshal(payloadBytes, &req); err == nil {
+			resp, err = s.msgService.SendContact(ctx, msg.InstanceID, &req)
+		}
+	case models.MessageTypeTextWithTyping:
+		var req models.SendTextWithTypingRequest
+		if err = json.Unmarshal(payloadBytes, &req); err == nil {
+			resp, err = s.msgService.SendTextWithTyping(ctx, msg.InstanceID, &req)
+		}
+	case models.MessageTypeReaction:
+		var req models.ReactionRequest
+		if err = json.Unmarshal(payloadBytes, &req); err == nil {
+			resp, err = s.msgService.ReactToMessage(ctx, msg.InstanceID, &req)
+		}
+	case models.MessageTypeRevoke:
+		var req models.RevokeRequest
+		if err = json.Unmarshal(payloadBytes, &req); err == nil {
+			resp, err = s.msgService.RevokeMessage(ctx, msg.InstanceID, &req)
+		}
+	case models.MessageTypeEdit:
+		var req models.EditMessageRequest
+		if err = json.Unmarshal(payloadBytes, &req); err == nil {
+			resp, err = s.msgService.EditMessage(ctx, msg.InstanceID, &req)
+		}
+	case models.MessageTypeMarkRead:
+		var req models.MarkAsReadRequest
+		if err = json.Unmarshal(payloadBytes, &req); err == nil {
+			resp, err = s.msgService.MarkAsRead(ctx, msg.InstanceID, &req)
+		}
+	case models.MessageTypePoll:
+		var req models.CreatePollRequest
+		if err = json.Unmarshal(payloadBytes, &req); err == nil {
+			pollResp, err = s.msgService.CreatePoll(ctx, msg.InstanceID, &req)
+		}
+	case models.MessageTypePollVote:
+		var req models.VotePollRequest
+		if err = json.Unmarshal(payloadBytes, &req); err == nil {
+			pollResp, err = s.msgService.VotePoll(ctx, msg.InstanceID, &req)
 		}
 	default:
 		log.Warn().Str("type", string(msg.Type)).Msg("Tipo de mensaje desconocido en cola")
-		return nil
+		return "", errUnknownQueuedType
 	}
 
 	if err != nil {
 		log.Error().Err(err).Str("msg_id", msg.ID).Msg("Error enviando mensaje desde cola")
-		return err
+		return "", err
+	}
+	if pollResp != nil {
+		return pollResp.MessageID, nil
+	}
+	if resp == nil {
+		return "", nil
 	}
 
-	return nil
+	return resp.MessageID, nil
 }
 
-func (s *QueueService) handleRetry(data string) {
-	var msg models.QueuedMessage
-	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+func (s *QueueService) handleRetry(msg *models.QueuedMessage, cause error) {
+	recordAttempt(msg, cause.Error())
+	if msg.Attempts >= queueMaxRetries {
+		log.Error().Str("msg_id", msg.ID).Int("attempts", msg.Attempts).Msg("Mensaje fallido tras máximo de reintentos")
+		s.deadLetter(msg)
 		return
 	}
 
-	if msg.Attempts >= 3 {
-		log.Error().Str("msg_id", msg.ID).Int("attempts", msg.Attempts).Msg("Mensaje fallido tras máximo de reintentos")
+	// Mientras espera el reintento queda pendiente y todavía se puede cancelar
+	s.setStatus(msg.ID, models.QueueStatusPending, cause.Error(), "")
+
+	msg.Attempts++
+	// El reintento se programa en lugar de esperar, así el worker sigue con otros mensajes
+	backoff := time.Duration(msg.Attempts) * queueRetryBackoff
+	err := s.queueRepo.Schedule(context.Background(), msg, time.Now().Add(backoff))
+	if err == repository.ErrQueueItemCancelled {
+		log.Info().Str("msg_id", msg.ID).Msg("Mensaje cancelado antes de programar el reintento")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("msg_id", msg.ID).Msg("Error programando reintento del mensaje")
 		return
 	}
+	log.Info().Str("msg_id", msg.ID).Int("attempt", msg.Attempts).Dur("backoff", backoff).Msg("Reintento de mensaje programado")
+}
 
-	msg.Attempts++
-	// Esperar un poco antes de re-encolar (backoff simple)
-	time.Sleep(time.Duration(msg.Attempts) * 2 * time.Second)
+// handleRateLimitRetry devuelve el mensaje al frente de la cola de su instancia sin penalizar
+// "Attempts" y pausa esa cola hasta que la política vuelva a permitir envíos (p. ej. al terminar
+// el horario de silencio). Los workers siguen atendiendo a las demás instancias.
+func (s *QueueService) handleRateLimitRetry(msg *models.QueuedMessage, limitErr *errors.AppError) {
+	wait := time.Duration(limitErr.RetryAfter) * time.Second
+	if wait <= 0 {
+		wait = time.Second
+	}
+
+	s.setStatus(msg.ID, models.QueueStatusPending, limitErr.Error(), "")
+	if err := s.queueRepo.Defer(context.Background(), msg, wait); err != nil && err != repository.ErrQueueItemCancelled {
+		log.Error().Err(err).Str("msg_id", msg.ID).Msg("Error re-encolando mensaje limitado")
+	}
+}
+
+// isSendLimited indica si el envío fue rechazado por la política de envío de la instancia
+func isSendLimited(err error) bool {
+	appErr, ok := err.(*errors.AppError)
+	return ok && appErr.Code == http.StatusTooManyRequests
+}
+
+// requeue devuelve el mensaje a la cola de su instancia, salvo que se haya cancelado mientras esperaba
+func (s *QueueService) requeue(msg *models.QueuedMessage) {
+	_, err := s.queueRepo.Enqueue(context.Background(), msg)
+	if err == repository.ErrQueueItemCancelled {
+		log.Info().Str("msg_id", msg.ID).Msg("Mensaje cancelado, no se vuelve a encolar")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("msg_id", msg.ID).Msg("Error re-encolando mensaje")
+	}
+}
+
+// recordAttempt agrega un intento fallido al historial del mensaje
+func recordAttempt(msg *models.QueuedMessage, cause string) {
+	msg.LastError = cause
+	msg.History = append(msg.History, models.QueueAttempt{
+		Attempt: msg.Attempts + 1,
+		Error:   cause,
+		At:      time.Now().Unix(),
+	})
+}
+
+// deadLetter mueve a la dead-letter de su instancia un mensaje que agotó sus reintentos
+// y avisa por webhook (queue.failed) al sistema que lo envió
+func (s *QueueService) deadLetter(msg *models.QueuedMessage) {
+	ctx := context.Background()
+	msg.FailedAt = time.Now().Unix()
+	if err := s.queueRepo.PushDeadLetter(ctx, msg, queueDeadLetterMax); err != nil {
+		log.Error().Err(err).Str("msg_id", msg.ID).Msg("Error guardando mensaje en dead-letter")
+	}
+	s.setStatus(msg.ID, models.QueueStatusFailed, msg.LastError, "")
+
+	s.notifyQueueEvent(msg.InstanceID, models.QueueEventFailed, models.QueueEvent{
+		ID:        msg.ID,
+		Type:      msg.Type,
+		Status:    models.QueueStatusFailed,
+		Attempts:  len(msg.History),
+		LastError: msg.LastError,
+		History:   msg.History,
+	})
+}
+
+// expire descarta un mensaje que venció antes de enviarse y avisa con queue.expired
+func (s *QueueService) expire(msg *models.QueuedMessage) {
+	reason := fmt.Sprintf("Venció el %s sin enviarse", time.Unix(msg.ExpiresAt, 0).UTC().Format(time.RFC3339))
+	expired, err := s.queueRepo.Expire(context.Background(), msg.ID, reason)
+	if err != nil {
+		log.Error().Err(err).Str("msg_id", msg.ID).Msg("Error marcando mensaje de cola como vencido")
+	}
+	if !expired {
+		return // Cancelado o sin estado: no hay nada que avisar
+	}
 
-	jsonBytes, _ := json.Marshal(msg)
-	s.redisClient.EnqueueMessage(context.Background(), "queue:messages", string(jsonBytes))
-	log.Info().Str("msg_id", msg.ID).Int("attempt", msg.Attempts).Msg("Mensaje re-encolado para reintento")
+	log.Info().Str("msg_id", msg.ID).Str("instance_id", msg.InstanceID).Msg("Mensaje de cola vencido, se descarta")
+	s.notifyQueueEvent(msg.InstanceID, models.QueueEventExpired, models.QueueEvent{
+		ID:        msg.ID,
+		Type:      msg.Type,
+		Status:    models.QueueStatusExpired,
+		Attempts:  msg.Attempts,
+		LastError: reason,
+		History:   msg.History,
+	})
 }
 
-func (s *QueueService) handleRateLimitRetry(data string) {
-	// En caso de rate limit, re-encolamos sin penalizar "Attempts"
-	// Pero esperamos un poco para dejar que la ventana de tiempo se limpie.
-	time.Sleep(5 * time.Second)
-	s.redisClient.EnqueueMessage(context.Background(), "queue:messages", data)
+// notifyQueueEvent avisa del resultado de un mensaje encolado por webhook y por WebSocket.
+// El ID del evento es estable por mensaje para que los receptores descarten duplicados.
+func (s *QueueService) notifyQueueEvent(instanceID, eventType string, data models.QueueEvent) {
+	eventID := models.NewEventID(instanceID, eventType, data.ID)
+
+	if s.webhookSvc != nil {
+		event := &models.WebhookEvent{ID: eventID, Event: eventType, Data: data}
+		if err := s.webhookSvc.SendEvent(context.Background(), instanceID, event); err != nil {
+			log.Error().Err(err).Str("msg_id", data.ID).Str("event", eventType).Msg("Error enviando webhook de la cola")
+		}
+	}
+
+	if s.notifier != nil {
+		s.notifier.BroadcastEvent(eventType, map[string]interface{}{
+			"instance_id":         instanceID,
+			"event_id":            eventID,
+			"id":                  data.ID,
+			"type":                data.Type,
+			"status":              data.Status,
+			"attempts":            data.Attempts,
+			"last_error":          data.LastError,
+			"history":             data.History,
+			"whatsapp_message_id": data.WhatsAppMessageID,
+		})
+	}
+}
+
+// ListDeadLetters obtiene los mensajes que agotaron sus reintentos
+func (s *QueueService) ListDeadLetters(ctx context.Context, instanceID string, limit int) ([]models.QueuedMessage, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	messages, err := s.queueRepo.ListDeadLetters(ctx, instanceID, limit)
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	return messages, nil
+}
+
+// PurgeDeadLetters elimina los mensajes fallidos de una instancia
+func (s *QueueService) PurgeDeadLetters(ctx context.Context, instanceID string) error {
+	if err := s.queueRepo.PurgeDeadLetters(ctx, instanceID); err != nil {
+		return errors.ErrInternalServer.Wrap(err)
+	}
+	return nil
+}
+
+// RequeueDeadLetters devuelve a la cola los mensajes fallidos indicados (todos si ids está vacío)
+// con los intentos en cero. El historial de intentos se conserva.
+func (s *QueueService) RequeueDeadLetters(ctx context.Context, instanceID string, ids []string) (int, error) {
+	messages, err := s.queueRepo.TakeDeadLetters(ctx, instanceID, ids)
+	if err != nil {
+		s.restoreDeadLetters(ctx, messages)
+		return 0, errors.ErrInternalServer.Wrap(err)
+	}
+
+	for i := range messages {
+		msg := messages[i]
+		msg.Attempts = 0
+		msg.FailedAt = 0
+		if _, err := s.queueRepo.Enqueue(ctx, &msg); err != nil {
+			// Ya se sacaron de la dead-letter: los que faltan vuelven a ella para no perderlos
+			s.restoreDeadLetters(ctx, messages[i:])
+			return i, errors.ErrInternalServer.Wrap(err)
+		}
+	}
+
+	if len(messages) > 0 {
+		log.Info().Str("instance_id", instanceID).Int("count", len(messages)).Msg("Mensajes de dead-letter re-encolados")
+	}
+	return len(messages), nil
+}
+
+// restoreDeadLetters devuelve a la dead-letter mensajes que se sacaron y no se re-encolaron,
+// conservando su orden (llegan en el orden de la lista, los más recientes primero)
+func (s *QueueService) restoreDeadLetters(ctx context.Context, messages []models.QueuedMessage) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if err := s.queueRepo.PushDeadLetter(ctx, &messages[i], queueDeadLetterMax); err != nil {
+			log.Error().Err(err).Str("msg_id", messages[i].ID).Msg("Error devolviendo mensaje a la dead-letter")
+		}
+	}
+}
+
+// setStatus registra el estado de un mensaje de la cola
+func (s *QueueService) setStatus(msgID, status, lastError, waMessageID string) {
+	if err := s.queueRepo.UpdateStatus(context.Background(), msgID, status, lastError, waMessageID); err != nil {
+		log.Error().Err(err).Str("msg_id", msgID).Str("status", status).Msg("Error actualizando estado del mensaje de cola")
+	}
+}
+
+// leaseLoop renueva los leases de los workers de esta réplica y aplica los cambios
+// de workers hechos por API en otra réplica
+func (s *QueueService) leaseLoop() {
+	ticker := time.NewTicker(queueLeaseRenewal)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			if workers, err := s.desiredWorkers(context.Background()); err != nil {
+				log.Error().Err(err).Msg("Error leyendo los workers de cola fijados por API")
+			} else {
+				s.resize(workers)
+			}
+			s.renewLeases()
+		}
+	}
+}
+
+func (s *QueueService) renewLeases() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, worker := range s.running {
+		s.renewLease(worker.id)
+	}
+	for id := range s.stopping {
+		s.renewLease(id)
+	}
+}
+
+func (s *QueueService) renewLease(workerID int) {
+	if err := s.queueRepo.RenewLease(context.Background(), repository.QueueProcessingKey(s.nodeID, workerID), queueLeaseTTL); err != nil {
+		log.Error().Err(err).Int("worker_id", workerID).Msg("Error renovando lease del worker de cola")
+	}
+}
+
+// retryLoop devuelve a su cola los reintentos programados cuyo backoff ya venció
+func (s *QueueService) retryLoop() {
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			s.promoteRetries(time.Now())
+		}
+	}
+}
+
+// promoteRetries devuelve a su cola los reintentos vencidos en now
+func (s *QueueService) promoteRetries(now time.Time) {
+	for {
+		n, err := s.queueRepo.PromoteDue(context.Background(), now, queuePromoteBatch)
+		if err != nil {
+			log.Error().Err(err).Msg("Error devolviendo reintentos programados a la cola")
+			return
+		}
+		if n > 0 {
+			log.Debug().Int("count", n).Msg("Reintentos programados devueltos a la cola")
+		}
+		if n < queuePromoteBatch {
+			return
+		}
+	}
+}
+
+// reaperLoop recupera los mensajes huérfanos al arrancar y luego periódicamente
+func (s *QueueService) reaperLoop() {
+	ticker := time.NewTicker(queueReaperInterval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := s.ReapOrphans(context.Background()); err != nil {
+			log.Error().Err(err).Msg("Error recuperando mensajes huérfanos de la cola")
+		} else if n > 0 {
+			log.Warn().Int("count", n).Msg("Mensajes huérfanos devueltos a la cola")
+		}
+
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// errWorkerLost error registrado en los mensajes recuperados por el reaper
+const errWorkerLost = "El worker se detuvo durante el envío"
+
+// ReapOrphans devuelve a la cola de su instancia los mensajes que quedaron en la lista de
+// procesamiento de un worker caído. Cuenta como un intento más: un mensaje que tumba al
+// worker una y otra vez termina fallido en lugar de reintentarse para siempre.
+func (s *QueueService) ReapOrphans(ctx context.Context) (int, error) {
+	keys, err := s.queueRepo.OrphanedProcessing(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, key := range keys {
+		for {
+			data, err := s.queueRepo.PopOrphan(ctx, key)
+			if err == redis.Nil {
+				break
+			}
+			if err != nil {
+				return count, err
+			}
+
+			var msg models.QueuedMessage
+			if err := json.Unmarshal([]byte(data), &msg); err != nil {
+				log.Error().Err(err).Str("data", data).Msg("Mensaje huérfano corrupto, se descarta")
+				continue
+			}
+
+			// El worker pudo caerse después de enviar y antes del ACK
+			if item, err := s.queueRepo.Item(ctx, msg.ID); err == nil && item != nil && item.Status == models.QueueStatusSent {
+				continue
+			}
+
+			count++
+			recordAttempt(&msg, errWorkerLost)
+			if msg.Attempts >= queueMaxRetries {
+				log.Error().Str("msg_id", msg.ID).Int("attempts", msg.Attempts).Msg("Mensaje huérfano fallido tras máximo de reintentos")
+				s.deadLetter(&msg)
+				continue
+			}
+			s.setStatus(msg.ID, models.QueueStatusPending, errWorkerLost, "")
+			msg.Attempts++
+			s.requeue(&msg)
+		}
+	}
+	return count, nil
+}
+
+// wait duerme d o hasta que se detenga el servicio o el worker
+func (s *QueueService) wait(stop <-chan struct{}, d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-s.stopChan:
+	case <-stop:
+	}
 }
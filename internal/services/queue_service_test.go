@@ -0,0 +1,762 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"kero-kero/internal/models"
+	"kero-kero/internal/repository"
+	"kero-kero/internal/testutil"
+	"kero-kero/internal/whatsapp"
+	"kero-kero/pkg/errors"
+)
+
+func TestQueueService_FairDispatch(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	s := NewQueueService(&repository.RedisClient{Client: redisClient}, nil)
+
+	// "ventas" encola una ráfaga antes de que "soporte" encole sus dos mensajes
+	for i := 0; i < 5; i++ {
+		_, err := s.EnqueueMessage(ctx, "ventas", models.MessageTypeText, map[string]string{"text": "promo"})
+		require.NoError(t, err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err := s.EnqueueMessage(ctx, "soporte", models.MessageTypeText, map[string]string{"text": "ticket"})
+		require.NoError(t, err)
+	}
+
+	depths, err := s.QueueDepths(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"ventas": 5, "soporte": 2}, depths)
+
+	processingKey := repository.QueueProcessingKey(s.nodeID, 0)
+	var order []string
+	for {
+		data, err := s.queueRepo.Dequeue(ctx, processingKey)
+		if err == redis.Nil {
+			break
+		}
+		require.NoError(t, err)
+
+		var msg models.QueuedMessage
+		require.NoError(t, json.Unmarshal([]byte(data), &msg))
+		order = append(order, msg.InstanceID)
+		require.NoError(t, s.queueRepo.Ack(ctx, processingKey, data))
+	}
+
+	assert.Equal(t, []string{"ventas", "soporte", "ventas", "soporte", "ventas", "ventas", "ventas"}, order)
+
+	depths, err = s.QueueDepths(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, depths)
+
+	depth, err := s.QueueDepth(ctx, "ventas")
+	require.NoError(t, err)
+	assert.Zero(t, depth)
+}
+
+func TestQueueService_MigrateLegacy(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	s := NewQueueService(&repository.RedisClient{Client: redisClient}, nil)
+
+	for _, instanceID := range []string{"ventas", "soporte", "ventas"} {
+		data, _ := json.Marshal(&models.QueuedMessage{ID: "msg", InstanceID: instanceID, Type: models.MessageTypeText})
+		require.NoError(t, redisClient.RPush(ctx, "queue:messages", data).Err())
+	}
+
+	n, err := s.queueRepo.MigrateLegacy(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	depths, err := s.QueueDepths(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"ventas": 2, "soporte": 1}, depths)
+	assert.False(t, mr.Exists("queue:messages"))
+}
+
+func TestQueueService_ItemStatus(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	s := NewQueueService(&repository.RedisClient{Client: redisClient}, nil)
+
+	first, err := s.EnqueueMessage(ctx, "ventas", models.MessageTypeText, map[string]string{"text": "hola"})
+	require.NoError(t, err)
+	second, err := s.EnqueueMessage(ctx, "ventas", models.MessageType("sticker"), map[string]string{})
+	require.NoError(t, err)
+
+	item, err := s.GetQueueItem(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, models.QueueStatusPending, item.Status)
+	assert.Equal(t, "ventas", item.InstanceID)
+	assert.Equal(t, models.MessageTypeText, item.Type)
+
+	t.Run("cancelar un pendiente lo quita de la cola", func(t *testing.T) {
+		item, err := s.CancelQueueItem(ctx, first)
+		require.NoError(t, err)
+		assert.Equal(t, models.QueueStatusCancelled, item.Status)
+
+		depth, err := s.QueueDepth(ctx, "ventas")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), depth)
+
+		// Cancelar de nuevo no es un error
+		item, err = s.CancelQueueItem(ctx, first)
+		require.NoError(t, err)
+		assert.Equal(t, models.QueueStatusCancelled, item.Status)
+	})
+
+	t.Run("tipo desconocido falla sin reintentos", func(t *testing.T) {
+		processingKey := repository.QueueProcessingKey(s.nodeID, 0)
+		data, err := s.queueRepo.Dequeue(ctx, processingKey)
+		require.NoError(t, err)
+		s.handleMessage(0, data)
+
+		item, err := s.GetQueueItem(ctx, second)
+		require.NoError(t, err)
+		assert.Equal(t, models.QueueStatusFailed, item.Status)
+		assert.Equal(t, 1, item.Attempts)
+		assert.NotEmpty(t, item.LastError)
+
+		_, err = s.CancelQueueItem(ctx, second)
+		assert.Equal(t, 409, err.(*errors.AppError).Code)
+	})
+
+	t.Run("un cancelado no se vuelve a encolar", func(t *testing.T) {
+		_, err := s.queueRepo.Enqueue(ctx, &models.QueuedMessage{ID: first, InstanceID: "ventas", Type: models.MessageTypeText})
+		assert.Equal(t, repository.ErrQueueItemCancelled, err)
+	})
+
+	t.Run("listar por estado", func(t *testing.T) {
+		items, total, err := s.ListQueue(ctx, "ventas", nil, 0, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 1, total)
+		assert.Equal(t, second, items[0].ID)
+
+		items, total, err = s.ListQueue(ctx, "ventas", []string{models.QueueStatusCancelled, models.QueueStatusFailed}, 1, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 2, total)
+		assert.Len(t, items, 1)
+
+		_, _, err = s.ListQueue(ctx, "ventas", []string{"lost"}, 0, 0)
+		assert.Error(t, err)
+	})
+
+	t.Run("mensaje inexistente", func(t *testing.T) {
+		_, err := s.GetQueueItem(ctx, "msg_0")
+		assert.Equal(t, 404, err.(*errors.AppError).Code)
+		_, err = s.CancelQueueItem(ctx, "msg_0")
+		assert.Equal(t, 404, err.(*errors.AppError).Code)
+	})
+}
+
+func TestQueueService_ReapOrphans(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	s := NewQueueService(&repository.RedisClient{Client: redisClient}, nil)
+
+	// take encola un mensaje y lo deja en la lista de procesamiento indicada, como un worker a medio enviar
+	take := func(instanceID string, attempts int, processingKey string) string {
+		msgID, err := s.EnqueueMessage(ctx, instanceID, models.MessageTypeText, map[string]string{"text": "hola"})
+		require.NoError(t, err)
+		data, err := s.queueRepo.Dequeue(ctx, processingKey)
+		require.NoError(t, err)
+		if attempts > 0 {
+			var msg models.QueuedMessage
+			require.NoError(t, json.Unmarshal([]byte(data), &msg))
+			msg.Attempts = attempts
+			raw, _ := json.Marshal(&msg)
+			require.NoError(t, redisClient.LSet(ctx, processingKey, -1, raw).Err())
+		}
+		_, err = s.queueRepo.StartProcessing(ctx, msgID, attempts+1)
+		require.NoError(t, err)
+		return msgID
+	}
+
+	live := repository.QueueProcessingKey(s.nodeID, 0)
+	dead := repository.QueueProcessingKey("caida", 0)
+	legacy := "queue:processing:1" // Versiones anteriores: sin réplica y sin lease
+
+	require.NoError(t, s.queueRepo.RenewLease(ctx, live, queueLeaseTTL))
+	require.NoError(t, s.queueRepo.RenewLease(ctx, dead, time.Second))
+	mr.FastForward(2 * time.Second)
+
+	inFlight := take("ventas", 0, live)
+	orphan := take("ventas", 0, dead)
+	exhausted := take("soporte", queueMaxRetries, dead)
+	old := take("soporte", 1, legacy)
+
+	n, err := s.ReapOrphans(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	// El worker vivo conserva su mensaje
+	item, err := s.GetQueueItem(ctx, inFlight)
+	require.NoError(t, err)
+	assert.Equal(t, models.QueueStatusProcessing, item.Status)
+	assert.Equal(t, int64(1), redisClient.LLen(ctx, live).Val())
+
+	item, err = s.GetQueueItem(ctx, orphan)
+	require.NoError(t, err)
+	assert.Equal(t, models.QueueStatusPending, item.Status)
+	assert.Equal(t, errWorkerLost, item.LastError)
+
+	item, err = s.GetQueueItem(ctx, exhausted)
+	require.NoError(t, err)
+	assert.Equal(t, models.QueueStatusFailed, item.Status)
+
+	depths, err := s.QueueDepths(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"ventas": 1, "soporte": 1}, depths)
+	assert.False(t, mr.Exists(dead))
+	assert.False(t, mr.Exists(legacy))
+
+	// El contador de intentos avanza al recuperarlo
+	data, err := s.queueRepo.Dequeue(ctx, live)
+	require.NoError(t, err)
+	for data != "" {
+		var msg models.QueuedMessage
+		require.NoError(t, json.Unmarshal([]byte(data), &msg))
+		switch msg.ID {
+		case orphan:
+			assert.Equal(t, 1, msg.Attempts)
+		case old:
+			assert.Equal(t, 2, msg.Attempts)
+		}
+		data, _ = s.queueRepo.Dequeue(ctx, live)
+	}
+}
+
+func TestQueueService_DeadLetters(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	rc := &repository.RedisClient{Client: redisClient}
+	webhookRepo := repository.NewWebhookRepository(rc)
+	require.NoError(t, webhookRepo.Set(ctx, &models.WebhookConfig{
+		ID: "crm", InstanceID: "ventas", URL: "http://crm.local/hook", Events: []string{models.QueueEventFailed}, Enabled: true,
+	}))
+
+	s := NewQueueService(rc, nil)
+	s.SetWebhookService(NewWebhookService(webhookRepo))
+
+	// fail simula el último intento fallido de un mensaje recién encolado
+	fail := func(cause string) string {
+		msgID, err := s.EnqueueMessage(ctx, "ventas", models.MessageTypeText, map[string]string{"text": "hola"})
+		require.NoError(t, err)
+		data, err := s.queueRepo.Dequeue(ctx, repository.QueueProcessingKey(s.nodeID, 0))
+		require.NoError(t, err)
+
+		var msg models.QueuedMessage
+		require.NoError(t, json.Unmarshal([]byte(data), &msg))
+		msg.Attempts = queueMaxRetries
+		s.handleRetry(&msg, fmt.Errorf("%s", cause))
+		return msgID
+	}
+
+	first := fail("Instancia no autenticada")
+	second := fail("timeout")
+
+	messages, err := s.ListDeadLetters(ctx, "ventas", 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, second, messages[0].ID)
+	assert.Equal(t, "timeout", messages[0].LastError)
+	assert.NotZero(t, messages[0].FailedAt)
+	require.Len(t, messages[1].History, 1)
+	assert.Equal(t, queueMaxRetries+1, messages[1].History[0].Attempt)
+
+	item, err := s.GetQueueItem(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, models.QueueStatusFailed, item.Status)
+
+	t.Run("webhook queue.failed", func(t *testing.T) {
+		deliveries, err := redisClient.LRange(ctx, "webhook:queue", 0, -1).Result()
+		require.NoError(t, err)
+		require.Len(t, deliveries, 2)
+
+		var delivery models.WebhookDelivery
+		require.NoError(t, json.Unmarshal([]byte(deliveries[1]), &delivery))
+		assert.Equal(t, models.QueueEventFailed, delivery.Event)
+
+		var event struct {
+			Data models.QueueEvent `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(delivery.Payload, &event))
+		assert.Equal(t, first, event.Data.ID)
+		assert.Equal(t, "Instancia no autenticada", event.Data.LastError)
+	})
+
+	t.Run("re-encolar por ID", func(t *testing.T) {
+		n, err := s.RequeueDeadLetters(ctx, "ventas", []string{first})
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		item, err := s.GetQueueItem(ctx, first)
+		require.NoError(t, err)
+		assert.Equal(t, models.QueueStatusPending, item.Status)
+
+		data, err := s.queueRepo.Dequeue(ctx, repository.QueueProcessingKey(s.nodeID, 0))
+		require.NoError(t, err)
+		var msg models.QueuedMessage
+		require.NoError(t, json.Unmarshal([]byte(data), &msg))
+		assert.Equal(t, first, msg.ID)
+		assert.Zero(t, msg.Attempts)
+		assert.Len(t, msg.History, 1)
+
+		// Ya no está en la dead-letter
+		n, err = s.RequeueDeadLetters(ctx, "ventas", []string{first})
+		require.NoError(t, err)
+		assert.Zero(t, n)
+	})
+
+	t.Run("un fallo al re-encolar no pierde los que faltan", func(t *testing.T) {
+		require.NoError(t, s.PurgeDeadLetters(ctx, "ventas"))
+		for _, id := range []string{"msg_a", "msg_b", "msg_c"} {
+			require.NoError(t, s.queueRepo.PushDeadLetter(ctx, &models.QueuedMessage{
+				ID: id, InstanceID: "ventas", Type: models.MessageTypeText, Attempts: queueMaxRetries, FailedAt: 1700000000,
+			}, queueDeadLetterMax))
+		}
+		// El hash de msg_b con un tipo incorrecto hace fallar su Enqueue (WRONGTYPE)
+		require.NoError(t, mr.Set("queue:item:msg_b", "corrupto"))
+
+		n, err := s.RequeueDeadLetters(ctx, "ventas", nil)
+		require.Error(t, err)
+		assert.Equal(t, 1, n)
+
+		messages, err := s.ListDeadLetters(ctx, "ventas", 0)
+		require.NoError(t, err)
+		require.Len(t, messages, 2)
+		assert.Equal(t, "msg_b", messages[0].ID)
+		assert.Equal(t, "msg_a", messages[1].ID)
+		assert.Equal(t, queueMaxRetries, messages[0].Attempts)
+		assert.NotZero(t, messages[0].FailedAt)
+
+		item, err := s.GetQueueItem(ctx, "msg_c")
+		require.NoError(t, err)
+		assert.Equal(t, models.QueueStatusPending, item.Status)
+	})
+
+	t.Run("purgar", func(t *testing.T) {
+		require.NoError(t, s.PurgeDeadLetters(ctx, "ventas"))
+		messages, err := s.ListDeadLetters(ctx, "ventas", 0)
+		require.NoError(t, err)
+		assert.Empty(t, messages)
+	})
+}
+
+func TestQueueService_AllMessageTypes(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	rc := &repository.RedisClient{Client: redisClient}
+	// Sin clientes conectados: cada tipo llega hasta MessageService, que responde instancia no encontrada
+	s := NewQueueService(rc, NewMessageService(whatsapp.NewManager(nil, nil, nil, rc), nil))
+
+	types := []models.MessageType{
+		models.MessageTypeText, models.MessageTypeImage, models.MessageTypeVideo, models.MessageTypeAudio,
+		models.MessageTypeDocument, models.MessageTypeLocation, models.MessageTypeContact,
+		models.MessageTypeTextWithTyping, models.MessageTypeReaction, models.MessageTypeRevoke,
+		models.MessageTypeEdit, models.MessageTypeMarkRead, models.MessageTypePoll, models.MessageTypePollVote,
+	}
+	for _, msgType := range types {
+		_, err := s.processMessage(ctx, &models.QueuedMessage{ID: "msg_1", InstanceID: "ventas", Type: msgType, Payload: map[string]string{}})
+		assert.Equal(t, errors.ErrInstanceNotFound, err, msgType)
+	}
+
+	_, err := s.processMessage(ctx, &models.QueuedMessage{ID: "msg_1", InstanceID: "ventas", Type: "sticker"})
+	assert.Equal(t, errUnknownQueuedType, err)
+}
+
+func TestQueueService_CompletionEvents(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	rc := &repository.RedisClient{Client: redisClient}
+	webhookRepo := repository.NewWebhookRepository(rc)
+	require.NoError(t, webhookRepo.Set(ctx, &models.WebhookConfig{
+		ID: "crm", InstanceID: "ventas", URL: "http://crm.local/hook", Events: []string{models.QueueEventSent, models.QueueEventFailed}, Enabled: true,
+	}))
+
+	notifier := &fakeNotifier{}
+	s := NewQueueService(rc, nil)
+	s.SetWebhookService(NewWebhookService(webhookRepo))
+	s.SetEventNotifier(notifier)
+
+	s.notifyQueueEvent("ventas", models.QueueEventSent, models.QueueEvent{
+		ID:                "msg_1",
+		Type:              models.MessageTypePoll,
+		Status:            models.QueueStatusSent,
+		Attempts:          1,
+		WhatsAppMessageID: "3EB0C767D26A1D8B2A5C",
+	})
+	s.deadLetter(&models.QueuedMessage{ID: "msg_2", InstanceID: "ventas", Type: models.MessageTypeEdit, LastError: "timeout"})
+
+	deliveries, err := redisClient.LRange(ctx, "webhook:queue", 0, -1).Result()
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+
+	var delivery models.WebhookDelivery
+	require.NoError(t, json.Unmarshal([]byte(deliveries[1]), &delivery))
+	assert.Equal(t, models.QueueEventSent, delivery.Event)
+	assert.Equal(t, models.NewEventID("ventas", models.QueueEventSent, "msg_1"), delivery.EventID)
+
+	var event struct {
+		Data models.QueueEvent `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(delivery.Payload, &event))
+	assert.Equal(t, "msg_1", event.Data.ID)
+	assert.Equal(t, "3EB0C767D26A1D8B2A5C", event.Data.WhatsAppMessageID)
+
+	// Los mismos eventos llegan por WebSocket con el ID de la cola y el de WhatsApp
+	assert.Equal(t, []string{models.QueueEventSent, models.QueueEventFailed}, notifier.received())
+	payload := notifier.payloads[0].(map[string]interface{})
+	assert.Equal(t, "ventas", payload["instance_id"])
+	assert.Equal(t, "msg_1", payload["id"])
+	assert.Equal(t, "3EB0C767D26A1D8B2A5C", payload["whatsapp_message_id"])
+	assert.Equal(t, "timeout", notifier.payloads[1].(map[string]interface{})["last_error"])
+}
+
+func TestQueueService_Priorities(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	s := NewQueueService(&repository.RedisClient{Client: redisClient}, nil)
+
+	enqueue := func(instanceID, priority, text string) string {
+		msgID, err := s.EnqueueMessageWithOptions(ctx, instanceID, models.MessageTypeText, map[string]string{"text": text}, models.QueueOptions{Priority: priority})
+		require.NoError(t, err)
+		return msgID
+	}
+	enqueue("ventas", models.QueuePriorityBulk, "campaña 1")
+	enqueue("ventas", models.QueuePriorityBulk, "campaña 2")
+	enqueue("ventas", "", "pedido")
+	enqueue("soporte", models.QueuePriorityNormal, "ticket")
+	otp := enqueue("ventas", models.QueuePriorityHigh, "otp ventas")
+	enqueue("soporte", models.QueuePriorityHigh, "otp soporte")
+
+	lanes, err := s.QueueLaneDepths(ctx, "ventas")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"high": 1, "normal": 1, "bulk": 2}, lanes)
+	depths, err := s.QueueDepths(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"ventas": 4, "soporte": 2}, depths)
+
+	item, err := s.GetQueueItem(ctx, otp)
+	require.NoError(t, err)
+	assert.Equal(t, models.QueuePriorityHigh, item.Priority)
+
+	processingKey := repository.QueueProcessingKey(s.nodeID, 0)
+	var order []string
+	for {
+		data, err := s.queueRepo.Dequeue(ctx, processingKey)
+		if err == redis.Nil {
+			break
+		}
+		require.NoError(t, err)
+		require.NoError(t, s.queueRepo.Ack(ctx, processingKey, data))
+
+		var msg models.QueuedMessage
+		require.NoError(t, json.Unmarshal([]byte(data), &msg))
+		order = append(order, msg.Payload.(map[string]interface{})["text"].(string))
+	}
+
+	// Primero los high de todas las instancias (por turnos), luego normal y al final bulk
+	assert.Equal(t, []string{"otp ventas", "otp soporte", "pedido", "ticket", "campaña 1", "campaña 2"}, order)
+
+	t.Run("cancelar en un carril de prioridad", func(t *testing.T) {
+		msgID := enqueue("ventas", models.QueuePriorityBulk, "campaña 3")
+		_, err := s.CancelQueueItem(ctx, msgID)
+		require.NoError(t, err)
+
+		depths, err := s.QueueDepths(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, depths)
+		_, err = s.queueRepo.Dequeue(ctx, processingKey)
+		assert.Equal(t, redis.Nil, err)
+	})
+
+	t.Run("prioridad inválida", func(t *testing.T) {
+		_, err := s.EnqueueMessageWithOptions(ctx, "ventas", models.MessageTypeText, nil, models.QueueOptions{Priority: "urgente"})
+		require.Error(t, err)
+		assert.Equal(t, 400, err.(*errors.AppError).Code)
+	})
+}
+
+func TestQueueService_Expiry(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	rc := &repository.RedisClient{Client: redisClient}
+	notifier := &fakeNotifier{}
+	// Sin MessageService: un mensaje vencido se descarta sin intentar enviarlo
+	s := NewQueueService(rc, nil)
+	s.SetEventNotifier(notifier)
+
+	_, err := s.EnqueueMessageWithOptions(ctx, "ventas", models.MessageTypeText, nil, models.QueueOptions{ExpiresAt: time.Now().Add(-time.Second)})
+	require.Error(t, err)
+
+	msgID, err := s.EnqueueMessageWithOptions(ctx, "ventas", models.MessageTypeText, map[string]string{"text": "otp"}, models.QueueOptions{
+		Priority:  models.QueuePriorityHigh,
+		ExpiresAt: time.Now().Add(time.Minute),
+	})
+	require.NoError(t, err)
+
+	item, err := s.GetQueueItem(ctx, msgID)
+	require.NoError(t, err)
+	require.NotNil(t, item.ExpiresAt)
+
+	processingKey := repository.QueueProcessingKey(s.nodeID, 0)
+	data, err := s.queueRepo.Dequeue(ctx, processingKey)
+	require.NoError(t, err)
+
+	// Simula que el mensaje esperó en la cola más de lo permitido
+	var msg models.QueuedMessage
+	require.NoError(t, json.Unmarshal([]byte(data), &msg))
+	msg.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	stale, err := json.Marshal(msg)
+	require.NoError(t, err)
+	s.handleMessage(0, string(stale))
+
+	item, err = s.GetQueueItem(ctx, msgID)
+	require.NoError(t, err)
+	assert.Equal(t, models.QueueStatusExpired, item.Status)
+	assert.Zero(t, item.Attempts)
+	assert.Contains(t, item.LastError, "Venció")
+
+	assert.Equal(t, []string{models.QueueEventExpired}, notifier.received())
+
+	items, total, err := s.ListQueue(ctx, "ventas", nil, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, msgID, items[0].ID)
+}
+
+func TestQueueService_DelayedRetries(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	s := NewQueueService(&repository.RedisClient{Client: redisClient}, nil)
+	processingKey := repository.QueueProcessingKey(s.nodeID, 0)
+
+	// fail simula un envío fallido del siguiente mensaje de la cola
+	fail := func() *models.QueuedMessage {
+		data, err := s.queueRepo.Dequeue(ctx, processingKey)
+		require.NoError(t, err)
+		require.NoError(t, s.queueRepo.Ack(ctx, processingKey, data))
+
+		var msg models.QueuedMessage
+		require.NoError(t, json.Unmarshal([]byte(data), &msg))
+		s.handleRetry(&msg, fmt.Errorf("timeout"))
+		return &msg
+	}
+	delayed := func() int64 {
+		n, err := s.DelayedRetries(ctx)
+		require.NoError(t, err)
+		return n
+	}
+
+	msgID, err := s.EnqueueMessageWithOptions(ctx, "ventas", models.MessageTypeText, map[string]string{"text": "promo"}, models.QueueOptions{Priority: models.QueuePriorityBulk})
+	require.NoError(t, err)
+	fail()
+
+	t.Run("el reintento espera fuera de la cola", func(t *testing.T) {
+		item, err := s.GetQueueItem(ctx, msgID)
+		require.NoError(t, err)
+		assert.Equal(t, models.QueueStatusPending, item.Status)
+		assert.Equal(t, "timeout", item.LastError)
+		assert.Equal(t, int64(1), delayed())
+
+		depth, err := s.QueueDepth(ctx, "ventas")
+		require.NoError(t, err)
+		assert.Zero(t, depth)
+
+		s.promoteRetries(time.Now())
+		assert.Equal(t, int64(1), delayed())
+	})
+
+	t.Run("vuelve a su carril al vencer el backoff", func(t *testing.T) {
+		s.promoteRetries(time.Now().Add(queueRetryBackoff))
+		assert.Zero(t, delayed())
+
+		lanes, err := s.QueueLaneDepths(ctx, "ventas")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), lanes[models.QueuePriorityBulk])
+
+		data, err := s.queueRepo.Dequeue(ctx, processingKey)
+		require.NoError(t, err)
+		var msg models.QueuedMessage
+		require.NoError(t, json.Unmarshal([]byte(data), &msg))
+		assert.Equal(t, msgID, msg.ID)
+		assert.Equal(t, 1, msg.Attempts)
+		require.Len(t, msg.History, 1)
+	})
+
+	t.Run("cancelar un reintento programado", func(t *testing.T) {
+		cancelled, err := s.EnqueueMessage(ctx, "ventas", models.MessageTypeText, map[string]string{"text": "hola"})
+		require.NoError(t, err)
+		fail()
+		require.Equal(t, int64(1), delayed())
+
+		item, err := s.CancelQueueItem(ctx, cancelled)
+		require.NoError(t, err)
+		assert.Equal(t, models.QueueStatusCancelled, item.Status)
+		assert.Zero(t, delayed())
+
+		s.promoteRetries(time.Now().Add(time.Minute))
+		depth, err := s.QueueDepth(ctx, "ventas")
+		require.NoError(t, err)
+		assert.Zero(t, depth)
+	})
+}
+
+func TestQueueService_ScaleWorkers(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	rc := &repository.RedisClient{Client: redisClient}
+	leases := func() int {
+		n := 0
+		for _, key := range mr.Keys() {
+			if strings.HasPrefix(key, "queue:lease:") {
+				n++
+			}
+		}
+		return n
+	}
+
+	s := NewQueueService(rc, nil)
+	s.SetWorkers(2)
+
+	// Antes de Start solo se guarda el valor
+	workers, err := s.ScaleWorkers(ctx, 4)
+	require.NoError(t, err)
+	assert.Equal(t, models.QueueWorkers{Workers: 4, Default: 2, Override: true, Running: 0}, *workers)
+
+	s.Start()
+	defer s.Stop()
+
+	workers, err = s.Workers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, workers.Running)
+	assert.Equal(t, 4, leases())
+
+	t.Run("reducir detiene workers y libera sus leases", func(t *testing.T) {
+		workers, err := s.ScaleWorkers(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, 1, workers.Running)
+		assert.Eventually(t, func() bool { return leases() == 1 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("otra réplica toma el valor fijado por API", func(t *testing.T) {
+		replica := NewQueueService(rc, nil)
+		replica.Start()
+		defer replica.Stop()
+
+		workers, err := replica.Workers(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, workers.Running)
+		assert.Equal(t, 3, workers.Default)
+	})
+
+	t.Run("volver a la configuración", func(t *testing.T) {
+		workers, err := s.ResetWorkers(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, models.QueueWorkers{Workers: 2, Default: 2, Override: false, Running: 2}, *workers)
+	})
+
+	t.Run("fuera de rango", func(t *testing.T) {
+		for _, n := range []int{0, -1, queueMaxWorkers + 1} {
+			_, err := s.ScaleWorkers(ctx, n)
+			require.Error(t, err)
+			assert.Equal(t, 400, err.(*errors.AppError).Code)
+		}
+	})
+}
+
+func TestQueueService_ScaleDownDuringSend(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	s := NewQueueService(&repository.RedisClient{Client: redisClient}, nil)
+	s.SetWorkers(2)
+
+	// Envío lento: cada worker se queda con un mensaje hasta que se libera
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	s.process = func(ctx context.Context, msg *models.QueuedMessage) (string, error) {
+		started <- msg.ID
+		<-release
+		return "WA-" + msg.ID, nil
+	}
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		msgID, err := s.EnqueueMessage(ctx, "ventas", models.MessageTypeText, map[string]string{"text": "hola"})
+		require.NoError(t, err)
+		ids = append(ids, msgID)
+	}
+
+	s.Start()
+	defer s.Stop()
+	<-started
+	<-started
+
+	_, err := s.ScaleWorkers(ctx, 1)
+	require.NoError(t, err)
+
+	// Simula que pasó el TTL de los leases: la renovación debe incluir al worker que se está deteniendo
+	for _, key := range mr.Keys() {
+		if strings.HasPrefix(key, "queue:lease:") {
+			mr.Del(key)
+		}
+	}
+	s.renewLeases()
+
+	n, err := s.ReapOrphans(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	depth, err := s.QueueDepth(ctx, "ventas")
+	require.NoError(t, err)
+	assert.Zero(t, depth)
+
+	close(release)
+	for _, msgID := range ids {
+		assert.Eventually(t, func() bool {
+			item, err := s.GetQueueItem(ctx, msgID)
+			return err == nil && item.Status == models.QueueStatusSent && item.Attempts == 1
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	// El worker detenido libera su lease al terminar y ya no se renueva
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.stopping) == 0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"kero-kero/internal/models"
+	"kero-kero/internal/repository"
+	"kero-kero/pkg/errors"
+)
+
+// quietHoursDays días aceptados en las ventanas de silencio
+var quietHoursDays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// RatePolicyService aplica la política de envío de cada instancia: límites por minuto, hora y
+// día, ráfaga y horarios de silencio. La política por defecto solo limita la cola (X-Async):
+// los envíos síncronos de instancias sin política propia no tienen límite, como antes.
+type RatePolicyService struct {
+	repo     *repository.RatePolicyRepository
+	defaults models.RatePolicy
+	now      func() time.Time
+}
+
+func NewRatePolicyService(repo *repository.RatePolicyRepository, defaults models.RatePolicy) *RatePolicyService {
+	return &RatePolicyService{
+		repo:     repo,
+		defaults: defaults,
+		now:      time.Now,
+	}
+}
+
+// GetPolicy obtiene la política de una instancia (la de por defecto de la cola si no tiene una propia)
+func (s *RatePolicyService) GetPolicy(ctx context.Context, instanceID string) (*models.RatePolicy, error) {
+	policy, err := s.repo.Get(ctx, instanceID)
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	if policy == nil {
+		defaults := s.defaults
+		return &defaults, nil
+	}
+	return policy, nil
+}
+
+// SetPolicy valida y guarda la política de una instancia
+func (s *RatePolicyService) SetPolicy(ctx context.Context, instanceID string, policy *models.RatePolicy) (*models.RatePolicy, error) {
+	if err := validateRatePolicy(policy); err != nil {
+		return nil, err
+	}
+
+	now := s.now().UTC()
+	policy.UpdatedAt = &now
+	if err := s.repo.Set(ctx, instanceID, policy); err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+
+	log.Info().Str("instance_id", instanceID).Msg("Política de envío actualizada")
+	return policy, nil
+}
+
+// DeletePolicy elimina la política propia de una instancia, que vuelve a la de por defecto
+func (s *RatePolicyService) DeletePolicy(ctx context.Context, instanceID string) error {
+	if err := s.repo.Delete(ctx, instanceID); err != nil {
+		return errors.ErrInternalServer.Wrap(err)
+	}
+	return nil
+}
+
+// Check registra un envío de la instancia según su política propia. Si la política no lo
+// permite retorna un 429 con el tiempo de espera en RetryAfter y no consume cupo.
+func (s *RatePolicyService) Check(ctx context.Context, instanceID string) error {
+	policy, err := s.repo.Get(ctx, instanceID)
+	if err != nil {
+		// Si no se puede leer la política no bloqueamos los envíos
+		log.Error().Err(err).Str("instance_id", instanceID).Msg("Error obteniendo política de envío")
+		return nil
+	}
+	if policy == nil {
+		return nil
+	}
+	return s.apply(ctx, instanceID, policy)
+}
+
+// CheckQueueDefault aplica la política por defecto a un envío de la cola de una instancia sin
+// política propia. Las que tienen una la aplican en Check, así que aquí no consumen cupo.
+func (s *RatePolicyService) CheckQueueDefault(ctx context.Context, instanceID string) error {
+	policy, err := s.repo.Get(ctx, instanceID)
+	if err != nil {
+		log.Error().Err(err).Str("instance_id", instanceID).Msg("Error obteniendo política de envío")
+		return nil
+	}
+	if policy != nil {
+		return nil
+	}
+	defaults := s.defaults
+	return s.apply(ctx, instanceID, &defaults)
+}
+
+// apply registra un envío de la instancia bajo la política indicada
+func (s *RatePolicyService) apply(ctx context.Context, instanceID string, policy *models.RatePolicy) error {
+	loc := policyLocation(policy)
+	now := s.now().In(loc)
+
+	if until, quiet := quietUntil(policy, now); quiet {
+		return errors.ErrSendLimitReached.
+			WithDetails(fmt.Sprintf("Horario de silencio hasta %s (%s)", until.Format("15:04"), loc)).
+			WithRetryAfter(until.Sub(now))
+	}
+
+	windows := rateWindows(policy, now)
+	if len(windows) == 0 && policy.Burst == 0 {
+		return nil
+	}
+
+	wait, err := s.repo.Acquire(ctx, instanceID, windows, policy.Burst, refillRate(policy), now)
+	if err != nil {
+		log.Error().Err(err).Str("instance_id", instanceID).Msg("Error verificando límite de envío")
+		return nil
+	}
+	if wait > 0 {
+		return errors.ErrSendLimitReached.
+			WithDetails(fmt.Sprintf("Reintente en %s", wait.Round(time.Second))).
+			WithRetryAfter(wait)
+	}
+	return nil
+}
+
+// rateWindows ventanas fijas en curso de los límites configurados
+func rateWindows(policy *models.RatePolicy, now time.Time) []repository.RateWindow {
+	var windows []repository.RateWindow
+	if policy.PerMinute > 0 {
+		start := now.Truncate(time.Minute)
+		windows = append(windows, repository.RateWindow{Name: "m", Start: start, End: start.Add(time.Minute), Limit: policy.PerMinute})
+	}
+	if policy.PerHour > 0 {
+		start := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
+		windows = append(windows, repository.RateWindow{Name: "h", Start: start, End: start.Add(time.Hour), Limit: policy.PerHour})
+	}
+	if policy.PerDay > 0 {
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		windows = append(windows, repository.RateWindow{Name: "d", Start: start, End: start.AddDate(0, 0, 1), Limit: policy.PerDay})
+	}
+	return windows
+}
+
+// refillRate ritmo al que se repone la ráfaga (envíos por segundo): el del límite más corto
+func refillRate(policy *models.RatePolicy) float64 {
+	switch {
+	case policy.PerMinute > 0:
+		return float64(policy.PerMinute) / 60
+	case policy.PerHour > 0:
+		return float64(policy.PerHour) / 3600
+	case policy.PerDay > 0:
+		return float64(policy.PerDay) / 86400
+	}
+	return 0
+}
+
+func policyLocation(policy *models.RatePolicy) *time.Location {
+	if policy.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(policy.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// quietUntil indica si now cae en un horario de silencio y cuándo termina.
+// Una ventana que cruza la medianoche pertenece al día en que empieza.
+func quietUntil(policy *models.RatePolicy, now time.Time) (time.Time, bool) {
+	var until time.Time
+	for _, q := range policy.QuietHours {
+		startMin, _ := parseClock(q.Start)
+		endMin, _ := parseClock(q.End)
+		for _, offset := range []int{-1, 0} {
+			day := time.Date(now.Year(), now.Month(), now.Day()+offset, 0, 0, 0, 0, now.Location())
+			if !quietHoursApply(q.Days, day.Weekday()) {
+				continue
+			}
+			start := clockOn(day, startMin)
+			end := clockOn(day, endMin)
+			if endMin <= startMin {
+				end = clockOn(day.AddDate(0, 0, 1), endMin)
+			}
+			if !now.Before(start) && now.Before(end) && end.After(until) {
+				until = end
+			}
+		}
+	}
+	return until, !until.IsZero()
+}
+
+func quietHoursApply(days []string, weekday time.Weekday) bool {
+	if len(days) == 0 {
+		return true
+	}
+	for _, day := range days {
+		if quietHoursDays[strings.ToLower(day)] == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// clockOn hora del día indicado, en minutos desde la medianoche
+func clockOn(day time.Time, minutes int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), minutes/60, minutes%60, 0, 0, day.Location())
+}
+
+// parseClock convierte HH:MM en minutos desde la medianoche
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func validateRatePolicy(policy *models.RatePolicy) error {
+	if policy.PerMinute < 0 || policy.PerHour < 0 || policy.PerDay < 0 || policy.Burst < 0 {
+		return errors.ErrBadRequest.WithDetails("Los límites no pueden ser negativos")
+	}
+	if policy.Burst > 0 && refillRate(policy) == 0 {
+		return errors.ErrBadRequest.WithDetails("burst requiere per_minute, per_hour o per_day")
+	}
+	if policy.Timezone != "" {
+		if _, err := time.LoadLocation(policy.Timezone); err != nil {
+			return errors.ErrBadRequest.WithDetails("timezone inválida: " + policy.Timezone)
+		}
+	}
+	for i, q := range policy.QuietHours {
+		start, err := parseClock(q.Start)
+		if err != nil {
+			return errors.ErrBadRequest.WithDetails(fmt.Sprintf("quiet_hours[%d].start debe tener formato HH:MM", i))
+		}
+		end, err := parseClock(q.End)
+		if err != nil {
+			return errors.ErrBadRequest.WithDetails(fmt.Sprintf("quiet_hours[%d].end debe tener formato HH:MM", i))
+		}
+		if start == end {
+			return errors.ErrBadRequest.WithDetails(fmt.Sprintf("quiet_hours[%d]: start y end no pueden ser iguales", i))
+		}
+		for _, day := range q.Days {
+			if _, ok := quietHoursDays[strings.ToLower(day)]; !ok {
+				return errors.ErrBadRequest.WithDetails(fmt.Sprintf("quiet_hours[%d]: día inválido %q (mon, tue, wed, thu, fri, sat, sun)", i, day))
+			}
+		}
+	}
+	return nil
+}
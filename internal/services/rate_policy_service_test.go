@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"kero-kero/internal/models"
+	"kero-kero/internal/repository"
+	"kero-kero/internal/testutil"
+	"kero-kero/pkg/errors"
+)
+
+func TestRatePolicyService_Limits(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	repo := repository.NewRatePolicyRepository(&repository.RedisClient{Client: redisClient})
+	s := NewRatePolicyService(repo, models.RatePolicy{PerMinute: 2})
+
+	now := time.Date(2026, 3, 10, 12, 0, 10, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	t.Run("límite por minuto de la política por defecto en la cola", func(t *testing.T) {
+		require.NoError(t, s.CheckQueueDefault(ctx, "ventas"))
+		require.NoError(t, s.CheckQueueDefault(ctx, "ventas"))
+
+		err := s.CheckQueueDefault(ctx, "ventas")
+		require.Error(t, err)
+		appErr := err.(*errors.AppError)
+		assert.Equal(t, 429, appErr.Code)
+		assert.Equal(t, 50, appErr.RetryAfter) // Hasta el siguiente minuto
+
+		// Cada instancia tiene su propio cupo
+		require.NoError(t, s.CheckQueueDefault(ctx, "soporte"))
+
+		now = now.Add(50 * time.Second)
+		require.NoError(t, s.CheckQueueDefault(ctx, "ventas"))
+	})
+
+	t.Run("sin política propia los envíos síncronos no tienen límite", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			require.NoError(t, s.Check(ctx, "ventas"))
+		}
+	})
+
+	t.Run("ráfaga que se repone al ritmo del límite", func(t *testing.T) {
+		_, err := s.SetPolicy(ctx, "masivo", &models.RatePolicy{PerMinute: 60, Burst: 3})
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, s.Check(ctx, "masivo"))
+		}
+		err = s.Check(ctx, "masivo")
+		require.Error(t, err)
+		assert.Equal(t, 1, err.(*errors.AppError).RetryAfter)
+
+		now = now.Add(time.Second)
+		require.NoError(t, s.Check(ctx, "masivo"))
+		assert.Error(t, s.Check(ctx, "masivo"))
+
+		// Con política propia la de por defecto no consume cupo en la cola
+		assert.NoError(t, s.CheckQueueDefault(ctx, "masivo"))
+	})
+
+	t.Run("eliminar la política vuelve a la de por defecto", func(t *testing.T) {
+		policy, err := s.GetPolicy(ctx, "masivo")
+		require.NoError(t, err)
+		assert.Equal(t, 3, policy.Burst)
+		assert.NotNil(t, policy.UpdatedAt)
+
+		require.NoError(t, s.DeletePolicy(ctx, "masivo"))
+		policy, err = s.GetPolicy(ctx, "masivo")
+		require.NoError(t, err)
+		assert.Equal(t, models.RatePolicy{PerMinute: 2}, *policy)
+	})
+}
+
+func TestRatePolicyService_QuietHours(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	repo := repository.NewRatePolicyRepository(&repository.RedisClient{Client: redisClient})
+	s := NewRatePolicyService(repo, models.RatePolicy{})
+
+	_, err := s.SetPolicy(ctx, "ventas", &models.RatePolicy{
+		Timezone: "America/Mexico_City", // UTC-6
+		QuietHours: []models.QuietHours{
+			{Start: "22:00", End: "07:00"},
+			{Start: "13:00", End: "15:00", Days: []string{"sat", "sun"}},
+		},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		at         time.Time
+		retryAfter int
+	}{
+		{"antes de la ventana nocturna", time.Date(2026, 3, 10, 3, 59, 0, 0, time.UTC), 0},           // 21:59 local
+		{"ventana nocturna", time.Date(2026, 3, 10, 4, 0, 0, 0, time.UTC), 9 * 3600},                 // 22:00 local
+		{"después de medianoche", time.Date(2026, 3, 11, 12, 30, 0, 0, time.UTC), 30 * 60},           // 06:30 local
+		{"fin de la ventana", time.Date(2026, 3, 11, 13, 0, 0, 0, time.UTC), 0},                      // 07:00 local
+		{"ventana solo de fin de semana (martes)", time.Date(2026, 3, 10, 20, 0, 0, 0, time.UTC), 0}, // 14:00 local
+		{"ventana solo de fin de semana (sábado)", time.Date(2026, 3, 14, 20, 0, 0, 0, time.UTC), 3600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.now = func() time.Time { return tt.at }
+			err := s.Check(ctx, "ventas")
+			if tt.retryAfter == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, tt.retryAfter, err.(*errors.AppError).RetryAfter)
+		})
+	}
+}
+
+func TestRatePolicyService_Validation(t *testing.T) {
+	invalid := []models.RatePolicy{
+		{PerMinute: -1},
+		{Burst: 5},
+		{Timezone: "Marte/Olympus"},
+		{QuietHours: []models.QuietHours{{Start: "25:00", End: "07:00"}}},
+		{QuietHours: []models.QuietHours{{Start: "22:00", End: "22:00"}}},
+		{QuietHours: []models.QuietHours{{Start: "22:00", End: "07:00", Days: []string{"lunes"}}}},
+	}
+	for _, policy := range invalid {
+		assert.Error(t, validateRatePolicy(&policy), "%+v", policy)
+	}
+
+	assert.NoError(t, validateRatePolicy(&models.RatePolicy{
+		PerMinute: 20, PerDay: 1000, Burst: 5, Timezone: "Europe/Madrid",
+		QuietHours: []models.QuietHours{{Start: "22:00", End: "08:00", Days: []string{"Mon", "fri"}}},
+	}))
+}
+
+func TestQueueService_SendLimitPausesInstance(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	s := NewQueueService(&repository.RedisClient{Client: redisClient}, nil)
+
+	limited, err := s.EnqueueMessage(ctx, "ventas", models.MessageTypeText, map[string]string{"text": "promo"})
+	require.NoError(t, err)
+	_, err = s.EnqueueMessage(ctx, "ventas", models.MessageTypeText, map[string]string{"text": "promo 2"})
+	require.NoError(t, err)
+	_, err = s.EnqueueMessage(ctx, "soporte", models.MessageTypeText, map[string]string{"text": "ticket"})
+	require.NoError(t, err)
+
+	processingKey := repository.QueueProcessingKey(s.nodeID, 0)
+	next := func() *models.QueuedMessage {
+		data, err := s.queueRepo.Dequeue(ctx, processingKey)
+		if err == redis.Nil {
+			return nil
+		}
+		require.NoError(t, err)
+		require.NoError(t, s.queueRepo.Ack(ctx, processingKey, data))
+
+		var msg models.QueuedMessage
+		require.NoError(t, json.Unmarshal([]byte(data), &msg))
+		return &msg
+	}
+
+	msg := next()
+	require.Equal(t, limited, msg.ID)
+	limitErr := errors.ErrSendLimitReached.WithRetryAfter(30 * time.Second)
+	assert.True(t, isSendLimited(limitErr))
+	s.handleRateLimitRetry(msg, limitErr)
+
+	item, err := s.GetQueueItem(ctx, limited)
+	require.NoError(t, err)
+	assert.Equal(t, models.QueueStatusPending, item.Status)
+	assert.Equal(t, 0, item.Attempts)
+
+	// La cola de "ventas" queda en pausa; las demás instancias siguen
+	assert.Equal(t, "soporte", next().InstanceID)
+	assert.Nil(t, next())
+
+	depth, err := s.QueueDepth(ctx, "ventas")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), depth)
+
+	// Al terminar la pausa se retoma en el mismo orden
+	mr.FastForward(31 * time.Second)
+	assert.Equal(t, limited, next().ID)
+	assert.Equal(t, "ventas", next().InstanceID)
+	assert.Nil(t, next())
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"kero-kero/internal/models"
+	"kero-kero/pkg/errors"
+)
+
+// renderedWebhook cuerpo y headers que se envían al receptor según el formato de la suscripción
+type renderedWebhook struct {
+	Body        []byte
+	ContentType string
+	Headers     map[string]string
+}
+
+// webhookTemplateFuncs funciones disponibles en las plantillas de usuario
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"default": func(fallback, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return fallback
+		}
+		return v
+	},
+}
+
+// validateWebhookFormat verifica el formato de una suscripción y compila su plantilla
+func validateWebhookFormat(format string, tmpl *models.WebhookTemplate) error {
+	switch format {
+	case "", models.WebhookFormatNative, models.WebhookFormatSlack, models.WebhookFormatChatwoot, models.WebhookFormatForm:
+		return nil
+	case models.WebhookFormatTemplate:
+		if tmpl == nil || tmpl.Body == "" {
+			return errors.ErrBadRequest.WithDetails("template.body es requerido con format \"template\"")
+		}
+		if _, err := template.New("body").Funcs(webhookTemplateFuncs).Parse(tmpl.Body); err != nil {
+			return errors.ErrBadRequest.WithDetails("template.body inválido: " + err.Error())
+		}
+		for name, value := range tmpl.Headers {
+			if _, err := template.New(name).Funcs(webhookTemplateFuncs).Parse(value); err != nil {
+				return errors.ErrBadRequest.WithDetails(fmt.Sprintf("template.headers[%s] inválido: %s", name, err.Error()))
+			}
+		}
+		return nil
+	default:
+		return errors.ErrBadRequest.WithDetails("format inválido (native, slack, chatwoot, form, template)")
+	}
+}
+
+// renderWebhook transforma el evento original (JSON de WebhookEvent) al formato de la suscripción
+func renderWebhook(config *models.WebhookConfig, payload []byte) (*renderedWebhook, error) {
+	if config.Format == "" || config.Format == models.WebhookFormatNative {
+		return &renderedWebhook{Body: payload, ContentType: "application/json"}, nil
+	}
+
+	var event map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&event); err != nil {
+		return nil, fmt.Errorf("error decoding event: %w", err)
+	}
+
+	switch config.Format {
+	case models.WebhookFormatSlack:
+		body, err := json.Marshal(slackMessage(event))
+		return &renderedWebhook{Body: body, ContentType: "application/json"}, err
+	case models.WebhookFormatChatwoot:
+		body, err := json.Marshal(chatwootMessage(event))
+		return &renderedWebhook{Body: body, ContentType: "application/json"}, err
+	case models.WebhookFormatForm:
+		values := url.Values{}
+		flattenValues(values, "", event)
+		return &renderedWebhook{Body: []byte(values.Encode()), ContentType: "application/x-www-form-urlencoded"}, nil
+	case models.WebhookFormatTemplate:
+		return renderWebhookTemplate(config.Template, event)
+	default:
+		return nil, fmt.Errorf("formato de webhook desconocido: %s", config.Format)
+	}
+}
+
+// renderWebhookTemplate ejecuta las plantillas de cuerpo y headers del usuario
+func renderWebhookTemplate(tmpl *models.WebhookTemplate, event map[string]interface{}) (*renderedWebhook, error) {
+	if tmpl == nil {
+		return nil, fmt.Errorf("la suscripción no tiene plantilla")
+	}
+
+	body, err := executeWebhookTemplate("body", tmpl.Body, event)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := &renderedWebhook{Body: []byte(body), ContentType: "application/json", Headers: map[string]string{}}
+	for name, value := range tmpl.Headers {
+		header, err := executeWebhookTemplate(name, value, event)
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(name, "Content-Type") {
+			rendered.ContentType = header
+			continue
+		}
+		rendered.Headers[name] = header
+	}
+	return rendered, nil
+}
+
+func executeWebhookTemplate(name, text string, event map[string]interface{}) (string, error) {
+	tmpl, err := template.New(name).Funcs(webhookTemplateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("error parsing template %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return "", fmt.Errorf("error executing template %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// slackMessage construye un mensaje para los incoming webhooks de Slack
+func slackMessage(event map[string]interface{}) map[string]interface{} {
+	text := webhookSummary(event)
+	return map[string]interface{}{
+		"text": text,
+		"blocks": []map[string]interface{}{
+			{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": text},
+			},
+			{
+				"type": "context",
+				"elements": []map[string]string{
+					{"type": "mrkdwn", "text": fmt.Sprintf("Instancia `%s` · evento `%s`", stringField(event, "instance_id"), stringField(event, "event"))},
+				},
+			},
+		},
+	}
+}
+
+// webhookSummary resume un evento en una línea legible
+func webhookSummary(event map[string]interface{}) string {
+	data, _ := event["data"].(map[string]interface{})
+	switch stringField(event, "event") {
+	case "message":
+		sender := stringField(data, "sender_name")
+		if sender == "" {
+			sender = stringField(data, "from")
+		}
+		text := stringField(data, "text")
+		if caption := stringField(data, "caption"); caption != "" {
+			text = caption
+		}
+		if chat := stringField(data, "chat_name"); chat != "" && data["is_group"] == true {
+			return fmt.Sprintf("*%s* en *%s*: %s", sender, chat, text)
+		}
+		return fmt.Sprintf("*%s*: %s", sender, text)
+	case "status":
+		return fmt.Sprintf("Instancia `%s`: %s", stringField(event, "instance_id"), stringField(data, "status"))
+	default:
+		return fmt.Sprintf("Evento `%s` de la instancia `%s`", stringField(event, "event"), stringField(event, "instance_id"))
+	}
+}
+
+// chatwootMessage imita los webhooks message_created de Chatwoot. Los eventos que no son
+// mensajes se envían con el nombre original y los datos sin transformar.
+func chatwootMessage(event map[string]interface{}) map[string]interface{} {
+	data, _ := event["data"].(map[string]interface{})
+	if stringField(event, "event") != "message" || data == nil {
+		return map[string]interface{}{
+			"event":       stringField(event, "event"),
+			"instance_id": stringField(event, "instance_id"),
+			"data":        event["data"],
+		}
+	}
+
+	messageType := "incoming"
+	if data["is_from_me"] == true {
+		messageType = "outgoing"
+	}
+	content := stringField(data, "text")
+	if caption := stringField(data, "caption"); caption != "" {
+		content = caption
+	}
+	from := stringField(data, "from")
+	phone, _, _ := strings.Cut(from, "@")
+
+	message := map[string]interface{}{
+		"event":        "message_created",
+		"message_type": messageType,
+		"content":      content,
+		"content_type": stringField(data, "message_type"),
+		"source_id":    stringField(data, "message_id"),
+		"created_at":   data["timestamp"],
+		"sender": map[string]interface{}{
+			"identifier":   from,
+			"name":         stringField(data, "sender_name"),
+			"phone_number": "+" + phone,
+		},
+		"conversation": map[string]interface{}{
+			"identifier": stringField(data, "to"),
+			"name":       stringField(data, "chat_name"),
+			"is_group":   data["is_group"] == true,
+		},
+		"inbox": map[string]interface{}{
+			"identifier": stringField(event, "instance_id"),
+		},
+	}
+	if mediaURL := stringField(data, "media_url"); mediaURL != "" || stringField(data, "media_data") != "" {
+		message["attachments"] = []map[string]interface{}{{
+			"file_type": stringField(data, "message_type"),
+			"file_name": stringField(data, "file_name"),
+			"mime_type": stringField(data, "mime_type"),
+			"data_url":  mediaURL,
+			"data":      stringField(data, "media_data"),
+		}}
+	}
+	return message
+}
+
+// flattenValues aplana un JSON en claves con puntos (data.text, data.ids.0) para el modo form
+func flattenValues(values url.Values, prefix string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			flattenValues(values, joinKey(prefix, k), v[k])
+		}
+	case []interface{}:
+		for i, item := range v {
+			flattenValues(values, joinKey(prefix, strconv.Itoa(i)), item)
+		}
+	case nil:
+		values.Set(prefix, "")
+	default:
+		values.Set(prefix, fmt.Sprint(v))
+	}
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func stringField(m map[string]interface{}, key string) string {
+	if m == nil {
+		return ""
+	}
+	if s, ok := m[key].(string); ok {
+		return s
+	}
+	return ""
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"kero-kero/internal/models"
+)
+
+func TestRenderWebhook(t *testing.T) {
+	payload, err := json.Marshal(&models.WebhookEvent{
+		InstanceID: "ventas",
+		Event:      "message",
+		Timestamp:  1700000000,
+		Data: models.MessageEvent{
+			MessageID:   "ABC123",
+			From:        "5215511111111@s.whatsapp.net",
+			To:          "5215511111111@s.whatsapp.net",
+			MessageType: "text",
+			Text:        "Hola",
+			SenderName:  "Ana",
+			Timestamp:   1700000000,
+		},
+	})
+	require.NoError(t, err)
+
+	t.Run("nativo sin cambios", func(t *testing.T) {
+		rendered, err := renderWebhook(&models.WebhookConfig{}, payload)
+		require.NoError(t, err)
+		assert.Equal(t, payload, rendered.Body)
+		assert.Equal(t, "application/json", rendered.ContentType)
+	})
+
+	t.Run("slack", func(t *testing.T) {
+		rendered, err := renderWebhook(&models.WebhookConfig{Format: models.WebhookFormatSlack}, payload)
+		require.NoError(t, err)
+
+		var msg struct {
+			Text   string                   `json:"text"`
+			Blocks []map[string]interface{} `json:"blocks"`
+		}
+		require.NoError(t, json.Unmarshal(rendered.Body, &msg))
+		assert.Equal(t, "*Ana*: Hola", msg.Text)
+		assert.Len(t, msg.Blocks, 2)
+	})
+
+	t.Run("chatwoot", func(t *testing.T) {
+		rendered, err := renderWebhook(&models.WebhookConfig{Format: models.WebhookFormatChatwoot}, payload)
+		require.NoError(t, err)
+
+		var msg map[string]interface{}
+		require.NoError(t, json.Unmarshal(rendered.Body, &msg))
+		assert.Equal(t, "message_created", msg["event"])
+		assert.Equal(t, "incoming", msg["message_type"])
+		assert.Equal(t, "Hola", msg["content"])
+		assert.Equal(t, "+5215511111111", msg["sender"].(map[string]interface{})["phone_number"])
+	})
+
+	t.Run("form con claves planas", func(t *testing.T) {
+		rendered, err := renderWebhook(&models.WebhookConfig{Format: models.WebhookFormatForm}, payload)
+		require.NoError(t, err)
+		assert.Equal(t, "application/x-www-form-urlencoded", rendered.ContentType)
+
+		values, err := url.ParseQuery(string(rendered.Body))
+		require.NoError(t, err)
+		assert.Equal(t, "message", values.Get("event"))
+		assert.Equal(t, "Hola", values.Get("data.text"))
+		assert.Equal(t, "1700000000", values.Get("timestamp"))
+	})
+
+	t.Run("plantilla de usuario", func(t *testing.T) {
+		config := &models.WebhookConfig{
+			Format: models.WebhookFormatTemplate,
+			Template: &models.WebhookTemplate{
+				Body: `{"msg": {{json .data.text}}, "chat": "{{.data.chat_name | default "sin nombre"}}"}`,
+				Headers: map[string]string{
+					"Content-Type": "application/vnd.crm+json",
+					"X-Instance":   "{{.instance_id}}",
+				},
+			},
+		}
+		require.NoError(t, validateWebhookFormat(config.Format, config.Template))
+
+		rendered, err := renderWebhook(config, payload)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"msg": "Hola", "chat": "sin nombre"}`, string(rendered.Body))
+		assert.Equal(t, "application/vnd.crm+json", rendered.ContentType)
+		assert.Equal(t, map[string]string{"X-Instance": "ventas"}, rendered.Headers)
+	})
+
+	t.Run("validar formato y plantilla", func(t *testing.T) {
+		assert.Error(t, validateWebhookFormat("xml", nil))
+		assert.Error(t, validateWebhookFormat(models.WebhookFormatTemplate, nil))
+		assert.Error(t, validateWebhookFormat(models.WebhookFormatTemplate, &models.WebhookTemplate{Body: "{{.event"}))
+		assert.NoError(t, validateWebhookFormat(models.WebhookFormatSlack, nil))
+	})
+}
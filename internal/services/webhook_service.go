@@ -4,124 +4,1443 @@ import (
 	"bytes"
 	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	mrand "math/rand"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 
 	"kero-kero/internal/models"
 	"kero-kero/internal/repository"
+	"kero-kero/internal/whatsapp"
 	"kero-kero/pkg/errors"
+	"kero-kero/pkg/webhooksig"
 )
 
+// WebhookDeliveryPolicy define cómo se despachan y reintentan los eventos de webhook
+type WebhookDeliveryPolicy struct {
+	Workers       int
+	MaxAttempts   int
+	BackoffBase   time.Duration
+	BackoffMax    time.Duration
+	Timeout       time.Duration
+	DeadLetterMax int
+	PollInterval  time.Duration // Cada cuánto se revisan los reintentos vencidos
+	LogRetention  time.Duration // Cuánto se conserva el historial de entregas
+
+	// Circuit breaker: tras CircuitThreshold fallos seguidos se dejan de enviar
+	// entregas a la suscripción y solo se sondea cada ProbeInterval
+	CircuitThreshold int
+	ProbeInterval    time.Duration
+}
+
+// DefaultWebhookDeliveryPolicy retorna la política usada si no se configura otra
+func DefaultWebhookDeliveryPolicy() WebhookDeliveryPolicy {
+	return WebhookDeliveryPolicy{
+		Workers:       4,
+		MaxAttempts:   8,
+		BackoffBase:   2 * time.Second,
+		BackoffMax:    10 * time.Minute,
+		Timeout:       10 * time.Second,
+		DeadLetterMax: 1000,
+		PollInterval:  time.Second,
+		LogRetention:  7 * 24 * time.Hour,
+
+		CircuitThreshold: 5,
+		ProbeInterval:    time.Minute,
+	}
+}
+
+// WebhookEventNotifier recibe los avisos de salud de los webhooks (p. ej. el WebSocket)
+type WebhookEventNotifier interface {
+	BroadcastEvent(eventType string, payload interface{})
+}
+
 type WebhookService struct {
 	webhookRepo *repository.WebhookRepository
+	logRepo     *repository.WebhookLogRepository // Historial de entregas (opcional)
+	envGlobal   *models.WebhookConfig            // Webhook global definido por configuración (opcional)
+	notifier    WebhookEventNotifier             // Avisos webhook.disabled / webhook.recovered (opcional)
 	httpClient  *http.Client
+	policy      WebhookDeliveryPolicy
+	stopChan    chan struct{}
 }
 
 func NewWebhookService(webhookRepo *repository.WebhookRepository) *WebhookService {
+	policy := DefaultWebhookDeliveryPolicy()
 	return &WebhookService{
 		webhookRepo: webhookRepo,
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout: policy.Timeout,
 		},
+		policy:   policy,
+		stopChan: make(chan struct{}),
 	}
 }
 
-// SetWebhook configura el webhook para una instancia
+// SetDeliveryPolicy configura la política de entrega. Debe llamarse antes de Start.
+func (s *WebhookService) SetDeliveryPolicy(policy WebhookDeliveryPolicy) {
+	defaults := DefaultWebhookDeliveryPolicy()
+	if policy.Workers <= 0 {
+		policy.Workers = defaults.Workers
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = defaults.MaxAttempts
+	}
+	if policy.BackoffBase <= 0 {
+		policy.BackoffBase = defaults.BackoffBase
+	}
+	if policy.BackoffMax < policy.BackoffBase {
+		policy.BackoffMax = policy.BackoffBase
+	}
+	if policy.Timeout <= 0 {
+		policy.Timeout = defaults.Timeout
+	}
+	if policy.PollInterval <= 0 {
+		policy.PollInterval = defaults.PollInterval
+	}
+	if policy.LogRetention <= 0 {
+		policy.LogRetention = defaults.LogRetention
+	}
+	if policy.CircuitThreshold <= 0 {
+		policy.CircuitThreshold = defaults.CircuitThreshold
+	}
+	if policy.ProbeInterval <= 0 {
+		policy.ProbeInterval = defaults.ProbeInterval
+	}
+
+	s.policy = policy
+	s.httpClient.Timeout = policy.Timeout
+}
+
+// SetDeliveryLog habilita el historial de entregas. Debe llamarse antes de Start.
+func (s *WebhookService) SetDeliveryLog(logRepo *repository.WebhookLogRepository) {
+	s.logRepo = logRepo
+}
+
+// SetEventNotifier configura a quién se avisa cuando un webhook se deshabilita o se recupera
+func (s *WebhookService) SetEventNotifier(notifier WebhookEventNotifier) {
+	s.notifier = notifier
+}
+
+// Start inicia el dispatcher de webhooks en segundo plano
+func (s *WebhookService) Start() {
+	ctx := context.Background()
+	if n, err := s.webhookRepo.RecoverProcessing(ctx); err != nil {
+		log.Error().Err(err).Msg("Error recuperando entregas de webhook pendientes")
+	} else if n > 0 {
+		log.Warn().Int("count", n).Msg("Entregas de webhook recuperadas tras un reinicio")
+	}
+
+	log.Info().Int("workers", s.policy.Workers).Int("max_attempts", s.policy.MaxAttempts).Msg("Iniciando dispatcher de webhooks")
+	for i := 0; i < s.policy.Workers; i++ {
+		go s.deliveryLoop(i)
+	}
+	go s.retryLoop()
+	go s.batchLoop()
+	if s.logRepo != nil {
+		go s.retentionLoop()
+	}
+}
+
+// Stop detiene el dispatcher
+func (s *WebhookService) Stop() {
+	close(s.stopChan)
+}
+
+// SetWebhook crea o reemplaza la suscripción "default" de una instancia.
+// Se mantiene para la configuración de un solo webhook (p. ej. webhook_url de la instancia).
 func (s *WebhookService) SetWebhook(ctx context.Context, config *models.WebhookConfig) error {
 	if config.InstanceID == "" {
 		return errors.ErrBadRequest.WithDetails("instance_id requerido")
 	}
+	if config.ID == "" {
+		config.ID = repository.DefaultWebhookID
+	}
+	if err := validateWebhookURL(config.URL); err != nil {
+		return err
+	}
+	if err := validateWebhookFilters(config.Filters); err != nil {
+		return err
+	}
+	if err := validateWebhookFormat(config.Format, config.Template); err != nil {
+		return err
+	}
+	if err := normalizeWebhookBatch(config); err != nil {
+		return err
+	}
 
 	if len(config.Events) == 0 {
 		config.Events = []string{"message", "status", "receipt"}
 	}
 
+	if existing, err := s.webhookRepo.Get(ctx, config.InstanceID, config.ID); err == nil {
+		config.CreatedAt = existing.CreatedAt
+	}
+
 	config.Enabled = true
 	return s.webhookRepo.Set(ctx, config)
 }
 
-// GetWebhook obtiene la configuración de webhook
-func (s *WebhookService) GetWebhook(ctx context.Context, instanceID string) (*models.WebhookConfig, error) {
-	return s.webhookRepo.Get(ctx, instanceID)
+// CreateWebhook añade una nueva suscripción de webhook a una instancia
+func (s *WebhookService) CreateWebhook(ctx context.Context, config *models.WebhookConfig) error {
+	if config.InstanceID == "" {
+		return errors.ErrBadRequest.WithDetails("instance_id requerido")
+	}
+	if err := validateWebhookURL(config.URL); err != nil {
+		return err
+	}
+	if err := validateWebhookFilters(config.Filters); err != nil {
+		return err
+	}
+	if err := validateWebhookFormat(config.Format, config.Template); err != nil {
+		return err
+	}
+	if err := normalizeWebhookBatch(config); err != nil {
+		return err
+	}
+
+	if len(config.Events) == 0 {
+		config.Events = []string{"message", "status", "receipt"}
+	}
+
+	config.ID = uuid.New().String()
+	config.Enabled = true
+	config.CreatedAt = time.Time{}
+	if err := s.webhookRepo.Set(ctx, config); err != nil {
+		return errors.ErrInternalServer.Wrap(err)
+	}
+	return nil
 }
 
-// DeleteWebhook elimina la configuración de webhook
-func (s *WebhookService) DeleteWebhook(ctx context.Context, instanceID string) error {
-	return s.webhookRepo.Delete(ctx, instanceID)
+// ListWebhooks obtiene todas las suscripciones de webhook de una instancia
+func (s *WebhookService) ListWebhooks(ctx context.Context, instanceID string) ([]*models.WebhookConfig, error) {
+	configs, err := s.webhookRepo.List(ctx, instanceID)
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	for _, config := range configs {
+		s.attachCircuit(ctx, config)
+	}
+	return configs, nil
 }
 
-// SendEvent envía un evento al webhook configurado
-func (s *WebhookService) SendEvent(ctx context.Context, instanceID string, event *models.WebhookEvent) error {
-	config, err := s.webhookRepo.Get(ctx, instanceID)
+// GetWebhook obtiene una suscripción de webhook
+func (s *WebhookService) GetWebhook(ctx context.Context, instanceID, webhookID string) (*models.WebhookConfig, error) {
+	config, err := s.webhookRepo.Get(ctx, instanceID, webhookID)
+	if err == redis.Nil {
+		return nil, errors.ErrNotFound.WithDetails("webhook no encontrado")
+	}
 	if err != nil {
-		log.Debug().Err(err).Str("instance_id", instanceID).Msg("No hay webhook configurado")
-		return nil // No es un error crítico
+		return nil, errors.ErrInternalServer.Wrap(err)
 	}
+	s.attachCircuit(ctx, config)
+	return config, nil
+}
 
-	if !config.Enabled {
-		return nil
+// attachCircuit completa el estado del circuit breaker de una suscripción
+func (s *WebhookService) attachCircuit(ctx context.Context, config *models.WebhookConfig) {
+	circuit, err := s.webhookRepo.GetCircuit(ctx, config.InstanceID, config.ID, config.Global)
+	if err != nil {
+		log.Error().Err(err).Str("webhook_id", config.ID).Msg("Error obteniendo estado del circuito de webhook")
+		return
+	}
+	config.Circuit = circuit
+}
+
+// UpdateWebhook actualiza los campos enviados de una suscripción de webhook
+func (s *WebhookService) UpdateWebhook(ctx context.Context, instanceID, webhookID string, req *models.UpdateWebhookRequest) (*models.WebhookConfig, error) {
+	config, err := s.GetWebhook(ctx, instanceID, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyWebhookUpdate(config, req); err != nil {
+		return nil, err
 	}
 
-	// Verificar si el evento está en la lista de eventos configurados
-	eventEnabled := false
-	for _, e := range config.Events {
-		if e == event.Event || e == "all" {
-			eventEnabled = true
+	if err := s.webhookRepo.Set(ctx, config); err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	s.resetCircuitOnUpdate(ctx, config, req)
+	return config, nil
+}
+
+// resetCircuitOnUpdate cierra el circuito si el operador cambió la URL o volvió a habilitar la suscripción
+func (s *WebhookService) resetCircuitOnUpdate(ctx context.Context, config *models.WebhookConfig, req *models.UpdateWebhookRequest) {
+	if req.URL == nil && (req.Enabled == nil || !*req.Enabled) {
+		return
+	}
+	if err := s.webhookRepo.ResetCircuit(ctx, config.InstanceID, config.ID, config.Global); err != nil {
+		log.Error().Err(err).Str("webhook_id", config.ID).Msg("Error reiniciando circuito de webhook")
+		return
+	}
+	config.Circuit = &models.WebhookCircuit{State: models.WebhookCircuitClosed}
+}
+
+// applyWebhookUpdate aplica una actualización parcial sobre una suscripción
+func applyWebhookUpdate(config *models.WebhookConfig, req *models.UpdateWebhookRequest) error {
+	if req.URL != nil {
+		if err := validateWebhookURL(*req.URL); err != nil {
+			return err
+		}
+		config.URL = *req.URL
+	}
+	if req.Events != nil {
+		if len(req.Events) == 0 {
+			return errors.ErrBadRequest.WithDetails("events no puede estar vacío")
+		}
+		config.Events = req.Events
+	}
+	if req.Secret != nil {
+		// Cambiar el secreto directamente termina cualquier rotación en curso
+		config.Secret = *req.Secret
+		config.PreviousSecret = ""
+		config.PreviousSecretExpiresAt = nil
+	}
+	if req.Enabled != nil {
+		config.Enabled = *req.Enabled
+	}
+	if req.Format != nil || req.Template != nil {
+		format, tmpl := config.Format, config.Template
+		if req.Format != nil {
+			format = *req.Format
+		}
+		if req.Template != nil {
+			tmpl = req.Template
+		}
+		if err := validateWebhookFormat(format, tmpl); err != nil {
+			return err
+		}
+		config.Format, config.Template = format, tmpl
+	}
+	if req.Batch != nil {
+		config.Batch = req.Batch
+		if req.Batch.MaxSize == 0 && req.Batch.MaxLingerMs == 0 {
+			config.Batch = nil
+		}
+	}
+	if req.Format != nil || req.Batch != nil {
+		if err := normalizeWebhookBatch(config); err != nil {
+			return err
+		}
+	}
+	if req.Filters != nil {
+		if err := validateWebhookFilters(req.Filters); err != nil {
+			return err
+		}
+		config.Filters = req.Filters
+		if isEmptyWebhookFilters(req.Filters) {
+			config.Filters = nil
+		}
+	}
+	return nil
+}
+
+// RotateWebhookSecret reemplaza el secreto de una suscripción sin cortar las entregas.
+// Durante el periodo de gracia cada petición lleva una firma con el secreto nuevo y otra con el anterior.
+func (s *WebhookService) RotateWebhookSecret(ctx context.Context, instanceID, webhookID string, req *models.RotateWebhookSecretRequest) (*models.WebhookConfig, error) {
+	config, err := s.GetWebhook(ctx, instanceID, webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		if secret, err = generateWebhookSecret(); err != nil {
+			return nil, errors.ErrInternalServer.Wrap(err)
+		}
+	}
+	if secret == config.Secret {
+		return nil, errors.ErrBadRequest.WithDetails("el secreto nuevo debe ser distinto al actual")
+	}
+
+	grace := time.Duration(req.GracePeriod) * time.Second
+	if grace <= 0 {
+		grace = 24 * time.Hour
+	}
+
+	config.PreviousSecret = ""
+	config.PreviousSecretExpiresAt = nil
+	if config.Secret != "" {
+		expiresAt := time.Now().Add(grace)
+		config.PreviousSecret = config.Secret
+		config.PreviousSecretExpiresAt = &expiresAt
+	}
+	config.Secret = secret
+
+	if err := s.webhookRepo.Set(ctx, config); err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+
+	log.Info().Str("instance_id", instanceID).Str("webhook_id", webhookID).Dur("grace_period", grace).Msg("Secreto de webhook rotado")
+	return config, nil
+}
+
+// generateWebhookSecret genera un secreto aleatorio de 32 bytes en hex
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// activeSecrets retorna los secretos con los que se firma: el actual y, durante una rotación, el anterior
+func activeSecrets(config *models.WebhookConfig, now time.Time) []string {
+	secrets := []string{config.Secret}
+	if config.PreviousSecret != "" && config.PreviousSecretExpiresAt != nil && now.Before(*config.PreviousSecretExpiresAt) {
+		secrets = append(secrets, config.PreviousSecret)
+	}
+	return secrets
+}
+
+// DeleteWebhook elimina una suscripción de webhook
+func (s *WebhookService) DeleteWebhook(ctx context.Context, instanceID, webhookID string) error {
+	err := s.webhookRepo.Delete(ctx, instanceID, webhookID)
+	if err == redis.Nil {
+		return errors.ErrNotFound.WithDetails("webhook no encontrado")
+	}
+	if err != nil {
+		return errors.ErrInternalServer.Wrap(err)
+	}
+	s.webhookRepo.ResetCircuit(ctx, instanceID, webhookID, false)
+	return nil
+}
+
+// DeleteWebhooks elimina todas las suscripciones de webhook de una instancia
+func (s *WebhookService) DeleteWebhooks(ctx context.Context, instanceID string) error {
+	if configs, err := s.webhookRepo.List(ctx, instanceID); err == nil {
+		for _, config := range configs {
+			s.webhookRepo.ResetCircuit(ctx, instanceID, config.ID, false)
+		}
+	}
+	if err := s.webhookRepo.DeleteAll(ctx, instanceID); err != nil {
+		return errors.ErrInternalServer.Wrap(err)
+	}
+	return nil
+}
+
+// EnvGlobalWebhookID identifica el webhook global definido por variables de entorno
+const EnvGlobalWebhookID = "env"
+
+// SetEnvGlobalWebhook registra el webhook global definido por configuración (WEBHOOK_GLOBAL_URL).
+// No se guarda en Redis: no se puede modificar ni eliminar por API.
+func (s *WebhookService) SetEnvGlobalWebhook(url string, events []string, secret string) error {
+	if err := validateWebhookURL(url); err != nil {
+		return err
+	}
+
+	var cleaned []string
+	for _, e := range events {
+		if e = strings.TrimSpace(e); e != "" {
+			cleaned = append(cleaned, e)
+		}
+	}
+	if len(cleaned) == 0 {
+		cleaned = []string{"message", "status", "receipt"}
+	}
+
+	s.envGlobal = &models.WebhookConfig{
+		ID:        EnvGlobalWebhookID,
+		URL:       url,
+		Events:    cleaned,
+		Secret:    secret,
+		Enabled:   true,
+		Global:    true,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	log.Info().Str("url", url).Strs("events", cleaned).Msg("Webhook global configurado por entorno")
+	return nil
+}
+
+// CreateGlobalWebhook añade una suscripción que recibe los eventos de todas las instancias
+func (s *WebhookService) CreateGlobalWebhook(ctx context.Context, config *models.WebhookConfig) error {
+	if err := validateWebhookURL(config.URL); err != nil {
+		return err
+	}
+	if err := validateWebhookFilters(config.Filters); err != nil {
+		return err
+	}
+	if err := validateWebhookFormat(config.Format, config.Template); err != nil {
+		return err
+	}
+	if err := normalizeWebhookBatch(config); err != nil {
+		return err
+	}
+
+	if len(config.Events) == 0 {
+		config.Events = []string{"message", "status", "receipt"}
+	}
+
+	config.ID = uuid.New().String()
+	config.Enabled = true
+	config.CreatedAt = time.Time{}
+	if err := s.webhookRepo.SetGlobal(ctx, config); err != nil {
+		return errors.ErrInternalServer.Wrap(err)
+	}
+	return nil
+}
+
+// ListGlobalWebhooks obtiene las suscripciones globales, incluida la definida por entorno
+func (s *WebhookService) ListGlobalWebhooks(ctx context.Context) ([]*models.WebhookConfig, error) {
+	configs, err := s.globalSubscriptions(ctx)
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	for i, config := range configs {
+		if config == s.envGlobal {
+			envCopy := *config
+			config = &envCopy
+			configs[i] = config
+		}
+		s.attachCircuit(ctx, config)
+	}
+	return configs, nil
+}
+
+// globalSubscriptions obtiene las suscripciones globales, sin estado del circuito
+func (s *WebhookService) globalSubscriptions(ctx context.Context) ([]*models.WebhookConfig, error) {
+	configs, err := s.webhookRepo.ListGlobal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.envGlobal != nil {
+		configs = append([]*models.WebhookConfig{s.envGlobal}, configs...)
+	}
+	return configs, nil
+}
+
+// GetGlobalWebhook obtiene una suscripción global
+func (s *WebhookService) GetGlobalWebhook(ctx context.Context, webhookID string) (*models.WebhookConfig, error) {
+	if webhookID == EnvGlobalWebhookID && s.envGlobal != nil {
+		envCopy := *s.envGlobal
+		s.attachCircuit(ctx, &envCopy)
+		return &envCopy, nil
+	}
+
+	config, err := s.webhookRepo.GetGlobal(ctx, webhookID)
+	if err == redis.Nil {
+		return nil, errors.ErrNotFound.WithDetails("webhook no encontrado")
+	}
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	s.attachCircuit(ctx, config)
+	return config, nil
+}
+
+// UpdateGlobalWebhook actualiza los campos enviados de una suscripción global
+func (s *WebhookService) UpdateGlobalWebhook(ctx context.Context, webhookID string, req *models.UpdateWebhookRequest) (*models.WebhookConfig, error) {
+	if webhookID == EnvGlobalWebhookID {
+		return nil, errors.ErrBadRequest.WithDetails("el webhook global de entorno solo se modifica con WEBHOOK_GLOBAL_*")
+	}
+
+	config, err := s.GetGlobalWebhook(ctx, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyWebhookUpdate(config, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.webhookRepo.SetGlobal(ctx, config); err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	s.resetCircuitOnUpdate(ctx, config, req)
+	return config, nil
+}
+
+// DeleteGlobalWebhook elimina una suscripción global
+func (s *WebhookService) DeleteGlobalWebhook(ctx context.Context, webhookID string) error {
+	if webhookID == EnvGlobalWebhookID {
+		return errors.ErrBadRequest.WithDetails("el webhook global de entorno solo se elimina quitando WEBHOOK_GLOBAL_URL")
+	}
+
+	err := s.webhookRepo.DeleteGlobal(ctx, webhookID)
+	if err == redis.Nil {
+		return errors.ErrNotFound.WithDetails("webhook no encontrado")
+	}
+	if err != nil {
+		return errors.ErrInternalServer.Wrap(err)
+	}
+	s.webhookRepo.ResetCircuit(ctx, "", webhookID, true)
+	return nil
+}
+
+// lookupSubscription obtiene la suscripción de una entrega, sea de la instancia o global
+func (s *WebhookService) lookupSubscription(ctx context.Context, instanceID, webhookID string, global bool) (*models.WebhookConfig, error) {
+	if !global {
+		return s.webhookRepo.Get(ctx, instanceID, webhookID)
+	}
+	if webhookID == EnvGlobalWebhookID {
+		if s.envGlobal == nil {
+			return nil, redis.Nil
+		}
+		return s.envGlobal, nil
+	}
+	return s.webhookRepo.GetGlobal(ctx, webhookID)
+}
+
+// ListDeadLetters obtiene las entregas que agotaron sus reintentos
+func (s *WebhookService) ListDeadLetters(ctx context.Context, instanceID string, limit int) ([]models.WebhookDelivery, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	deliveries, err := s.webhookRepo.ListDeadLetters(ctx, instanceID, limit)
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	return deliveries, nil
+}
+
+// PurgeDeadLetters elimina las entregas fallidas de una instancia
+func (s *WebhookService) PurgeDeadLetters(ctx context.Context, instanceID string) error {
+	if err := s.webhookRepo.PurgeDeadLetters(ctx, instanceID); err != nil {
+		return errors.ErrInternalServer.Wrap(err)
+	}
+	return nil
+}
+
+// ListDeliveries consulta el historial de entregas de una instancia
+func (s *WebhookService) ListDeliveries(ctx context.Context, filter models.WebhookDeliveryFilter) ([]models.WebhookDeliveryLog, error) {
+	if s.logRepo == nil {
+		return nil, errors.ErrServiceUnavailable.WithDetails("historial de entregas no habilitado")
+	}
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 50
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	entries, err := s.logRepo.List(ctx, filter)
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	return entries, nil
+}
+
+// GetDelivery obtiene un intento del historial de entregas
+func (s *WebhookService) GetDelivery(ctx context.Context, instanceID, id string) (*models.WebhookDeliveryLog, error) {
+	if s.logRepo == nil {
+		return nil, errors.ErrServiceUnavailable.WithDetails("historial de entregas no habilitado")
+	}
+
+	entry, err := s.logRepo.GetByID(ctx, instanceID, id)
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	if entry == nil {
+		return nil, errors.ErrNotFound.WithDetails("entrega no encontrada")
+	}
+	return entry, nil
+}
+
+// ReplayDelivery vuelve a encolar el evento de un intento del historial.
+// El reenvío es una entrega nueva, con sus propios reintentos.
+func (s *WebhookService) ReplayDelivery(ctx context.Context, instanceID, id string) (*models.WebhookDelivery, error) {
+	entry, err := s.GetDelivery(ctx, instanceID, id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.lookupSubscription(ctx, instanceID, entry.WebhookID, entry.Global); err != nil {
+		return nil, errors.ErrNotFound.WithDetails("el webhook de esta entrega ya no existe")
+	}
+
+	delivery, err := s.replay(ctx, entry)
+	if err != nil {
+		return nil, errors.ErrInternalServer.Wrap(err)
+	}
+	return delivery, nil
+}
+
+// ReplayFailed vuelve a encolar todas las entregas que fallaron definitivamente en un rango de tiempo.
+// Retorna cuántas se encolaron; se omiten las de suscripciones que ya no existen.
+func (s *WebhookService) ReplayFailed(ctx context.Context, instanceID string, req *models.ReplayWebhookDeliveriesRequest) (int, error) {
+	if req.From.IsZero() {
+		return 0, errors.ErrBadRequest.WithDetails("from es requerido")
+	}
+	if req.To.IsZero() {
+		req.To = time.Now()
+	}
+	if req.To.Before(req.From) {
+		return 0, errors.ErrBadRequest.WithDetails("to debe ser posterior a from")
+	}
+
+	filter := models.WebhookDeliveryFilter{
+		InstanceID: instanceID,
+		WebhookID:  req.WebhookID,
+		Status:     models.WebhookDeliveryFailed,
+		From:       req.From,
+		To:         req.To,
+		Limit:      500,
+	}
+
+	// Juntar primero todo el rango: los reenvíos generan nuevos registros y moverían la paginación
+	var failed []models.WebhookDeliveryLog
+	for {
+		page, err := s.ListDeliveries(ctx, filter)
+		if err != nil {
+			return 0, err
+		}
+		failed = append(failed, page...)
+		if len(page) < filter.Limit {
 			break
 		}
+		filter.Offset += len(page)
+	}
+
+	active := make(map[string]bool)
+	replayed := 0
+	for i := range failed {
+		entry := &failed[i]
+		key := fmt.Sprintf("%t:%s", entry.Global, entry.WebhookID)
+		exists, checked := active[key]
+		if !checked {
+			_, err := s.lookupSubscription(ctx, instanceID, entry.WebhookID, entry.Global)
+			exists = err == nil
+			active[key] = exists
+		}
+		if !exists {
+			continue
+		}
+
+		if _, err := s.replay(ctx, entry); err != nil {
+			return replayed, errors.ErrInternalServer.Wrap(err)
+		}
+		replayed++
+	}
+
+	log.Info().Str("instance_id", instanceID).Int("replayed", replayed).Msg("Entregas de webhook fallidas reenviadas")
+	return replayed, nil
+}
+
+// replay encola una entrega nueva con el payload de un intento del historial
+func (s *WebhookService) replay(ctx context.Context, entry *models.WebhookDeliveryLog) (*models.WebhookDelivery, error) {
+	delivery := &models.WebhookDelivery{
+		ID:         uuid.New().String(),
+		InstanceID: entry.InstanceID,
+		WebhookID:  entry.WebhookID,
+		Global:     entry.Global,
+		Event:      entry.Event,
+		Payload:    entry.Payload,
+		CreatedAt:  time.Now().Unix(),
+	}
+	if err := s.webhookRepo.EnqueueDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// SendEvent encola un evento para cada suscripción de webhook que lo escuche,
+// tanto las de la instancia como las globales.
+// La entrega real la hace el dispatcher en segundo plano, con reintentos.
+func (s *WebhookService) SendEvent(ctx context.Context, instanceID string, event *models.WebhookEvent) error {
+	configs, err := s.webhookRepo.List(ctx, instanceID)
+	if err != nil {
+		// Las suscripciones globales no dependen de las de la instancia: se les entrega igual
+		log.Error().Err(err).Str("instance_id", instanceID).Msg("Error obteniendo webhooks de la instancia")
+		configs = nil
+	}
+
+	globals, err := s.globalSubscriptions(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Error obteniendo webhooks globales")
+	}
+	configs = append(configs, globals...)
+
+	var targets []*models.WebhookConfig
+	for _, config := range configs {
+		if config.Enabled && webhookListensTo(config, event.Event) && webhookFiltersMatch(config.Filters, event) {
+			targets = append(targets, config)
+		}
 	}
 
-	if !eventEnabled {
+	if len(targets) == 0 {
 		return nil
 	}
 
 	event.InstanceID = instanceID
 	event.Timestamp = time.Now().Unix()
+	if event.ID == "" {
+		// Último recurso: quien emite el evento debería pasar un ID estable (models.NewEventID)
+		event.ID = uuid.New().String()
+	}
+
+	// Se serializa como mucho dos veces: con media y sin media
+	var fullPayload, strippedPayload []byte
+	for _, config := range targets {
+		var payload []byte
+		if webhookIncludesMedia(config.Filters) {
+			if fullPayload == nil {
+				if fullPayload, err = json.Marshal(event); err != nil {
+					return fmt.Errorf("error marshaling event: %w", err)
+				}
+			}
+			payload = fullPayload
+		} else {
+			if strippedPayload == nil {
+				if strippedPayload, err = json.Marshal(withoutMedia(event)); err != nil {
+					return fmt.Errorf("error marshaling event: %w", err)
+				}
+			}
+			payload = strippedPayload
+		}
+
+		delivery := &models.WebhookDelivery{
+			ID:         uuid.New().String(),
+			InstanceID: instanceID,
+			WebhookID:  config.ID,
+			Global:     config.Global,
+			Event:      event.Event,
+			EventID:    event.ID,
+			Payload:    payload,
+			CreatedAt:  time.Now().Unix(),
+		}
+
+		if config.Batch != nil {
+			if err := s.appendToBatch(ctx, config, delivery); err != nil {
+				log.Error().Err(err).Str("instance_id", instanceID).Str("webhook_id", config.ID).Str("event", event.Event).Msg("Error añadiendo evento de webhook al lote")
+				return fmt.Errorf("error batching webhook: %w", err)
+			}
+			continue
+		}
+
+		if err := s.webhookRepo.EnqueueDelivery(ctx, delivery); err != nil {
+			log.Error().Err(err).Str("instance_id", instanceID).Str("webhook_id", config.ID).Str("event", event.Event).Msg("Error encolando evento de webhook")
+			return fmt.Errorf("error enqueuing webhook: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// webhookListensTo verifica si el evento está en la lista de eventos configurados
+func webhookListensTo(config *models.WebhookConfig, event string) bool {
+	for _, e := range config.Events {
+		if e == event || e == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// Límites del modo por lotes
+const (
+	defaultWebhookBatchSize   = 100
+	maxWebhookBatchSize       = 1000
+	defaultWebhookBatchLinger = 1000  // ms
+	maxWebhookBatchLinger     = 60000 // ms
+)
+
+// normalizeWebhookBatch completa los valores por defecto del modo por lotes y los valida.
+// Los lotes se envían siempre como un array JSON de eventos nativos.
+func normalizeWebhookBatch(config *models.WebhookConfig) error {
+	batch := config.Batch
+	if batch == nil {
+		return nil
+	}
+	if config.Format != "" && config.Format != models.WebhookFormatNative {
+		return errors.ErrBadRequest.WithDetails("batch solo está disponible con format \"native\"")
+	}
+	if batch.MaxSize == 0 {
+		batch.MaxSize = defaultWebhookBatchSize
+	}
+	if batch.MaxLingerMs == 0 {
+		batch.MaxLingerMs = defaultWebhookBatchLinger
+	}
+	if batch.MaxSize < 1 || batch.MaxSize > maxWebhookBatchSize {
+		return errors.ErrBadRequest.WithDetails(fmt.Sprintf("batch.max_size debe estar entre 1 y %d", maxWebhookBatchSize))
+	}
+	if batch.MaxLingerMs < 1 || batch.MaxLingerMs > maxWebhookBatchLinger {
+		return errors.ErrBadRequest.WithDetails(fmt.Sprintf("batch.max_linger_ms debe estar entre 1 y %d", maxWebhookBatchLinger))
+	}
+	return nil
+}
+
+// webhookChatTypes tipos de chat válidos en los filtros (ver whatsapp.GetChatType)
+var webhookChatTypes = map[string]bool{"private": true, "group": true, "channel": true, "status": true}
+
+// validateWebhookFilters verifica los valores de los filtros de una suscripción
+func validateWebhookFilters(filters *models.WebhookFilters) error {
+	if filters == nil {
+		return nil
+	}
+	for _, chatType := range filters.ChatTypes {
+		if !webhookChatTypes[chatType] {
+			return errors.ErrBadRequest.WithDetails(fmt.Sprintf("chat_type inválido: %s (private, group, channel, status)", chatType))
+		}
+	}
+	return nil
+}
+
+// isEmptyWebhookFilters indica si los filtros no restringen nada
+func isEmptyWebhookFilters(filters *models.WebhookFilters) bool {
+	return len(filters.ChatTypes) == 0 && len(filters.ChatJIDs) == 0 && len(filters.SenderJIDs) == 0 &&
+		len(filters.MessageTypes) == 0 && filters.IsFromMe == nil && filters.IncludeMedia == nil
+}
+
+// messageEventData extrae los datos de un evento "message", si lo es
+func messageEventData(event *models.WebhookEvent) (*models.MessageEvent, bool) {
+	if event.Event != "message" {
+		return nil, false
+	}
+	switch data := event.Data.(type) {
+	case models.MessageEvent:
+		return &data, true
+	case *models.MessageEvent:
+		return data, data != nil
+	}
+	return nil, false
+}
+
+// webhookFiltersMatch evalúa los filtros de una suscripción sobre un evento.
+// Solo los eventos "message" se filtran; el resto pasa si la suscripción escucha el evento.
+func webhookFiltersMatch(filters *models.WebhookFilters, event *models.WebhookEvent) bool {
+	if filters == nil {
+		return true
+	}
+	msg, ok := messageEventData(event)
+	if !ok {
+		return true
+	}
+
+	// En MessageEvent, To es el chat y From el remitente
+	if len(filters.ChatTypes) > 0 && !containsString(filters.ChatTypes, whatsapp.GetChatType(msg.To)) {
+		return false
+	}
+	if len(filters.ChatJIDs) > 0 && !matchesJID(filters.ChatJIDs, msg.To) {
+		return false
+	}
+	if len(filters.SenderJIDs) > 0 && !matchesJID(filters.SenderJIDs, msg.From) {
+		return false
+	}
+	if len(filters.MessageTypes) > 0 && !containsString(filters.MessageTypes, msg.MessageType) {
+		return false
+	}
+	if filters.IsFromMe != nil && *filters.IsFromMe != msg.IsFromMe {
+		return false
+	}
+	return true
+}
+
+// webhookIncludesMedia indica si la suscripción quiere el archivo en base64 dentro del payload
+func webhookIncludesMedia(filters *models.WebhookFilters) bool {
+	return filters == nil || filters.IncludeMedia == nil || *filters.IncludeMedia
+}
+
+// withoutMedia retorna una copia del evento sin media_data. Si había archivo, se ofrece
+// media_url para descargarlo bajo demanda.
+func withoutMedia(event *models.WebhookEvent) *models.WebhookEvent {
+	msg, ok := messageEventData(event)
+	if !ok || msg.MediaData == "" {
+		return event
+	}
+
+	stripped := *msg
+	stripped.MediaData = ""
+	if stripped.MediaURL == "" {
+		stripped.MediaURL = fmt.Sprintf("/instances/%s/messages/%s/media", event.InstanceID, msg.MessageID)
+	}
+	copied := *event
+	copied.Data = stripped
+	return &copied
+}
+
+// matchesJID compara un JID con una lista de JIDs completos o números (parte de usuario)
+func matchesJID(list []string, jid string) bool {
+	user, _, _ := strings.Cut(jid, "@")
+	for _, candidate := range list {
+		if candidate == jid || (!strings.Contains(candidate, "@") && candidate == user) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateWebhookURL verifica que la URL sea absoluta y http(s)
+func validateWebhookURL(rawURL string) error {
+	if rawURL == "" {
+		return errors.ErrBadRequest.WithDetails("url es requerida")
+	}
+	u, err := url.ParseRequestURI(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.ErrBadRequest.WithDetails("url inválida, debe ser http(s)")
+	}
+	return nil
+}
+
+func (s *WebhookService) deliveryLoop(id int) {
+	log.Debug().Int("worker_id", id).Msg("Worker de webhooks iniciado")
+
+	for {
+		select {
+		case <-s.stopChan:
+			log.Debug().Int("worker_id", id).Msg("Worker de webhooks detenido")
+			return
+		default:
+		}
+
+		ctx := context.Background()
+		delivery, raw, err := s.webhookRepo.DequeueDelivery(ctx, 2*time.Second)
+		if err != nil {
+			if err == redis.Nil {
+				continue
+			}
+			if raw != "" {
+				// Entrega corrupta: no tiene sentido reintentarla
+				log.Error().Err(err).Int("worker_id", id).Msg("Descartando entrega de webhook inválida")
+				s.webhookRepo.AckDelivery(ctx, raw)
+				continue
+			}
+			log.Error().Err(err).Int("worker_id", id).Msg("Error extrayendo entrega de webhook")
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		s.processDelivery(ctx, delivery)
+
+		if err := s.webhookRepo.AckDelivery(ctx, raw); err != nil {
+			log.Error().Err(err).Int("worker_id", id).Msg("Error haciendo ACK de entrega de webhook")
+		}
+	}
+}
+
+// retryLoop promueve periódicamente los reintentos vencidos a la cola principal
+func (s *WebhookService) retryLoop() {
+	ticker := time.NewTicker(s.policy.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			if _, err := s.webhookRepo.PromoteDueRetries(context.Background(), time.Now(), 100); err != nil {
+				log.Error().Err(err).Msg("Error promoviendo reintentos de webhook")
+			}
+		}
+	}
+}
+
+// appendToBatch añade la entrega al lote de la suscripción y lo envía si ya está lleno
+func (s *WebhookService) appendToBatch(ctx context.Context, config *models.WebhookConfig, delivery *models.WebhookDelivery) error {
+	dueAt := time.Now().Add(time.Duration(config.Batch.MaxLingerMs) * time.Millisecond)
+	n, err := s.webhookRepo.AppendBatch(ctx, delivery, dueAt)
+	if err != nil {
+		return err
+	}
+	if n >= config.Batch.MaxSize {
+		s.flushBatch(ctx, repository.BatchKey(delivery.InstanceID, delivery.WebhookID, delivery.Global))
+	}
+	return nil
+}
+
+// batchLoop envía periódicamente los lotes cuyo tiempo de espera venció
+func (s *WebhookService) batchLoop() {
+	ticker := time.NewTicker(s.policy.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			ctx := context.Background()
+			keys, err := s.webhookRepo.DueBatches(ctx, time.Now(), 100)
+			if err != nil {
+				log.Error().Err(err).Msg("Error obteniendo lotes de webhook vencidos")
+				continue
+			}
+			for _, key := range keys {
+				s.flushBatch(ctx, key)
+			}
+		}
+	}
+}
+
+// flushBatch convierte los eventos pendientes de un lote en una sola entrega.
+// Cada evento del array conserva su propio ID; la entrega (y su firma) es una sola.
+func (s *WebhookService) flushBatch(ctx context.Context, key string) {
+	instanceID, webhookID, global, err := repository.ParseBatchKey(key)
+	if err != nil {
+		log.Error().Err(err).Msg("Descartando lote de webhook inválido")
+		s.webhookRepo.TakeBatch(ctx, key, maxWebhookBatchSize)
+		return
+	}
+
+	maxSize := maxWebhookBatchSize
+	config, err := s.lookupSubscription(ctx, instanceID, webhookID, global)
+	if err == nil && config.Batch != nil {
+		maxSize = config.Batch.MaxSize
+	}
+
+	// Los eventos se sacan del lote en el mismo paso que se encola la entrega: si algo falla
+	// siguen en el lote y se reintentan en la siguiente pasada
+	items, err := s.webhookRepo.PeekBatch(ctx, key, maxSize)
+	if err != nil {
+		log.Error().Err(err).Str("instance_id", instanceID).Str("webhook_id", webhookID).Msg("Error leyendo lote de webhook")
+		return
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	events := make([]map[string]interface{}, 0, len(items))
+	for _, raw := range items {
+		var item models.WebhookDelivery
+		var event map[string]interface{}
+		err := json.Unmarshal([]byte(raw), &item)
+		if err == nil {
+			decoder := json.NewDecoder(bytes.NewReader(item.Payload))
+			decoder.UseNumber()
+			err = decoder.Decode(&event)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("instance_id", instanceID).Str("webhook_id", webhookID).Str("data", raw).Msg("Descartando evento de lote de webhook corrupto")
+			continue
+		}
+		if _, ok := event["id"]; !ok {
+			event["id"] = item.ID
+		}
+		events = append(events, event)
+	}
+
+	var delivery *models.WebhookDelivery
+	if len(events) > 0 {
+		payload, err := json.Marshal(events)
+		if err != nil {
+			log.Error().Err(err).Str("instance_id", instanceID).Str("webhook_id", webhookID).Msg("Error serializando lote de webhook")
+			return
+		}
+		delivery = &models.WebhookDelivery{
+			ID:         uuid.New().String(),
+			InstanceID: instanceID,
+			WebhookID:  webhookID,
+			Global:     global,
+			Event:      models.WebhookEventBatch,
+			Payload:    payload,
+			CreatedAt:  time.Now().Unix(),
+		}
+	}
+
+	committed, err := s.webhookRepo.CommitBatch(ctx, key, items, delivery)
+	if err != nil {
+		log.Error().Err(err).Str("instance_id", instanceID).Str("webhook_id", webhookID).Int("events", len(events)).Msg("Error encolando lote de webhook; los eventos siguen en el lote")
+		return
+	}
+	if !committed {
+		log.Debug().Str("instance_id", instanceID).Str("webhook_id", webhookID).Msg("Lote de webhook tomado por otra réplica")
+		return
+	}
+	if delivery != nil {
+		log.Debug().Str("instance_id", instanceID).Str("webhook_id", webhookID).Int("events", len(events)).Msg("Lote de webhook encolado")
+	}
+}
+
+// processDelivery intenta entregar un evento y decide si reintentar o mandarlo a dead-letter
+func (s *WebhookService) processDelivery(ctx context.Context, delivery *models.WebhookDelivery) {
+	config, err := s.lookupSubscription(ctx, delivery.InstanceID, delivery.WebhookID, delivery.Global)
+	if err != nil || !config.Enabled {
+		// El webhook se eliminó o deshabilitó mientras el evento esperaba
+		log.Debug().Str("instance_id", delivery.InstanceID).Str("delivery_id", delivery.ID).Msg("Webhook ya no está activo, descartando entrega")
+		return
+	}
+
+	if held, until := s.circuitHolds(ctx, delivery); held {
+		// Circuito abierto: la entrega espera al siguiente sondeo sin gastar intentos
+		if err := s.webhookRepo.ScheduleRetry(ctx, delivery, until); err != nil {
+			log.Error().Err(err).Str("delivery_id", delivery.ID).Msg("Error reprogramando entrega de webhook con circuito abierto")
+		}
+		return
+	}
+
+	delivery.Attempts++
+	start := time.Now()
+	status, retryable, err := s.post(ctx, config, delivery)
+	latency := time.Since(start)
+	delivery.LastStatus = status
+	s.updateCircuit(ctx, delivery, config, err)
+	if err == nil {
+		s.recordAttempt(ctx, delivery, config, models.WebhookDeliveryDelivered, latency, nil)
+		log.Debug().
+			Str("instance_id", delivery.InstanceID).
+			Str("webhook_id", delivery.WebhookID).
+			Str("delivery_id", delivery.ID).
+			Int("attempts", delivery.Attempts).
+			Msg("Webhook entregado")
+		return
+	}
+	delivery.LastError = err.Error()
+
+	if retryable && delivery.Attempts < s.policy.MaxAttempts {
+		next := time.Now().Add(s.backoff(delivery.Attempts))
+		s.recordAttempt(ctx, delivery, config, models.WebhookDeliveryRetrying, latency, err)
+		if err := s.webhookRepo.ScheduleRetry(ctx, delivery, next); err != nil {
+			log.Error().Err(err).Str("delivery_id", delivery.ID).Msg("Error programando reintento de webhook")
+		}
+		log.Warn().
+			Err(err).
+			Str("instance_id", delivery.InstanceID).
+			Str("url", config.URL).
+			Int("attempt", delivery.Attempts).
+			Time("next_attempt", next).
+			Msg("Webhook falló, reintento programado")
+		return
+	}
+
+	s.recordAttempt(ctx, delivery, config, models.WebhookDeliveryFailed, latency, err)
+	delivery.FailedAt = time.Now().Unix()
+	if err := s.webhookRepo.PushDeadLetter(ctx, delivery, s.policy.DeadLetterMax); err != nil {
+		log.Error().Err(err).Str("delivery_id", delivery.ID).Msg("Error guardando entrega en dead-letter")
+	}
+	log.Error().
+		Str("instance_id", delivery.InstanceID).
+		Str("url", config.URL).
+		Str("delivery_id", delivery.ID).
+		Int("attempts", delivery.Attempts).
+		Str("last_error", delivery.LastError).
+		Msg("Webhook movido a dead-letter")
+}
+
+// circuitHolds indica si la entrega debe esperar porque el circuito de su suscripción está abierto,
+// y hasta cuándo. Si ya toca sondear, reserva el sondeo y deja pasar esta entrega.
+func (s *WebhookService) circuitHolds(ctx context.Context, delivery *models.WebhookDelivery) (bool, time.Time) {
+	circuit, err := s.webhookRepo.GetCircuit(ctx, delivery.InstanceID, delivery.WebhookID, delivery.Global)
+	if err != nil {
+		log.Error().Err(err).Str("delivery_id", delivery.ID).Msg("Error consultando circuito de webhook")
+		return false, time.Time{}
+	}
+	if circuit.State != models.WebhookCircuitOpen {
+		return false, time.Time{}
+	}
+
+	now := time.Now()
+	next := now.Add(s.policy.ProbeInterval)
+	claimed, err := s.webhookRepo.ClaimCircuitProbe(ctx, delivery.InstanceID, delivery.WebhookID, delivery.Global, now, next)
+	if err != nil {
+		log.Error().Err(err).Str("delivery_id", delivery.ID).Msg("Error reservando sondeo de webhook")
+		return true, next
+	}
+	if claimed {
+		log.Debug().Str("instance_id", delivery.InstanceID).Str("webhook_id", delivery.WebhookID).Msg("Sondeando webhook con circuito abierto")
+		return false, time.Time{}
+	}
+	if circuit.NextProbeAt != nil && circuit.NextProbeAt.After(now) {
+		next = *circuit.NextProbeAt
+	}
+	return true, next
+}
+
+// updateCircuit registra el resultado de un intento en el circuit breaker y avisa de los cambios de estado
+func (s *WebhookService) updateCircuit(ctx context.Context, delivery *models.WebhookDelivery, config *models.WebhookConfig, deliveryErr error) {
+	if deliveryErr == nil {
+		recovered, err := s.webhookRepo.RecordCircuitSuccess(ctx, delivery.InstanceID, delivery.WebhookID, delivery.Global)
+		if err != nil {
+			log.Error().Err(err).Str("delivery_id", delivery.ID).Msg("Error cerrando circuito de webhook")
+			return
+		}
+		if recovered {
+			log.Info().
+				Str("instance_id", delivery.InstanceID).
+				Str("webhook_id", delivery.WebhookID).
+				Str("url", config.URL).
+				Msg("Webhook recuperado, se reanudan las entregas")
+			s.notifyCircuit("webhook.recovered", delivery, config, nil)
+		}
+		return
+	}
+
+	nextProbe := time.Now().Add(s.policy.ProbeInterval)
+	opened, err := s.webhookRepo.RecordCircuitFailure(ctx, delivery.InstanceID, delivery.WebhookID, delivery.Global, deliveryErr.Error(), s.policy.CircuitThreshold, nextProbe)
+	if err != nil {
+		log.Error().Err(err).Str("delivery_id", delivery.ID).Msg("Error registrando fallo en el circuito de webhook")
+		return
+	}
+	if opened {
+		log.Error().
+			Err(deliveryErr).
+			Str("instance_id", delivery.InstanceID).
+			Str("webhook_id", delivery.WebhookID).
+			Str("url", config.URL).
+			Int("threshold", s.policy.CircuitThreshold).
+			Time("next_probe", nextProbe).
+			Msg("Webhook deshabilitado temporalmente por fallos consecutivos")
+		s.notifyCircuit("webhook.disabled", delivery, config, map[string]interface{}{
+			"consecutive_failures": s.policy.CircuitThreshold,
+			"last_error":           deliveryErr.Error(),
+			"next_probe_at":        nextProbe.Unix(),
+		})
+	}
+}
+
+// notifyCircuit avisa por WebSocket de un cambio de estado del circuito.
+// Para las suscripciones globales se avisa en la instancia cuyo evento provocó el cambio.
+func (s *WebhookService) notifyCircuit(eventType string, delivery *models.WebhookDelivery, config *models.WebhookConfig, extra map[string]interface{}) {
+	if s.notifier == nil {
+		return
+	}
+
+	payload := map[string]interface{}{
+		"instance_id": delivery.InstanceID,
+		"webhook_id":  delivery.WebhookID,
+		"global":      delivery.Global,
+		"url":         config.URL,
+		"timestamp":   time.Now().Unix(),
+	}
+	for k, v := range extra {
+		payload[k] = v
+	}
+	s.notifier.BroadcastEvent(eventType, payload)
+}
 
-	payload, err := json.Marshal(event)
+// recordAttempt guarda el intento en el historial de entregas, si está habilitado
+func (s *WebhookService) recordAttempt(ctx context.Context, delivery *models.WebhookDelivery, config *models.WebhookConfig, status string, latency time.Duration, deliveryErr error) {
+	if s.logRepo == nil {
+		return
+	}
+
+	entry := &models.WebhookDeliveryLog{
+		ID:         uuid.New().String(),
+		DeliveryID: delivery.ID,
+		InstanceID: delivery.InstanceID,
+		WebhookID:  delivery.WebhookID,
+		Global:     delivery.Global,
+		Event:      delivery.Event,
+		URL:        config.URL,
+		Payload:    delivery.Payload,
+		Status:     status,
+		StatusCode: delivery.LastStatus,
+		LatencyMs:  latency.Milliseconds(),
+		Attempt:    delivery.Attempts,
+		CreatedAt:  time.Now(),
+	}
+	if deliveryErr != nil {
+		entry.Error = deliveryErr.Error()
+	}
+
+	if err := s.logRepo.Create(ctx, entry); err != nil {
+		log.Error().Err(err).Str("delivery_id", delivery.ID).Msg("Error registrando entrega de webhook en el historial")
+	}
+}
+
+// retentionLoop elimina periódicamente el historial de entregas más antiguo que la retención
+func (s *WebhookService) retentionLoop() {
+	interval := time.Hour
+	if s.policy.LogRetention < interval {
+		interval = s.policy.LogRetention
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := s.logRepo.DeleteOlderThan(context.Background(), time.Now().Add(-s.policy.LogRetention))
+		if err != nil {
+			log.Error().Err(err).Msg("Error limpiando historial de webhooks")
+		} else if deleted > 0 {
+			log.Info().Int64("deleted", deleted).Msg("Historial de webhooks depurado")
+		}
+
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// post realiza la petición HTTP al receptor.
+// Retorna el código de estado, si el fallo amerita reintento y el error (nil si fue 2xx).
+func (s *WebhookService) post(ctx context.Context, config *models.WebhookConfig, delivery *models.WebhookDelivery) (int, bool, error) {
+	rendered, err := renderWebhook(config, delivery.Payload)
 	if err != nil {
-		return fmt.Errorf("error marshaling event: %w", err)
+		// Una plantilla que no se puede ejecutar no mejorará reintentando
+		return 0, false, fmt.Errorf("error rendering webhook: %w", err)
 	}
+	payload := rendered.Body
 
 	req, err := http.NewRequestWithContext(ctx, "POST", config.URL, bytes.NewBuffer(payload))
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+		return 0, false, fmt.Errorf("error creating request: %w", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	for name, value := range rendered.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", rendered.ContentType)
 	req.Header.Set("User-Agent", "Kero-Kero-Webhook/2.0")
+	req.Header.Set("X-Webhook-ID", delivery.ID)
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	if delivery.EventID != "" {
+		req.Header.Set("X-Event-ID", delivery.EventID)
+	}
 
 	// Firmar el payload si hay secret configurado
 	if config.Secret != "" {
-		signature := s.signPayload(payload, config.Secret)
-		req.Header.Set("X-Webhook-Signature", signature)
+		now := time.Now()
+		req.Header.Set(webhooksig.Header, webhooksig.HeaderValue(now, delivery.ID, payload, activeSecrets(config, now)...))
+
+		// Firma sin timestamp, se mantiene por compatibilidad con receptores existentes
+		req.Header.Set("X-Webhook-Signature", s.signPayload(payload, config.Secret))
 	}
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		log.Error().Err(err).Str("instance_id", instanceID).Str("url", config.URL).Msg("Error enviando webhook")
-		return fmt.Errorf("error sending webhook: %w", err)
+		return 0, true, fmt.Errorf("error sending webhook: %w", err)
 	}
 	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
 
-	if resp.StatusCode >= 400 {
-		log.Warn().
-			Int("status", resp.StatusCode).
-			Str("instance_id", instanceID).
-			Str("url", config.URL).
-			Msg("Webhook respondió con error")
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, false, nil
 	}
 
-	return nil
+	// 5xx, 408 y 429 son fallos temporales del receptor; el resto de 4xx no mejorará reintentando
+	retryable := resp.StatusCode >= 500 ||
+		resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == http.StatusTooManyRequests
+	return resp.StatusCode, retryable, fmt.Errorf("webhook respondió con estado %d", resp.StatusCode)
+}
+
+// backoff calcula la espera exponencial (con jitter) antes del siguiente intento
+func (s *WebhookService) backoff(attempt int) time.Duration {
+	delay := s.policy.BackoffBase
+	for i := 1; i < attempt && delay < s.policy.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > s.policy.BackoffMax {
+		delay = s.policy.BackoffMax
+	}
+
+	// Jitter de hasta ±20% para no sincronizar reintentos de muchos eventos
+	jitter := time.Duration(mrand.Int63n(int64(delay)/5 + 1))
+	if mrand.Intn(2) == 0 {
+		return delay - jitter
+	}
+	return delay + jitter
 }
 
-// signPayload firma el payload con HMAC-SHA256
+// signPayload firma el payload con HMAC-SHA256 (firma antigua, sin timestamp ni delivery ID)
 func (s *WebhookService) signPayload(payload []byte, secret string) string {
 	h := hmac.New(sha256.New, []byte(secret))
 	h.Write(payload)
@@ -3,8 +3,11 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,6 +17,7 @@ import (
 	"kero-kero/internal/models"
 	"kero-kero/internal/repository"
 	"kero-kero/internal/testutil"
+	"kero-kero/pkg/webhooksig"
 )
 
 func TestWebhookService_SetWebhook(t *testing.T) {
@@ -39,7 +43,7 @@ func TestWebhookService_SetWebhook(t *testing.T) {
 		require.NoError(t, err)
 
 		// Verificar que se guardó
-		saved, err := service.GetWebhook(ctx, "test-instance")
+		saved, err := service.GetWebhook(ctx, "test-instance", repository.DefaultWebhookID)
 		require.NoError(t, err)
 		assert.Equal(t, config.URL, saved.URL)
 		assert.Equal(t, config.Events, saved.Events)
@@ -65,12 +69,22 @@ func TestWebhookService_SetWebhook(t *testing.T) {
 		err := service.SetWebhook(ctx, config)
 		require.NoError(t, err)
 
-		saved, err := service.GetWebhook(ctx, "test-instance-2")
+		saved, err := service.GetWebhook(ctx, "test-instance-2", repository.DefaultWebhookID)
 		require.NoError(t, err)
 		assert.Contains(t, saved.Events, "message")
 		assert.Contains(t, saved.Events, "status")
 		assert.Contains(t, saved.Events, "receipt")
 	})
+
+	t.Run("error con URL inválida", func(t *testing.T) {
+		for _, rawURL := range []string{"", "ftp://example.com/webhook", "example.com/webhook"} {
+			err := service.SetWebhook(ctx, &models.WebhookConfig{InstanceID: "test-instance-3", URL: rawURL})
+			assert.Error(t, err, rawURL)
+		}
+
+		_, err := service.GetWebhook(ctx, "test-instance-3", repository.DefaultWebhookID)
+		assert.Error(t, err)
+	})
 }
 
 func TestWebhookService_SendEvent(t *testing.T) {
@@ -81,16 +95,17 @@ func TestWebhookService_SendEvent(t *testing.T) {
 	redisRepo := &repository.RedisClient{Client: redisClient}
 	webhookRepo := repository.NewWebhookRepository(redisRepo)
 	service := NewWebhookService(webhookRepo)
+	service.Start()
+	defer service.Stop()
 
 	ctx := context.Background()
 
 	t.Run("enviar evento exitosamente", func(t *testing.T) {
 		// Crear servidor HTTP mock
-		received := false
+		var received atomic.Bool
 		var receivedEvent models.WebhookEvent
 
 		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			received = true
 			assert.Equal(t, "POST", r.Method)
 			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
 			assert.NotEmpty(t, r.Header.Get("X-Webhook-Signature"))
@@ -99,6 +114,7 @@ func TestWebhookService_SendEvent(t *testing.T) {
 			require.NoError(t, err)
 
 			w.WriteHeader(http.StatusOK)
+			received.Store(true)
 		}))
 		defer server.Close()
 
@@ -127,10 +143,8 @@ func TestWebhookService_SendEvent(t *testing.T) {
 		err = service.SendEvent(ctx, "test-instance", event)
 		require.NoError(t, err)
 
-		// Esperar un poco para que se procese
-		time.Sleep(100 * time.Millisecond)
-
-		assert.True(t, received)
+		// La entrega la hace el dispatcher en segundo plano
+		require.Eventually(t, received.Load, 3*time.Second, 20*time.Millisecond)
 		assert.Equal(t, "message", receivedEvent.Event)
 		assert.Equal(t, "test-instance", receivedEvent.InstanceID)
 	})
@@ -216,10 +230,1006 @@ func TestWebhookService_DeleteWebhook(t *testing.T) {
 	require.NoError(t, err)
 
 	// Eliminar
-	err = service.DeleteWebhook(ctx, "test-instance")
+	err = service.DeleteWebhook(ctx, "test-instance", config.ID)
 	require.NoError(t, err)
 
 	// Verificar que no existe
-	_, err = service.GetWebhook(ctx, "test-instance")
+	_, err = service.GetWebhook(ctx, "test-instance", config.ID)
 	assert.Error(t, err)
+
+	// Eliminar de nuevo debe reportar que no existe
+	err = service.DeleteWebhook(ctx, "test-instance", config.ID)
+	assert.Error(t, err)
+}
+
+func TestWebhookService_Subscriptions(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	redisRepo := &repository.RedisClient{Client: redisClient}
+	webhookRepo := repository.NewWebhookRepository(redisRepo)
+	service := NewWebhookService(webhookRepo)
+	service.Start()
+	defer service.Stop()
+
+	ctx := context.Background()
+
+	newReceiver := func(counter *atomic.Int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			counter.Add(1)
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+
+	t.Run("crear varias suscripciones por instancia", func(t *testing.T) {
+		crm := &models.WebhookConfig{InstanceID: "multi", URL: "https://crm.example.com/hook", Events: []string{"message"}}
+		analytics := &models.WebhookConfig{InstanceID: "multi", URL: "https://analytics.example.com/hook", Events: []string{"receipt"}}
+
+		require.NoError(t, service.CreateWebhook(ctx, crm))
+		require.NoError(t, service.CreateWebhook(ctx, analytics))
+		assert.NotEmpty(t, crm.ID)
+		assert.NotEqual(t, crm.ID, analytics.ID)
+
+		configs, err := service.ListWebhooks(ctx, "multi")
+		require.NoError(t, err)
+		require.Len(t, configs, 2)
+		assert.Equal(t, crm.ID, configs[0].ID)
+		assert.Equal(t, analytics.ID, configs[1].ID)
+	})
+
+	t.Run("rechazar URL inválida", func(t *testing.T) {
+		err := service.CreateWebhook(ctx, &models.WebhookConfig{InstanceID: "multi", URL: "ftp://example.com"})
+		assert.Error(t, err)
+	})
+
+	t.Run("actualizar una suscripción", func(t *testing.T) {
+		config := &models.WebhookConfig{InstanceID: "update", URL: "https://example.com/a"}
+		require.NoError(t, service.CreateWebhook(ctx, config))
+
+		disabled := false
+		updated, err := service.UpdateWebhook(ctx, "update", config.ID, &models.UpdateWebhookRequest{
+			Events:  []string{"call"},
+			Enabled: &disabled,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"call"}, updated.Events)
+		assert.False(t, updated.Enabled)
+		assert.Equal(t, "https://example.com/a", updated.URL)
+
+		_, err = service.UpdateWebhook(ctx, "update", "no-existe", &models.UpdateWebhookRequest{})
+		assert.Error(t, err)
+	})
+
+	t.Run("entregar a cada suscripción que escucha el evento", func(t *testing.T) {
+		var crmCalls, ticketsCalls, analyticsCalls atomic.Int32
+		crmServer := newReceiver(&crmCalls)
+		defer crmServer.Close()
+		ticketsServer := newReceiver(&ticketsCalls)
+		defer ticketsServer.Close()
+		analyticsServer := newReceiver(&analyticsCalls)
+		defer analyticsServer.Close()
+
+		require.NoError(t, service.CreateWebhook(ctx, &models.WebhookConfig{InstanceID: "fanout", URL: crmServer.URL, Events: []string{"message"}}))
+		require.NoError(t, service.CreateWebhook(ctx, &models.WebhookConfig{InstanceID: "fanout", URL: ticketsServer.URL, Events: []string{"all"}}))
+		require.NoError(t, service.CreateWebhook(ctx, &models.WebhookConfig{InstanceID: "fanout", URL: analyticsServer.URL, Events: []string{"receipt"}}))
+
+		err := service.SendEvent(ctx, "fanout", &models.WebhookEvent{Event: "message"})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			return crmCalls.Load() == 1 && ticketsCalls.Load() == 1
+		}, 3*time.Second, 10*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, int32(0), analyticsCalls.Load())
+	})
+
+	t.Run("migrar configuración antigua de un solo webhook", func(t *testing.T) {
+		legacy := `{"instance_id":"legacy","url":"https://legacy.example.com","events":["message"],"enabled":true}`
+		require.NoError(t, redisClient.Set(ctx, "webhook:legacy", legacy, 0).Err())
+
+		configs, err := service.ListWebhooks(ctx, "legacy")
+		require.NoError(t, err)
+		require.Len(t, configs, 1)
+		assert.Equal(t, repository.DefaultWebhookID, configs[0].ID)
+		assert.Equal(t, "https://legacy.example.com", configs[0].URL)
+		assert.False(t, mr.Exists("webhook:legacy"))
+	})
+}
+
+func TestWebhookService_DeliveryRetries(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	redisRepo := &repository.RedisClient{Client: redisClient}
+	webhookRepo := repository.NewWebhookRepository(redisRepo)
+	service := NewWebhookService(webhookRepo)
+	service.SetDeliveryPolicy(WebhookDeliveryPolicy{
+		Workers:      1,
+		MaxAttempts:  3,
+		BackoffBase:  10 * time.Millisecond,
+		BackoffMax:   50 * time.Millisecond,
+		PollInterval: 10 * time.Millisecond,
+	})
+	service.Start()
+	defer service.Stop()
+
+	ctx := context.Background()
+
+	t.Run("reintentar hasta que el receptor responda 2xx", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		err := webhookRepo.Set(ctx, &models.WebhookConfig{
+			InstanceID: "retry-instance",
+			URL:        server.URL,
+			Events:     []string{"message"},
+			Enabled:    true,
+		})
+		require.NoError(t, err)
+
+		err = service.SendEvent(ctx, "retry-instance", &models.WebhookEvent{Event: "message"})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool { return calls.Load() == 3 }, 3*time.Second, 10*time.Millisecond)
+
+		dead, err := service.ListDeadLetters(ctx, "retry-instance", 10)
+		require.NoError(t, err)
+		assert.Empty(t, dead)
+	})
+
+	t.Run("mover a dead-letter al agotar los intentos", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		err := webhookRepo.Set(ctx, &models.WebhookConfig{
+			InstanceID: "dead-instance",
+			URL:        server.URL,
+			Events:     []string{"all"},
+			Enabled:    true,
+		})
+		require.NoError(t, err)
+
+		err = service.SendEvent(ctx, "dead-instance", &models.WebhookEvent{Event: "status"})
+		require.NoError(t, err)
+
+		var dead []models.WebhookDelivery
+		require.Eventually(t, func() bool {
+			dead, _ = service.ListDeadLetters(ctx, "dead-instance", 10)
+			return len(dead) == 1
+		}, 3*time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, int32(3), calls.Load())
+		assert.Equal(t, 3, dead[0].Attempts)
+		assert.Equal(t, http.StatusInternalServerError, dead[0].LastStatus)
+		assert.Equal(t, "status", dead[0].Event)
+	})
+
+	t.Run("no reintentar errores 4xx permanentes", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		err := webhookRepo.Set(ctx, &models.WebhookConfig{
+			InstanceID: "bad-request-instance",
+			URL:        server.URL,
+			Events:     []string{"all"},
+			Enabled:    true,
+		})
+		require.NoError(t, err)
+
+		err = service.SendEvent(ctx, "bad-request-instance", &models.WebhookEvent{Event: "status"})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			dead, _ := service.ListDeadLetters(ctx, "bad-request-instance", 10)
+			return len(dead) == 1
+		}, 3*time.Second, 10*time.Millisecond)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+}
+
+func TestWebhookService_Backoff(t *testing.T) {
+	service := NewWebhookService(nil)
+	service.SetDeliveryPolicy(WebhookDeliveryPolicy{
+		BackoffBase: time.Second,
+		BackoffMax:  8 * time.Second,
+	})
+
+	within := func(d, expected time.Duration) bool {
+		return d >= expected*8/10 && d <= expected*12/10
+	}
+
+	assert.True(t, within(service.backoff(1), time.Second))
+	assert.True(t, within(service.backoff(2), 2*time.Second))
+	assert.True(t, within(service.backoff(3), 4*time.Second))
+	assert.True(t, within(service.backoff(10), 8*time.Second), "el backoff debe respetar el máximo")
+}
+
+func TestWebhookService_DeliveryLog(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+	db := testutil.NewMockDatabase(t)
+	defer testutil.CleanupDatabase(t, db)
+
+	redisRepo := &repository.RedisClient{Client: redisClient}
+	webhookRepo := repository.NewWebhookRepository(redisRepo)
+	service := NewWebhookService(webhookRepo)
+	service.SetDeliveryPolicy(WebhookDeliveryPolicy{
+		Workers:      1,
+		MaxAttempts:  2,
+		BackoffBase:  10 * time.Millisecond,
+		BackoffMax:   20 * time.Millisecond,
+		PollInterval: 10 * time.Millisecond,
+	})
+	service.SetDeliveryLog(repository.NewWebhookLogRepository(db))
+	service.Start()
+	defer service.Stop()
+
+	ctx := context.Background()
+
+	// El receptor falla hasta que se "recupera" de la caída
+	var healthy atomic.Bool
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		received.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := &models.WebhookConfig{InstanceID: "log-instance", URL: server.URL, Events: []string{"all"}}
+	require.NoError(t, service.CreateWebhook(ctx, config))
+
+	start := time.Now().Add(-time.Second)
+	require.NoError(t, service.SendEvent(ctx, "log-instance", &models.WebhookEvent{Event: "message"}))
+	require.NoError(t, service.SendEvent(ctx, "log-instance", &models.WebhookEvent{Event: "receipt"}))
+
+	var failed []models.WebhookDeliveryLog
+	require.Eventually(t, func() bool {
+		failed, _ = service.ListDeliveries(ctx, models.WebhookDeliveryFilter{
+			InstanceID: "log-instance",
+			Status:     models.WebhookDeliveryFailed,
+		})
+		return len(failed) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+
+	t.Run("registrar cada intento con estado, código y payload", func(t *testing.T) {
+		all, err := service.ListDeliveries(ctx, models.WebhookDeliveryFilter{InstanceID: "log-instance"})
+		require.NoError(t, err)
+		assert.Len(t, all, 4) // 2 eventos x 2 intentos
+
+		retrying, err := service.ListDeliveries(ctx, models.WebhookDeliveryFilter{
+			InstanceID: "log-instance",
+			Status:     models.WebhookDeliveryRetrying,
+			Event:      "message",
+		})
+		require.NoError(t, err)
+		require.Len(t, retrying, 1)
+		assert.Equal(t, http.StatusBadGateway, retrying[0].StatusCode)
+		assert.Equal(t, 1, retrying[0].Attempt)
+		assert.Equal(t, config.ID, retrying[0].WebhookID)
+		assert.Equal(t, server.URL, retrying[0].URL)
+		assert.NotEmpty(t, retrying[0].Error)
+
+		var event models.WebhookEvent
+		require.NoError(t, json.Unmarshal(retrying[0].Payload, &event))
+		assert.Equal(t, "log-instance", event.InstanceID)
+
+		entry, err := service.GetDelivery(ctx, "log-instance", retrying[0].ID)
+		require.NoError(t, err)
+		assert.Equal(t, retrying[0].DeliveryID, entry.DeliveryID)
+
+		_, err = service.GetDelivery(ctx, "otra-instancia", retrying[0].ID)
+		assert.Error(t, err)
+	})
+
+	t.Run("reenviar una entrega", func(t *testing.T) {
+		healthy.Store(true)
+
+		delivery, err := service.ReplayDelivery(ctx, "log-instance", failed[0].ID)
+		require.NoError(t, err)
+		assert.NotEqual(t, failed[0].DeliveryID, delivery.ID)
+
+		require.Eventually(t, func() bool { return received.Load() == 1 }, 3*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("reenviar las fallidas de un rango", func(t *testing.T) {
+		received.Store(0)
+
+		_, err := service.ReplayFailed(ctx, "log-instance", &models.ReplayWebhookDeliveriesRequest{})
+		assert.Error(t, err, "from es requerido")
+
+		replayed, err := service.ReplayFailed(ctx, "log-instance", &models.ReplayWebhookDeliveriesRequest{
+			From: time.Now().Add(time.Hour),
+			To:   time.Now().Add(2 * time.Hour),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 0, replayed)
+
+		replayed, err = service.ReplayFailed(ctx, "log-instance", &models.ReplayWebhookDeliveriesRequest{From: start})
+		require.NoError(t, err)
+		assert.Equal(t, 2, replayed)
+
+		require.Eventually(t, func() bool {
+			delivered, _ := service.ListDeliveries(ctx, models.WebhookDeliveryFilter{
+				InstanceID: "log-instance",
+				Status:     models.WebhookDeliveryDelivered,
+			})
+			return len(delivered) == 3
+		}, 3*time.Second, 10*time.Millisecond)
+		assert.Equal(t, int32(2), received.Load())
+	})
+}
+
+func TestWebhookService_SignatureRotation(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	redisRepo := &repository.RedisClient{Client: redisClient}
+	webhookRepo := repository.NewWebhookRepository(redisRepo)
+	service := NewWebhookService(webhookRepo)
+	service.Start()
+	defer service.Stop()
+
+	ctx := context.Background()
+
+	type received struct {
+		body   []byte
+		header string
+		id     string
+	}
+	requests := make(chan received, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{body: body, header: r.Header.Get(webhooksig.Header), id: r.Header.Get("X-Webhook-ID")}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := &models.WebhookConfig{InstanceID: "sig-instance", URL: server.URL, Events: []string{"all"}, Secret: "viejo"}
+	require.NoError(t, service.CreateWebhook(ctx, config))
+
+	rotated, err := service.RotateWebhookSecret(ctx, "sig-instance", config.ID, &models.RotateWebhookSecretRequest{})
+	require.NoError(t, err)
+	assert.Len(t, rotated.Secret, 64)
+	assert.Equal(t, "viejo", rotated.PreviousSecret)
+	require.NotNil(t, rotated.PreviousSecretExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), *rotated.PreviousSecretExpiresAt, time.Minute)
+
+	require.NoError(t, service.SendEvent(ctx, "sig-instance", &models.WebhookEvent{Event: "message"}))
+
+	var req received
+	select {
+	case req = <-requests:
+	case <-time.After(3 * time.Second):
+		t.Fatal("el webhook no llegó")
+	}
+
+	// El receptor puede verificar con cualquiera de los dos secretos durante la rotación
+	sig, err := webhooksig.Verify(req.body, req.header, webhooksig.DefaultTolerance, "viejo")
+	require.NoError(t, err)
+	assert.Equal(t, req.id, sig.DeliveryID)
+	_, err = webhooksig.Verify(req.body, req.header, webhooksig.DefaultTolerance, rotated.Secret)
+	require.NoError(t, err)
+
+	t.Run("el secreto anterior deja de firmar al vencer", func(t *testing.T) {
+		expired := time.Now().Add(-time.Second)
+		rotated.PreviousSecretExpiresAt = &expired
+		assert.Equal(t, []string{rotated.Secret}, activeSecrets(rotated, time.Now()))
+	})
+
+	t.Run("cambiar el secreto directamente termina la rotación", func(t *testing.T) {
+		secret := "manual"
+		updated, err := service.UpdateWebhook(ctx, "sig-instance", config.ID, &models.UpdateWebhookRequest{Secret: &secret})
+		require.NoError(t, err)
+		assert.Empty(t, updated.PreviousSecret)
+		assert.Nil(t, updated.PreviousSecretExpiresAt)
+	})
+
+	t.Run("rechazar el mismo secreto", func(t *testing.T) {
+		_, err := service.RotateWebhookSecret(ctx, "sig-instance", config.ID, &models.RotateWebhookSecretRequest{Secret: "manual"})
+		assert.Error(t, err)
+	})
+}
+
+func TestWebhookService_GlobalWebhooks(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	redisRepo := &repository.RedisClient{Client: redisClient}
+	webhookRepo := repository.NewWebhookRepository(redisRepo)
+	service := NewWebhookService(webhookRepo)
+	service.Start()
+	defer service.Stop()
+
+	ctx := context.Background()
+
+	bodies := make(chan []byte, 8)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	global := &models.WebhookConfig{URL: server.URL, Events: []string{"message"}}
+	require.NoError(t, service.CreateGlobalWebhook(ctx, global))
+	assert.True(t, global.Global)
+
+	t.Run("recibir eventos de instancias sin webhook propio", func(t *testing.T) {
+		for _, instanceID := range []string{"nueva-1", "nueva-2"} {
+			require.NoError(t, service.SendEvent(ctx, instanceID, &models.WebhookEvent{Event: "message"}))
+		}
+
+		seen := map[string]bool{}
+		for i := 0; i < 2; i++ {
+			select {
+			case body := <-bodies:
+				var event models.WebhookEvent
+				require.NoError(t, json.Unmarshal(body, &event))
+				seen[event.InstanceID] = true
+			case <-time.After(3 * time.Second):
+				t.Fatal("el webhook global no llegó")
+			}
+		}
+		assert.True(t, seen["nueva-1"])
+		assert.True(t, seen["nueva-2"])
+	})
+
+	t.Run("convivir con el webhook de la instancia", func(t *testing.T) {
+		var calls atomic.Int32
+		own := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer own.Close()
+		require.NoError(t, service.CreateWebhook(ctx, &models.WebhookConfig{InstanceID: "propia", URL: own.URL, Events: []string{"message"}}))
+
+		require.NoError(t, service.SendEvent(ctx, "propia", &models.WebhookEvent{Event: "message"}))
+
+		select {
+		case <-bodies:
+		case <-time.After(3 * time.Second):
+			t.Fatal("el webhook global no llegó")
+		}
+		require.Eventually(t, func() bool { return calls.Load() == 1 }, 3*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("llegar aunque fallen los webhooks de la instancia", func(t *testing.T) {
+		mr.Set("webhooks:rota", "no es un hash") // List falla con WRONGTYPE
+
+		require.NoError(t, service.SendEvent(ctx, "rota", &models.WebhookEvent{Event: "message"}))
+
+		select {
+		case body := <-bodies:
+			var event models.WebhookEvent
+			require.NoError(t, json.Unmarshal(body, &event))
+			assert.Equal(t, "rota", event.InstanceID)
+		case <-time.After(3 * time.Second):
+			t.Fatal("el webhook global no llegó")
+		}
+	})
+
+	t.Run("respetar el filtro de eventos y el estado", func(t *testing.T) {
+		require.NoError(t, service.SendEvent(ctx, "nueva-1", &models.WebhookEvent{Event: "receipt"}))
+
+		disabled := false
+		_, err := service.UpdateGlobalWebhook(ctx, global.ID, &models.UpdateWebhookRequest{Enabled: &disabled})
+		require.NoError(t, err)
+		require.NoError(t, service.SendEvent(ctx, "nueva-1", &models.WebhookEvent{Event: "message"}))
+
+		select {
+		case <-bodies:
+			t.Fatal("no debía entregarse")
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+
+	t.Run("webhook global de entorno", func(t *testing.T) {
+		assert.Error(t, service.SetEnvGlobalWebhook("ftp://invalido", nil, ""))
+		require.NoError(t, service.SetEnvGlobalWebhook(server.URL, []string{" all ", ""}, "secreto"))
+
+		configs, err := service.ListGlobalWebhooks(ctx)
+		require.NoError(t, err)
+		require.Len(t, configs, 2)
+		assert.Equal(t, EnvGlobalWebhookID, configs[0].ID)
+		assert.Equal(t, []string{"all"}, configs[0].Events)
+
+		assert.Error(t, service.DeleteGlobalWebhook(ctx, EnvGlobalWebhookID))
+		_, err = service.UpdateGlobalWebhook(ctx, EnvGlobalWebhookID, &models.UpdateWebhookRequest{})
+		assert.Error(t, err)
+
+		require.NoError(t, service.SendEvent(ctx, "nueva-3", &models.WebhookEvent{Event: "status"}))
+		select {
+		case body := <-bodies:
+			assert.Contains(t, string(body), `"instance_id":"nueva-3"`)
+		case <-time.After(3 * time.Second):
+			t.Fatal("el webhook global de entorno no llegó")
+		}
+	})
+
+	t.Run("eliminar suscripción global", func(t *testing.T) {
+		require.NoError(t, service.DeleteGlobalWebhook(ctx, global.ID))
+		_, err := service.GetGlobalWebhook(ctx, global.ID)
+		assert.Error(t, err)
+		assert.Error(t, service.DeleteGlobalWebhook(ctx, global.ID))
+	})
+}
+
+// fakeNotifier guarda los avisos enviados por el servicio de webhooks
+type fakeNotifier struct {
+	mu       sync.Mutex
+	events   []string
+	payloads []interface{}
+}
+
+func (n *fakeNotifier) BroadcastEvent(eventType string, payload interface{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, eventType)
+	n.payloads = append(n.payloads, payload)
+}
+
+func (n *fakeNotifier) received() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.events...)
+}
+
+func TestWebhookService_CircuitBreaker(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	redisRepo := &repository.RedisClient{Client: redisClient}
+	webhookRepo := repository.NewWebhookRepository(redisRepo)
+	service := NewWebhookService(webhookRepo)
+	service.SetDeliveryPolicy(WebhookDeliveryPolicy{
+		Workers:          1,
+		MaxAttempts:      20,
+		BackoffBase:      10 * time.Millisecond,
+		BackoffMax:       20 * time.Millisecond,
+		PollInterval:     10 * time.Millisecond,
+		CircuitThreshold: 3,
+		ProbeInterval:    300 * time.Millisecond,
+	})
+	notifier := &fakeNotifier{}
+	service.SetEventNotifier(notifier)
+	service.Start()
+	defer service.Stop()
+
+	ctx := context.Background()
+
+	var down atomic.Bool
+	var calls atomic.Int32
+	down.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := &models.WebhookConfig{InstanceID: "circuit", URL: server.URL, Events: []string{"message"}}
+	require.NoError(t, service.CreateWebhook(ctx, config))
+	require.NoError(t, service.SendEvent(ctx, "circuit", &models.WebhookEvent{Event: "message"}))
+
+	t.Run("abrir el circuito tras fallos consecutivos", func(t *testing.T) {
+		require.Eventually(t, func() bool {
+			saved, err := service.GetWebhook(ctx, "circuit", config.ID)
+			return err == nil && saved.Circuit.State == models.WebhookCircuitOpen
+		}, 3*time.Second, 10*time.Millisecond)
+		require.Eventually(t, func() bool { return len(notifier.received()) == 1 }, time.Second, 10*time.Millisecond)
+		assert.Equal(t, []string{"webhook.disabled"}, notifier.received())
+
+		// Mientras está abierto no se envía nada hasta el siguiente sondeo
+		before := calls.Load()
+		time.Sleep(150 * time.Millisecond)
+		assert.Equal(t, before, calls.Load())
+
+		configs, err := service.ListWebhooks(ctx, "circuit")
+		require.NoError(t, err)
+		require.Len(t, configs, 1)
+		require.NotNil(t, configs[0].Circuit)
+		assert.Equal(t, models.WebhookCircuitOpen, configs[0].Circuit.State)
+		assert.GreaterOrEqual(t, configs[0].Circuit.ConsecutiveFailures, 3)
+		assert.NotNil(t, configs[0].Circuit.NextProbeAt)
+	})
+
+	t.Run("no guardar el estado del circuito con la suscripción", func(t *testing.T) {
+		raw, err := redisClient.HGet(ctx, "webhooks:circuit", config.ID).Result()
+		require.NoError(t, err)
+		assert.NotContains(t, raw, `"circuit":`)
+	})
+
+	t.Run("cerrar el circuito cuando el sondeo tiene éxito", func(t *testing.T) {
+		down.Store(false)
+		require.Eventually(t, func() bool {
+			saved, err := service.GetWebhook(ctx, "circuit", config.ID)
+			return err == nil && saved.Circuit.State == models.WebhookCircuitClosed
+		}, 3*time.Second, 10*time.Millisecond)
+		require.Eventually(t, func() bool { return len(notifier.received()) == 2 }, time.Second, 10*time.Millisecond)
+		assert.Equal(t, []string{"webhook.disabled", "webhook.recovered"}, notifier.received())
+
+		saved, err := service.GetWebhook(ctx, "circuit", config.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 0, saved.Circuit.ConsecutiveFailures)
+	})
+
+	t.Run("cambiar la URL reinicia el circuito", func(t *testing.T) {
+		_, err := webhookRepo.RecordCircuitFailure(ctx, "circuit", config.ID, false, "boom", 1, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+
+		url := server.URL + "/nuevo"
+		updated, err := service.UpdateWebhook(ctx, "circuit", config.ID, &models.UpdateWebhookRequest{URL: &url})
+		require.NoError(t, err)
+		assert.Equal(t, models.WebhookCircuitClosed, updated.Circuit.State)
+	})
+}
+
+func TestWebhookFiltersMatch(t *testing.T) {
+	yes, no := true, false
+	privateText := &models.WebhookEvent{Event: "message", Data: models.MessageEvent{
+		From: "5215511111111@s.whatsapp.net", To: "5215511111111@s.whatsapp.net", MessageType: "text",
+	}}
+	groupImage := &models.WebhookEvent{Event: "message", Data: models.MessageEvent{
+		From: "5215522222222@s.whatsapp.net", To: "120363000000000000@g.us", MessageType: "image", IsFromMe: true,
+	}}
+
+	tests := []struct {
+		name    string
+		filters *models.WebhookFilters
+		event   *models.WebhookEvent
+		want    bool
+	}{
+		{"sin filtros", nil, groupImage, true},
+		{"tipo de chat privado", &models.WebhookFilters{ChatTypes: []string{"private"}}, privateText, true},
+		{"tipo de chat excluido", &models.WebhookFilters{ChatTypes: []string{"private"}}, groupImage, false},
+		{"chat por JID completo", &models.WebhookFilters{ChatJIDs: []string{"120363000000000000@g.us"}}, groupImage, true},
+		{"remitente por número", &models.WebhookFilters{SenderJIDs: []string{"5215522222222"}}, groupImage, true},
+		{"remitente distinto", &models.WebhookFilters{SenderJIDs: []string{"5215599999999"}}, groupImage, false},
+		{"tipo de mensaje", &models.WebhookFilters{MessageTypes: []string{"text"}}, groupImage, false},
+		{"solo recibidos", &models.WebhookFilters{IsFromMe: &no}, groupImage, false},
+		{"solo enviados", &models.WebhookFilters{IsFromMe: &yes}, groupImage, true},
+		{"otros eventos no se filtran", &models.WebhookFilters{ChatTypes: []string{"group"}}, &models.WebhookEvent{Event: "status"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, webhookFiltersMatch(tt.filters, tt.event))
+		})
+	}
+}
+
+func TestWebhookService_Filters(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	redisRepo := &repository.RedisClient{Client: redisClient}
+	webhookRepo := repository.NewWebhookRepository(redisRepo)
+	service := NewWebhookService(webhookRepo)
+	service.Start()
+	defer service.Stop()
+
+	ctx := context.Background()
+
+	newReceiver := func(bodies chan<- []byte) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			bodies <- body
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+	botBodies := make(chan []byte, 4)
+	bot := newReceiver(botBodies)
+	defer bot.Close()
+	analyticsBodies := make(chan []byte, 4)
+	analytics := newReceiver(analyticsBodies)
+	defer analytics.Close()
+
+	t.Run("rechazar tipos de chat desconocidos", func(t *testing.T) {
+		err := service.CreateWebhook(ctx, &models.WebhookConfig{
+			InstanceID: "filters",
+			URL:        bot.URL,
+			Filters:    &models.WebhookFilters{ChatTypes: []string{"grupo"}},
+		})
+		assert.Error(t, err)
+	})
+
+	noMedia := false
+	require.NoError(t, service.CreateWebhook(ctx, &models.WebhookConfig{
+		InstanceID: "filters",
+		URL:        bot.URL,
+		Events:     []string{"message"},
+		Filters:    &models.WebhookFilters{ChatTypes: []string{"private"}, MessageTypes: []string{"text", "image"}, IncludeMedia: &noMedia},
+	}))
+	require.NoError(t, service.CreateWebhook(ctx, &models.WebhookConfig{
+		InstanceID: "filters",
+		URL:        analytics.URL,
+		Events:     []string{"message"},
+		Filters:    &models.WebhookFilters{ChatTypes: []string{"group"}},
+	}))
+
+	send := func(chat, messageType string) {
+		require.NoError(t, service.SendEvent(ctx, "filters", &models.WebhookEvent{Event: "message", Data: models.MessageEvent{
+			MessageID:   "msg-" + messageType,
+			From:        "5215511111111@s.whatsapp.net",
+			To:          chat,
+			MessageType: messageType,
+			MediaData:   "aGVsbG8=",
+		}}))
+	}
+	receive := func(bodies <-chan []byte) models.MessageEvent {
+		var event struct {
+			Data models.MessageEvent `json:"data"`
+		}
+		select {
+		case body := <-bodies:
+			require.NoError(t, json.Unmarshal(body, &event))
+		case <-time.After(3 * time.Second):
+			t.Fatal("el webhook no llegó")
+		}
+		return event.Data
+	}
+
+	send("5215511111111@s.whatsapp.net", "image")
+	send("120363000000000000@g.us", "image")
+
+	t.Run("entregar solo a la suscripción cuyo filtro coincide", func(t *testing.T) {
+		bot := receive(botBodies)
+		assert.Equal(t, "5215511111111@s.whatsapp.net", bot.To)
+		group := receive(analyticsBodies)
+		assert.Equal(t, "120363000000000000@g.us", group.To)
+
+		select {
+		case <-botBodies:
+			t.Fatal("el bot no debía recibir mensajes de grupo")
+		case <-analyticsBodies:
+			t.Fatal("analítica no debía recibir mensajes privados")
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+
+	t.Run("omitir media si la suscripción no la quiere", func(t *testing.T) {
+		send("5215511111111@s.whatsapp.net", "image")
+		event := receive(botBodies)
+		assert.Empty(t, event.MediaData)
+		assert.Equal(t, "/instances/filters/messages/msg-image/media", event.MediaURL)
+
+		send("120363000000000000@g.us", "image")
+		assert.Equal(t, "aGVsbG8=", receive(analyticsBodies).MediaData)
+	})
+
+	t.Run("quitar los filtros con un objeto vacío", func(t *testing.T) {
+		configs, err := service.ListWebhooks(ctx, "filters")
+		require.NoError(t, err)
+		updated, err := service.UpdateWebhook(ctx, "filters", configs[0].ID, &models.UpdateWebhookRequest{Filters: &models.WebhookFilters{}})
+		require.NoError(t, err)
+		assert.Nil(t, updated.Filters)
+	})
+}
+
+func TestWebhookService_Batching(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	redisRepo := &repository.RedisClient{Client: redisClient}
+	webhookRepo := repository.NewWebhookRepository(redisRepo)
+	service := NewWebhookService(webhookRepo)
+	service.SetDeliveryPolicy(WebhookDeliveryPolicy{PollInterval: 10 * time.Millisecond})
+	service.Start()
+	defer service.Stop()
+
+	ctx := context.Background()
+
+	type received struct {
+		body   []byte
+		header string
+		event  string
+	}
+	requests := make(chan received, 8)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{body: body, header: r.Header.Get(webhooksig.Header), event: r.Header.Get("X-Webhook-Event")}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	receive := func() received {
+		select {
+		case req := <-requests:
+			return req
+		case <-time.After(3 * time.Second):
+			t.Fatal("el lote no llegó")
+		}
+		return received{}
+	}
+
+	t.Run("validar la configuración del lote", func(t *testing.T) {
+		err := service.CreateWebhook(ctx, &models.WebhookConfig{InstanceID: "batch", URL: server.URL, Batch: &models.WebhookBatch{MaxSize: 5000}})
+		assert.Error(t, err)
+		err = service.CreateWebhook(ctx, &models.WebhookConfig{InstanceID: "batch", URL: server.URL, Format: models.WebhookFormatSlack, Batch: &models.WebhookBatch{}})
+		assert.Error(t, err)
+	})
+
+	config := &models.WebhookConfig{
+		InstanceID: "batch",
+		URL:        server.URL,
+		Events:     []string{"message"},
+		Secret:     "secreto",
+		Batch:      &models.WebhookBatch{MaxSize: 3, MaxLingerMs: 100},
+	}
+	require.NoError(t, service.CreateWebhook(ctx, config))
+
+	t.Run("enviar al llenar el lote", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			require.NoError(t, service.SendEvent(ctx, "batch", &models.WebhookEvent{Event: "message"}))
+		}
+
+		req := receive()
+		assert.Equal(t, models.WebhookEventBatch, req.event)
+
+		var events []map[string]interface{}
+		require.NoError(t, json.Unmarshal(req.body, &events))
+		require.Len(t, events, 3)
+		ids := map[interface{}]bool{}
+		for _, event := range events {
+			assert.Equal(t, "message", event["event"])
+			assert.NotEmpty(t, event["id"])
+			ids[event["id"]] = true
+		}
+		assert.Len(t, ids, 3)
+
+		// El lote completo se firma una sola vez
+		_, err := webhooksig.Verify(req.body, req.header, webhooksig.DefaultTolerance, "secreto")
+		assert.NoError(t, err)
+	})
+
+	t.Run("enviar al vencer el tiempo de espera", func(t *testing.T) {
+		start := time.Now()
+		require.NoError(t, service.SendEvent(ctx, "batch", &models.WebhookEvent{Event: "message"}))
+
+		req := receive()
+		assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+		var events []map[string]interface{}
+		require.NoError(t, json.Unmarshal(req.body, &events))
+		assert.Len(t, events, 1)
+	})
+
+	t.Run("desactivar el modo por lotes", func(t *testing.T) {
+		updated, err := service.UpdateWebhook(ctx, "batch", config.ID, &models.UpdateWebhookRequest{Batch: &models.WebhookBatch{}})
+		require.NoError(t, err)
+		assert.Nil(t, updated.Batch)
+
+		require.NoError(t, service.SendEvent(ctx, "batch", &models.WebhookEvent{Event: "message"}))
+		req := receive()
+		assert.Equal(t, "message", req.event)
+	})
+}
+
+func TestWebhookService_BatchFlushFailure(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ctx := context.Background()
+	webhookRepo := repository.NewWebhookRepository(&repository.RedisClient{Client: redisClient})
+	service := NewWebhookService(webhookRepo)
+
+	config := &models.WebhookConfig{InstanceID: "batch", URL: "http://crm.local/hook", Batch: &models.WebhookBatch{MaxSize: 10, MaxLingerMs: 100}}
+	require.NoError(t, service.CreateWebhook(ctx, config))
+
+	key := repository.BatchKey("batch", config.ID, false)
+	for _, id := range []string{"evt-1", "evt-2"} {
+		_, err := webhookRepo.AppendBatch(ctx, &models.WebhookDelivery{
+			ID: id, InstanceID: "batch", WebhookID: config.ID, Event: "message", Payload: json.RawMessage(`{"event":"message"}`),
+		}, time.Now())
+		require.NoError(t, err)
+	}
+	require.NoError(t, redisClient.RPush(ctx, key, "{corrupto").Err())
+
+	// La cola de entregas con un tipo incorrecto hace fallar el LPUSH (WRONGTYPE)
+	require.NoError(t, mr.Set("webhook:queue", "corrupto"))
+	service.flushBatch(ctx, key)
+
+	n, err := redisClient.LLen(ctx, key).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n, "los eventos siguen en el lote")
+
+	mr.Del("webhook:queue")
+	service.flushBatch(ctx, key)
+
+	n, err = redisClient.LLen(ctx, key).Result()
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	deliveries, err := redisClient.LRange(ctx, "webhook:queue", 0, -1).Result()
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+
+	var delivery models.WebhookDelivery
+	require.NoError(t, json.Unmarshal([]byte(deliveries[0]), &delivery))
+	assert.Equal(t, models.WebhookEventBatch, delivery.Event)
+	var events []map[string]interface{}
+	require.NoError(t, json.Unmarshal(delivery.Payload, &events))
+	require.Len(t, events, 2)
+	assert.Equal(t, "evt-1", events[0]["id"])
+
+	due, err := webhookRepo.DueBatches(ctx, time.Now().Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+}
+
+func TestWebhookService_EventIDs(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	redisRepo := &repository.RedisClient{Client: redisClient}
+	webhookRepo := repository.NewWebhookRepository(redisRepo)
+	service := NewWebhookService(webhookRepo)
+	service.Start()
+	defer service.Stop()
+
+	ctx := context.Background()
+
+	type received struct {
+		body    []byte
+		eventID string
+	}
+	requests := make(chan received, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{body: body, eventID: r.Header.Get("X-Event-ID")}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	require.NoError(t, service.CreateWebhook(ctx, &models.WebhookConfig{InstanceID: "ids", URL: server.URL, Events: []string{"all"}}))
+
+	receive := func() (received, models.WebhookEvent) {
+		var req received
+		select {
+		case req = <-requests:
+		case <-time.After(3 * time.Second):
+			t.Fatal("el webhook no llegó")
+		}
+		var event models.WebhookEvent
+		require.NoError(t, json.Unmarshal(req.body, &event))
+		return req, event
+	}
+
+	t.Run("conservar el ID determinista del evento", func(t *testing.T) {
+		id := models.NewEventID("ids", "message", "3EB0ABC")
+		require.NoError(t, service.SendEvent(ctx, "ids", &models.WebhookEvent{ID: id, Event: "message"}))
+
+		req, event := receive()
+		assert.Equal(t, id, event.ID)
+		assert.Equal(t, id, req.eventID)
+	})
+
+	t.Run("asignar un ID a eventos sin origen en WhatsApp", func(t *testing.T) {
+		require.NoError(t, service.SendEvent(ctx, "ids", &models.WebhookEvent{Event: "status"}))
+
+		req, event := receive()
+		assert.NotEmpty(t, event.ID)
+		assert.Equal(t, event.ID, req.eventID)
+	})
 }
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// EventBus transporte que reparte los eventos entre réplicas, para que un cliente conectado a
+// cualquiera de ellas reciba los de instancias cuyo cliente de WhatsApp vive en otra.
+// repository.RedisEventBus lo implementa con Redis pub/sub.
+type EventBus interface {
+	Publish(ctx context.Context, data []byte) error
+	Subscribe(ctx context.Context, handler func(data []byte)) error
+}
+
+// busEnvelope evento tal como viaja por el bus
+type busEnvelope struct {
+	Origin     string          `json:"origin"` // Réplica que lo emitió; ella misma ya lo entregó localmente
+	InstanceID string          `json:"instance_id"`
+	Seq        int64           `json:"seq,omitempty"`
+	Type       string          `json:"type"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// SetEventBus reparte los eventos entre réplicas a través del bus. Debe llamarse antes de Run.
+func (s *WebSocketService) SetEventBus(bus EventBus) {
+	s.bus = bus
+}
+
+// publish envía un evento emitido en esta réplica al resto
+func (s *WebSocketService) publish(message BroadcastMessage) {
+	data, err := json.Marshal(busEnvelope{
+		Origin:     s.nodeID,
+		InstanceID: message.InstanceID,
+		Seq:        message.Seq,
+		Type:       message.Type,
+		Data:       message.Data,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error serializando evento para el bus")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.bus.Publish(ctx, data); err != nil {
+		log.Error().Err(err).Str("instance_id", message.InstanceID).Msg("Error publicando evento en el bus")
+	}
+}
+
+// consumeBus entrega a los suscriptores locales los eventos emitidos en otras réplicas.
+// Si la suscripción termina con error se reintenta.
+func (s *WebSocketService) consumeBus() {
+	for {
+		err := s.bus.Subscribe(context.Background(), func(data []byte) {
+			var envelope busEnvelope
+			if err := json.Unmarshal(data, &envelope); err != nil {
+				log.Error().Err(err).Msg("Evento inválido en el bus")
+				return
+			}
+			if envelope.Origin == s.nodeID {
+				return
+			}
+			s.broadcast <- BroadcastMessage{
+				InstanceID: envelope.InstanceID,
+				Seq:        envelope.Seq,
+				Type:       envelope.Type,
+				Data:       envelope.Data,
+			}
+		})
+		log.Error().Err(err).Msg("Suscripción al bus de eventos terminada, reintentando")
+		time.Sleep(time.Second)
+	}
+}
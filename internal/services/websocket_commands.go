@@ -0,0 +1,281 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"kero-kero/internal/models"
+	"kero-kero/pkg/errors"
+)
+
+const (
+	wsCommandBuffer  = 32              // Comandos en espera por conexión
+	wsMaxCommandSize = 1 << 20         // Tamaño máximo de un frame recibido
+	wsCommandTimeout = 2 * time.Minute // Tiempo máximo de ejecución de un comando
+)
+
+// Tipos de respuesta a un comando
+const (
+	WebSocketResponseAck   = "ack"
+	WebSocketResponseError = "error"
+)
+
+// WebSocketCommand comando enviado por el cliente en el socket de la instancia.
+// El ID lo elige el cliente y se devuelve en la respuesta para correlacionarla.
+type WebSocketCommand struct {
+	ID     string          `json:"id"`
+	Method string          `json:"method"` // send_text, send_media, react, mark_read, presence...
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// WebSocketCommandResponse respuesta (ack o error) a un comando
+type WebSocketCommandResponse struct {
+	ID     string           `json:"id"`
+	Type   string           `json:"type"` // ack, error
+	Result interface{}      `json:"result,omitempty"`
+	Error  *errors.AppError `json:"error,omitempty"`
+}
+
+// wsMediaCommand parámetros de send_media: el tipo de medio más los campos de SendMediaRequest
+type wsMediaCommand struct {
+	Type string `json:"type"` // image, video, audio, document
+	models.SendMediaRequest
+}
+
+// wsPresenceCommand parámetros de presence: typing/recording, o paused para detenerla
+type wsPresenceCommand struct {
+	Phone    string `json:"phone"`
+	Type     string `json:"type"`
+	Duration int    `json:"duration,omitempty"` // ms; si se indica la presencia se detiene sola
+}
+
+// wsChatCommand parámetros de los comandos que actúan sobre un chat completo
+type wsChatCommand struct {
+	JID string `json:"jid"`
+}
+
+// enqueueCommand decodifica un frame del cliente y lo deja en la cola de comandos de la conexión
+func (s *WebSocketService) enqueueCommand(client *wsClient, data []byte) {
+	var cmd WebSocketCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		s.replyError(client, "", errors.ErrBadRequest.WithDetails("JSON inválido"))
+		return
+	}
+	if cmd.ID == "" || cmd.Method == "" {
+		s.replyError(client, cmd.ID, errors.ErrBadRequest.WithDetails("id y method son requeridos"))
+		return
+	}
+
+	select {
+	case client.commands <- cmd:
+	default:
+		s.replyError(client, cmd.ID, errors.ErrServiceUnavailable.WithDetails("Demasiados comandos pendientes en la conexión"))
+	}
+}
+
+// commandLoop ejecuta en orden los comandos de una conexión hasta que se cierra
+func (s *WebSocketService) commandLoop(client *wsClient) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-client.done
+		cancel()
+	}()
+
+	for {
+		select {
+		case cmd := <-client.commands:
+			response := s.executeCommand(ctx, client.instanceID, cmd)
+			if err := client.writeJSON(response); err != nil {
+				log.Error().Err(err).Str("instance_id", client.instanceID).Str("command_id", cmd.ID).Msg("Error enviando respuesta de comando WS")
+			}
+		case <-client.done:
+			return
+		}
+	}
+}
+
+func (s *WebSocketService) replyError(client *wsClient, id string, appErr *errors.AppError) {
+	response := WebSocketCommandResponse{ID: id, Type: WebSocketResponseError, Error: appErr}
+	if err := client.writeJSON(response); err != nil {
+		log.Error().Err(err).Str("instance_id", client.instanceID).Msg("Error enviando respuesta de comando WS")
+	}
+}
+
+// executeCommand ejecuta un comando y construye su respuesta correlacionada
+func (s *WebSocketService) executeCommand(ctx context.Context, instanceID string, cmd WebSocketCommand) WebSocketCommandResponse {
+	ctx, cancel := context.WithTimeout(ctx, wsCommandTimeout)
+	defer cancel()
+
+	result, err := s.dispatchCommand(ctx, instanceID, cmd.Method, cmd.Params)
+	if err != nil {
+		return WebSocketCommandResponse{ID: cmd.ID, Type: WebSocketResponseError, Error: errors.FromError(err)}
+	}
+	return WebSocketCommandResponse{ID: cmd.ID, Type: WebSocketResponseAck, Result: result}
+}
+
+// dispatchCommand traduce un método del protocolo a la llamada del servicio correspondiente
+func (s *WebSocketService) dispatchCommand(ctx context.Context, instanceID, method string, params json.RawMessage) (interface{}, error) {
+	if method == "ping" {
+		return map[string]bool{"pong": true}, nil
+	}
+	if s.messageService == nil || s.presenceService == nil || s.chatService == nil {
+		return nil, errors.ErrServiceUnavailable.WithDetails("Los comandos por WebSocket no están habilitados")
+	}
+
+	switch method {
+	case "send_text":
+		var req models.SendTextRequest
+		if err := decodeCommandParams(params, &req); err != nil {
+			return nil, err
+		}
+		if req.Phone == "" || req.Message == "" {
+			return nil, errors.ErrBadRequest.WithDetails("phone y message son requeridos")
+		}
+		return s.messageService.SendText(ctx, instanceID, &req)
+
+	case "send_media":
+		var req wsMediaCommand
+		if err := decodeCommandParams(params, &req); err != nil {
+			return nil, err
+		}
+		if req.Phone == "" || req.MediaURL == "" {
+			return nil, errors.ErrBadRequest.WithDetails("phone y media_url son requeridos")
+		}
+		switch req.Type {
+		case string(models.MessageTypeImage):
+			return s.messageService.SendImage(ctx, instanceID, &req.SendMediaRequest)
+		case string(models.MessageTypeVideo):
+			return s.messageService.SendVideo(ctx, instanceID, &req.SendMediaRequest)
+		case string(models.MessageTypeAudio):
+			return s.messageService.SendAudio(ctx, instanceID, &req.SendMediaRequest)
+		case string(models.MessageTypeDocument):
+			return s.messageService.SendDocument(ctx, instanceID, &req.SendMediaRequest)
+		default:
+			return nil, errors.ErrBadRequest.WithDetails("type inválido (image, video, audio, document)")
+		}
+
+	case "send_location":
+		var req models.SendLocationRequest
+		if err := decodeCommandParams(params, &req); err != nil {
+			return nil, err
+		}
+		if req.Phone == "" {
+			return nil, errors.ErrBadRequest.WithDetails("phone es requerido")
+		}
+		return s.messageService.SendLocation(ctx, instanceID, &req)
+
+	case "send_contact":
+		var req models.SendContactRequest
+		if err := decodeCommandParams(params, &req); err != nil {
+			return nil, err
+		}
+		if req.Phone == "" || req.VCard == "" {
+			return nil, errors.ErrBadRequest.WithDetails("phone y vcard son requeridos")
+		}
+		return s.messageService.SendContact(ctx, instanceID, &req)
+
+	case "react":
+		var req models.ReactionRequest
+		if err := decodeCommandParams(params, &req); err != nil {
+			return nil, err
+		}
+		if req.Phone == "" || req.MessageID == "" {
+			return nil, errors.ErrBadRequest.WithDetails("phone y message_id son requeridos")
+		}
+		return s.messageService.ReactToMessage(ctx, instanceID, &req)
+
+	case "revoke":
+		var req models.RevokeRequest
+		if err := decodeCommandParams(params, &req); err != nil {
+			return nil, err
+		}
+		if req.Phone == "" || req.MessageID == "" {
+			return nil, errors.ErrBadRequest.WithDetails("phone y message_id son requeridos")
+		}
+		return s.messageService.RevokeMessage(ctx, instanceID, &req)
+
+	case "edit":
+		var req models.EditMessageRequest
+		if err := decodeCommandParams(params, &req); err != nil {
+			return nil, err
+		}
+		if req.Phone == "" || req.MessageID == "" || req.NewText == "" {
+			return nil, errors.ErrBadRequest.WithDetails("phone, message_id y new_text son requeridos")
+		}
+		return s.messageService.EditMessage(ctx, instanceID, &req)
+
+	case "mark_read":
+		var req models.MarkAsReadRequest
+		if err := decodeCommandParams(params, &req); err != nil {
+			return nil, err
+		}
+		if req.MessageID == "" || req.ChatJID == "" {
+			return nil, errors.ErrBadRequest.WithDetails("message_id y chat_jid son requeridos")
+		}
+		return s.messageService.MarkAsRead(ctx, instanceID, &req)
+
+	case "mark_chat_read":
+		var req wsChatCommand
+		if err := decodeCommandParams(params, &req); err != nil {
+			return nil, err
+		}
+		if req.JID == "" {
+			return nil, errors.ErrBadRequest.WithDetails("jid es requerido")
+		}
+		if err := s.chatService.MarkAsRead(ctx, instanceID, req.JID); err != nil {
+			return nil, err
+		}
+		return map[string]bool{"success": true}, nil
+
+	case "presence":
+		var req wsPresenceCommand
+		if err := decodeCommandParams(params, &req); err != nil {
+			return nil, err
+		}
+		if req.Phone == "" {
+			return nil, errors.ErrBadRequest.WithDetails("phone es requerido")
+		}
+		if req.Duration > 120000 {
+			return nil, errors.ErrBadRequest.WithDetails("duration máximo: 120000 ms")
+		}
+		switch {
+		case req.Type == "paused":
+			return s.presenceService.StopPresence(ctx, instanceID, &models.StopPresenceRequest{Phone: req.Phone})
+		case req.Duration > 0:
+			return s.presenceService.TimedPresence(ctx, instanceID, &models.TimedPresenceRequest{
+				Phone:    req.Phone,
+				Type:     models.PresenceType(req.Type),
+				Duration: req.Duration,
+			})
+		default:
+			return s.presenceService.StartPresence(ctx, instanceID, &models.StartPresenceRequest{
+				Phone: req.Phone,
+				Type:  models.PresenceType(req.Type),
+			})
+		}
+
+	case "set_status":
+		var req models.SetStatusRequest
+		if err := decodeCommandParams(params, &req); err != nil {
+			return nil, err
+		}
+		return s.presenceService.SetOnlineStatus(ctx, instanceID, &req)
+
+	default:
+		return nil, errors.ErrBadRequest.WithDetails("Método desconocido: " + method)
+	}
+}
+
+func decodeCommandParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return errors.ErrBadRequest.WithDetails("params es requerido")
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return errors.ErrBadRequest.WithDetails("params inválidos: " + err.Error())
+	}
+	return nil
+}
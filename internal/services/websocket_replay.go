@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// WebSocketEventReplayGap se envía al reanudar si parte de los eventos pedidos ya salió del log;
+// el cliente debería volver a sincronizar su estado por la API REST.
+const WebSocketEventReplayGap = "replay.gap"
+
+// parseLastEventID lee el último seq recibido por el cliente (?last_event_id= o header Last-Event-ID)
+func parseLastEventID(r *http.Request) (int64, bool) {
+	value := r.URL.Query().Get("last_event_id")
+	if value == "" {
+		value = r.Header.Get("Last-Event-ID")
+	}
+	if value == "" {
+		return 0, false
+	}
+	seq, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, false
+	}
+	return seq, true
+}
+
+// withSeq agrega el número de secuencia a un WebSocketMessage ya serializado
+func withSeq(data []byte, seq int64) ([]byte, error) {
+	msg, err := loggedMessage(data, seq)
+	return msg.Data, err
+}
+
+// loggedMessage reconstruye un evento del log con su número de secuencia
+func loggedMessage(data []byte, seq int64) (BroadcastMessage, error) {
+	var msg struct {
+		ID      string          `json:"id"`
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return BroadcastMessage{}, err
+	}
+	out, err := json.Marshal(WebSocketMessage{ID: msg.ID, Seq: seq, Type: msg.Type, Payload: msg.Payload})
+	return BroadcastMessage{Seq: seq, Type: msg.Type, Data: out}, err
+}
+
+// replay envía al suscriptor los eventos posteriores a lastEventID y después libera los que
+// llegaron en vivo mientras tanto, descartando los que ya se enviaron en el replay.
+func (s *WebSocketService) replay(sub eventSubscriber, instanceID string, lastEventID int64) {
+	sent := lastEventID
+	defer func() {
+		finishReplay(sub, sent)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lastSeq, err := s.eventLog.LastSeq(ctx, instanceID)
+	if err != nil {
+		log.Error().Err(err).Str("instance_id", instanceID).Msg("Error leyendo log de eventos WS")
+		return
+	}
+	// Un last_event_id mayor al último asignado indica que el log se perdió (p. ej. Redis vacío)
+	after := lastEventID
+	if after > lastSeq {
+		after = 0
+		sent = 0
+	}
+
+	events, err := s.eventLog.Since(ctx, instanceID, after)
+	if err != nil {
+		log.Error().Err(err).Str("instance_id", instanceID).Msg("Error leyendo log de eventos WS")
+		return
+	}
+
+	firstAvailable := lastSeq + 1
+	if len(events) > 0 {
+		firstAvailable = events[0].Seq
+	}
+	if lastEventID > lastSeq || firstAvailable > after+1 {
+		data, err := json.Marshal(WebSocketMessage{
+			ID:   uuid.New().String(),
+			Type: WebSocketEventReplayGap,
+			Payload: map[string]int64{
+				"last_event_id":   lastEventID,
+				"first_available": firstAvailable,
+			},
+		})
+		if err != nil {
+			return
+		}
+		if err := sub.sendWait(BroadcastMessage{InstanceID: instanceID, Type: WebSocketEventReplayGap, Data: data}); err != nil {
+			return
+		}
+	}
+
+	for _, event := range events {
+		message, err := loggedMessage(event.Data, event.Seq)
+		if err != nil {
+			continue
+		}
+		message.InstanceID = instanceID
+		if err := sub.sendWait(message); err != nil {
+			return
+		}
+		sent = event.Seq
+	}
+
+	log.Info().Str("instance_id", instanceID).Int64("last_event_id", lastEventID).Int("replayed", len(events)).Msg("Suscriptor de eventos reanudado")
+}
+
+// finishReplay envía los eventos retenidos durante el replay y vuelve a la entrega en vivo.
+// Si no caben en el buffer el suscriptor se desconecta, igual que en la entrega en vivo.
+func finishReplay(sub eventSubscriber, sent int64) {
+	b := sub.replayState()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, message := range b.pending {
+		if message.Seq != 0 && message.Seq <= sent {
+			continue
+		}
+		if err := sub.send(message); err != nil {
+			sub.close()
+			break
+		}
+	}
+	b.pending = nil
+	b.replaying = false
+}
@@ -1,77 +1,260 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog/log"
+
+	"kero-kero/internal/repository"
 )
 
+// WebSocketSubprotocol subprotocolo de la API. Los clientes de navegador pueden ofrecerlo junto
+// con el token (Sec-WebSocket-Protocol: kero-kero, <token>) y el servidor responde con él.
+const WebSocketSubprotocol = "kero-kero"
+
 // WebSocketMessage representa un mensaje enviado por WS
 type WebSocketMessage struct {
+	ID      string      `json:"id"`            // Mismo ID que el webhook del evento, si lo tiene
+	Seq     int64       `json:"seq,omitempty"` // Posición en el log de la instancia; se usa como last_event_id al reconectar
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
 }
 
 // ClientRegistration representa un cliente registrándose a un room
 type ClientRegistration struct {
-	Conn       *websocket.Conn
+	Client     eventSubscriber
 	InstanceID string
 }
 
 // BroadcastMessage representa un mensaje a enviar a un room específico
 type BroadcastMessage struct {
 	InstanceID string
-	Data       []byte
+	Seq        int64 // 0 si el log de eventos está deshabilitado
+	Type       string
+	Data       []byte // WebSocketMessage serializado
+}
+
+const (
+	wsSendBuffer = 256                   // Mensajes pendientes por conexión antes de expulsarla
+	wsWriteWait  = 10 * time.Second      // Tiempo máximo para escribir un frame
+	wsPongWait   = 60 * time.Second      // Sin pong en este tiempo la conexión se da por muerta
+	wsPingPeriod = (wsPongWait * 9) / 10 // Frecuencia de los pings; menor que wsPongWait
+)
+
+// errSlowSubscriber el suscriptor no consume sus eventos a tiempo y su buffer se llenó
+var errSlowSubscriber = errors.New("suscriptor lento: buffer de salida lleno")
+
+// errSubscriberClosed el suscriptor ya se desconectó
+var errSubscriberClosed = errors.New("suscriptor desconectado")
+
+// eventSubscriber destino de los eventos de un room: una conexión WebSocket o un stream SSE.
+// Cada uno tiene su propio buffer y goroutine de escritura, así un cliente lento no frena al resto.
+type eventSubscriber interface {
+	send(message BroadcastMessage) error     // Encola sin bloquear; errSlowSubscriber si el buffer está lleno
+	sendWait(message BroadcastMessage) error // Encola esperando hasta wsWriteWait (replay)
+	close()
+	replayState() *replayBuffer
+}
+
+// replayBuffer retiene los eventos en vivo mientras se reenvían al suscriptor los que se perdió
+type replayBuffer struct {
+	mu        sync.Mutex
+	replaying bool
+	pending   []BroadcastMessage
+}
+
+func (b *replayBuffer) replayState() *replayBuffer {
+	return b
+}
+
+// deliver envía un evento en vivo, o lo retiene si el suscriptor todavía está recibiendo el replay
+func deliver(sub eventSubscriber, message BroadcastMessage) error {
+	b := sub.replayState()
+	b.mu.Lock()
+	if b.replaying {
+		b.pending = append(b.pending, message)
+		b.mu.Unlock()
+		return nil
+	}
+	b.mu.Unlock()
+	return sub.send(message)
+}
+
+// wsClient conexión WebSocket de un room. gorilla/websocket no admite escrituras concurrentes:
+// los eventos y las respuestas a comandos se encolan en outbound y solo writePump escribe.
+type wsClient struct {
+	conn       *websocket.Conn
+	instanceID string
+	outbound   chan []byte
+	commands   chan WebSocketCommand
+	done       chan struct{}
+	closeOnce  sync.Once
+	evicted    atomic.Bool // Se cerró por lento; writePump lo indica en el close frame
+
+	replayBuffer
+}
+
+func (c *wsClient) send(message BroadcastMessage) error {
+	select {
+	case <-c.done:
+		return errSubscriberClosed
+	default:
+	}
+	select {
+	case c.outbound <- message.Data:
+		return nil
+	default:
+		c.evicted.Store(true)
+		return errSlowSubscriber
+	}
+}
+
+func (c *wsClient) sendWait(message BroadcastMessage) error {
+	return c.queue(message.Data)
+}
+
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// queue encola un frame esperando lugar en el buffer hasta wsWriteWait
+func (c *wsClient) queue(data []byte) error {
+	timer := time.NewTimer(wsWriteWait)
+	defer timer.Stop()
+	select {
+	case c.outbound <- data:
+		return nil
+	case <-c.done:
+		return errSubscriberClosed
+	case <-timer.C:
+		return errSlowSubscriber
+	}
+}
+
+func (c *wsClient) writeJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.queue(data)
 }
 
 // WebSocketService maneja las conexiones en tiempo real con soporte de rooms
 type WebSocketService struct {
-	rooms      map[string]map[*websocket.Conn]bool // instanceID -> set of connections
+	rooms      map[string]map[eventSubscriber]bool // instanceID -> set of subscribers
 	broadcast  chan BroadcastMessage
 	register   chan ClientRegistration
 	unregister chan ClientRegistration
 	mutex      sync.RWMutex
 	upgrader   websocket.Upgrader
+
+	allowedOrigins []string // CORS_ALLOWED_ORIGINS; "*" acepta cualquier origen
+
+	eventLog *repository.EventStreamRepository // Log por instancia para reanudar conexiones; nil lo deshabilita
+	bus      EventBus                          // Reparte los eventos entre réplicas; nil si hay una sola
+	nodeID   string                            // Identifica a esta réplica en el bus
+
+	evicted atomic.Uint64 // Suscriptores expulsados por lentos desde el arranque
+
+	// Servicios a los que se despachan los comandos recibidos por el socket
+	messageService  *MessageService
+	presenceService *PresenceService
+	chatService     *ChatService
 }
 
 func NewWebSocketService() *WebSocketService {
-	return &WebSocketService{
-		rooms:      make(map[string]map[*websocket.Conn]bool),
-		broadcast:  make(chan BroadcastMessage),
-		register:   make(chan ClientRegistration),
-		unregister: make(chan ClientRegistration),
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Permitir todas las conexiones por ahora (CORS)
-			},
-		},
+	s := &WebSocketService{
+		rooms:          make(map[string]map[eventSubscriber]bool),
+		broadcast:      make(chan BroadcastMessage),
+		register:       make(chan ClientRegistration),
+		unregister:     make(chan ClientRegistration),
+		allowedOrigins: []string{"*"},
+		nodeID:         uuid.New().String(),
+	}
+	s.upgrader = websocket.Upgrader{CheckOrigin: s.checkOrigin}
+	return s
+}
+
+// SetAllowedOrigins configura los orígenes aceptados en el upgrade (mismo formato que CORS_ALLOWED_ORIGINS)
+func (s *WebSocketService) SetAllowedOrigins(origins []string) {
+	s.allowedOrigins = origins
+}
+
+// SetEventLog habilita el log de eventos por instancia y la reanudación con last_event_id
+func (s *WebSocketService) SetEventLog(eventLog *repository.EventStreamRepository) {
+	s.eventLog = eventLog
+}
+
+// SetCommandServices habilita los comandos por WebSocket (send_text, react, presence...)
+func (s *WebSocketService) SetCommandServices(message *MessageService, presence *PresenceService, chat *ChatService) {
+	s.messageService = message
+	s.presenceService = presence
+	s.chatService = chat
+}
+
+// checkOrigin valida el header Origin del handshake. Los clientes que no son navegadores
+// no lo envían y se aceptan; la autenticación la hace el middleware.
+func (s *WebSocketService) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
 	}
+	for _, allowed := range s.allowedOrigins {
+		if originAllowed(strings.TrimSpace(allowed), origin) {
+			return true
+		}
+	}
+	log.Warn().Str("origin", origin).Msg("Origen no permitido en upgrade WebSocket")
+	return false
+}
+
+// originAllowed compara un origen con un patrón de CORS ("*", exacto o con comodín como https://*.example.com)
+func originAllowed(pattern, origin string) bool {
+	pattern = strings.ToLower(pattern)
+	origin = strings.ToLower(origin)
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+	prefix, suffix, ok := strings.Cut(pattern, "*")
+	return ok && len(origin) >= len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
 }
 
 // Run inicia el bucle de eventos del WS
 func (s *WebSocketService) Run() {
+	if s.bus != nil {
+		go s.consumeBus()
+	}
+
 	for {
 		select {
 		case registration := <-s.register:
 			s.mutex.Lock()
 			if _, ok := s.rooms[registration.InstanceID]; !ok {
-				s.rooms[registration.InstanceID] = make(map[*websocket.Conn]bool)
+				s.rooms[registration.InstanceID] = make(map[eventSubscriber]bool)
 			}
-			s.rooms[registration.InstanceID][registration.Conn] = true
+			s.rooms[registration.InstanceID][registration.Client] = true
 			s.mutex.Unlock()
 			log.Info().Str("instance_id", registration.InstanceID).Msg("Cliente WebSocket conectado a room")
 
 		case registration := <-s.unregister:
 			s.mutex.Lock()
 			if room, ok := s.rooms[registration.InstanceID]; ok {
-				if _, ok := room[registration.Conn]; ok {
-					delete(room, registration.Conn)
-					registration.Conn.Close()
+				if _, ok := room[registration.Client]; ok {
+					delete(room, registration.Client)
+					registration.Client.close()
 					// Si el room está vacío, eliminarlo
 					if len(room) == 0 {
 						delete(s.rooms, registration.InstanceID)
@@ -82,18 +265,26 @@ func (s *WebSocketService) Run() {
 			s.mutex.Unlock()
 
 		case message := <-s.broadcast:
-			s.mutex.RLock()
+			s.mutex.Lock()
 			if room, ok := s.rooms[message.InstanceID]; ok {
 				for client := range room {
-					err := client.WriteMessage(websocket.TextMessage, message.Data)
-					if err != nil {
+					err := deliver(client, message)
+					if err == errSlowSubscriber {
+						s.evicted.Add(1)
+						log.Warn().Str("instance_id", message.InstanceID).Msg("Suscriptor expulsado por no consumir sus eventos a tiempo")
+					} else if err != nil {
 						log.Error().Err(err).Str("instance_id", message.InstanceID).Msg("Error enviando mensaje WS")
-						client.Close()
+					}
+					if err != nil {
+						client.close()
 						delete(room, client)
 					}
 				}
+				if len(room) == 0 {
+					delete(s.rooms, message.InstanceID)
+				}
 			}
-			s.mutex.RUnlock()
+			s.mutex.Unlock()
 		}
 	}
 }
@@ -106,54 +297,164 @@ func (s *WebSocketService) HandleConnection(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	conn, err := s.upgrader.Upgrade(w, r, nil)
+	// Si el cliente ofreció subprotocolos hay que responder con uno de ellos o el navegador cierra
+	// la conexión. Solo se acepta el de la API: los demás son la credencial y devolverla en el
+	// header de respuesta la dejaría a la vista de proxies y herramientas del navegador.
+	var responseHeader http.Header
+	if protocols := websocket.Subprotocols(r); len(protocols) > 0 {
+		offered := false
+		for _, protocol := range protocols {
+			if protocol == WebSocketSubprotocol {
+				offered = true
+				break
+			}
+		}
+		if !offered {
+			http.Error(w, "Sec-WebSocket-Protocol must include "+WebSocketSubprotocol, http.StatusBadRequest)
+			return
+		}
+		responseHeader = http.Header{"Sec-Websocket-Protocol": {WebSocketSubprotocol}}
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Error().Err(err).Msg("Error al actualizar a WebSocket")
 		return
 	}
 
-	registration := ClientRegistration{
-		Conn:       conn,
+	client := &wsClient{
+		conn:       conn,
+		instanceID: instanceID,
+		outbound:   make(chan []byte, wsSendBuffer),
+		commands:   make(chan WebSocketCommand, wsCommandBuffer),
+		done:       make(chan struct{}),
+	}
+
+	// Con last_event_id el cliente se registra reteniendo los eventos en vivo hasta
+	// recibir los que se perdió; así no hay huecos ni duplicados entre ambos.
+	lastEventID, resume := parseLastEventID(r)
+	resume = resume && s.eventLog != nil
+	client.replaying = resume
+
+	s.register <- ClientRegistration{
+		Client:     client,
 		InstanceID: instanceID,
 	}
-	s.register <- registration
+	if resume {
+		go s.replay(client, instanceID, lastEventID)
+	}
 
-	// Mantener conexión viva y leer mensajes (ping/pong)
-	go s.readPump(conn, instanceID)
+	go s.writePump(client)
+	// Los comandos se ejecutan en orden fuera del bucle de lectura para no bloquear los pongs
+	go s.commandLoop(client)
+	// Mantener conexión viva y leer mensajes (ping/pong y comandos)
+	go s.readPump(client)
 }
 
-func (s *WebSocketService) readPump(conn *websocket.Conn, instanceID string) {
+func (s *WebSocketService) readPump(client *wsClient) {
 	defer func() {
+		client.close()
 		s.unregister <- ClientRegistration{
-			Conn:       conn,
-			InstanceID: instanceID,
+			Client:     client,
+			InstanceID: client.instanceID,
 		}
 	}()
 
-	conn.SetReadLimit(512)
-	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	conn := client.conn
+	conn.SetReadLimit(wsMaxCommandSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
 	conn.SetPongHandler(func(string) error {
-		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
 		return nil
 	})
 
 	for {
-		_, _, err := conn.ReadMessage()
+		_, data, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Error().Err(err).Msg("Error leyendo mensaje WS")
 			}
 			break
 		}
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		s.enqueueCommand(client, data)
+	}
+}
+
+// writePump es el único que escribe en la conexión: eventos, respuestas a comandos y pings.
+// Al cerrarse el cliente envía un close frame; si fue expulsado por lento, con el motivo.
+func (s *WebSocketService) writePump(client *wsClient) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		client.conn.Close()
+	}()
+
+	conn := client.conn
+	for {
+		select {
+		case data := <-client.outbound:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				client.close()
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				client.close()
+				return
+			}
+		case <-client.done:
+			code, reason := websocket.CloseNormalClosure, ""
+			if client.evicted.Load() {
+				code, reason = websocket.ClosePolicyViolation, "cliente lento: demasiados eventos pendientes"
+			}
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteWait))
+			return
+		}
 	}
 }
 
+// RealtimeStats conexiones en tiempo real abiertas en esta réplica
+type RealtimeStats struct {
+	WebSocket int            `json:"websocket"`
+	SSE       int            `json:"sse"`
+	Instances map[string]int `json:"instances"` // Suscriptores por instancia
+	Evicted   uint64         `json:"evicted"`   // Expulsados por lentos desde el arranque
+}
+
+// Stats devuelve las conexiones abiertas para monitoreo
+func (s *WebSocketService) Stats() RealtimeStats {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	stats := RealtimeStats{Instances: make(map[string]int, len(s.rooms)), Evicted: s.evicted.Load()}
+	for instanceID, room := range s.rooms {
+		stats.Instances[instanceID] = len(room)
+		for client := range room {
+			switch client.(type) {
+			case *wsClient:
+				stats.WebSocket++
+			case *sseClient:
+				stats.SSE++
+			}
+		}
+	}
+	return stats
+}
+
 // BroadcastEvent envía un evento a todos los clientes conectados a un room específico
 func (s *WebSocketService) BroadcastEvent(eventType string, payload interface{}) {
 	msg := WebSocketMessage{
+		ID:      uuid.New().String(),
 		Type:    eventType,
 		Payload: payload,
 	}
+	if payloadMap, ok := payload.(map[string]interface{}); ok {
+		if id, ok := payloadMap["event_id"].(string); ok && id != "" {
+			msg.ID = id
+		}
+	}
 
 	jsonMsg, err := json.Marshal(msg)
 	if err != nil {
@@ -174,8 +475,30 @@ func (s *WebSocketService) BroadcastEvent(eventType string, payload interface{})
 		return
 	}
 
-	s.broadcast <- BroadcastMessage{
+	// Guardar en el log de la instancia; el evento se entrega con su número de secuencia
+	var seq int64
+	if s.eventLog != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		seq, err = s.eventLog.Append(ctx, instanceID, jsonMsg)
+		cancel()
+		if err != nil {
+			log.Error().Err(err).Str("instance_id", instanceID).Msg("Error guardando evento WS en el log")
+			seq = 0
+		} else if jsonMsg, err = withSeq(jsonMsg, seq); err != nil {
+			log.Error().Err(err).Msg("Error serializando mensaje WS")
+			return
+		}
+	}
+
+	message := BroadcastMessage{
 		InstanceID: instanceID,
+		Seq:        seq,
+		Type:       eventType,
 		Data:       jsonMsg,
 	}
+	// Las demás réplicas lo reciben por el bus; aquí se entrega directo a los clientes locales
+	if s.bus != nil {
+		s.publish(message)
+	}
+	s.broadcast <- message
 }
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"kero-kero/internal/repository"
+	"kero-kero/internal/testutil"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	assert.True(t, originAllowed("*", "https://panel.example.com"))
+	assert.True(t, originAllowed("https://panel.example.com", "https://PANEL.example.com"))
+	assert.True(t, originAllowed("https://*.example.com", "https://panel.example.com"))
+	assert.False(t, originAllowed("https://*.example.com", "https://example.org"))
+	assert.False(t, originAllowed("https://panel.example.com", "https://evil.com"))
+}
+
+func TestWebSocketService_HandleConnection(t *testing.T) {
+	ws := NewWebSocketService()
+	ws.SetAllowedOrigins([]string{"https://panel.example.com"})
+	go ws.Run()
+
+	r := chi.NewRouter()
+	r.Get("/instances/{instanceID}/ws", ws.HandleConnection)
+	server := httptest.NewServer(r)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/instances/ventas/ws"
+
+	t.Run("origen no permitido", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.com"}})
+		require.Error(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("origen permitido con subprotocolo de la API", func(t *testing.T) {
+		dialer := websocket.Dialer{Subprotocols: []string{"token-abc", WebSocketSubprotocol}}
+		conn, _, err := dialer.Dial(url, http.Header{"Origin": {"https://panel.example.com"}})
+		require.NoError(t, err)
+		defer conn.Close()
+		assert.Equal(t, WebSocketSubprotocol, conn.Subprotocol())
+	})
+
+	t.Run("token como subprotocolo sin el de la API", func(t *testing.T) {
+		// Aceptarlo obligaría a devolver el token en Sec-WebSocket-Protocol
+		dialer := websocket.Dialer{Subprotocols: []string{"token-abc"}}
+		_, resp, err := dialer.Dial(url, nil)
+		require.Error(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.NotContains(t, resp.Header.Get("Sec-WebSocket-Protocol"), "token-abc")
+	})
+}
+
+func TestWebSocketService_Commands(t *testing.T) {
+	messageService, waManager, messageRepo, cleanup := setupMessageService(t)
+	defer cleanup()
+
+	ws := NewWebSocketService()
+	ws.SetCommandServices(messageService, NewPresenceService(waManager), NewChatService(waManager, messageRepo))
+	go ws.Run()
+
+	r := chi.NewRouter()
+	r.Get("/instances/{instanceID}/ws", ws.HandleConnection)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/instances/ventas/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	call := func(frame string) WebSocketCommandResponse {
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(frame)))
+		var response WebSocketCommandResponse
+		require.NoError(t, conn.ReadJSON(&response))
+		return response
+	}
+
+	t.Run("ack correlacionado", func(t *testing.T) {
+		response := call(`{"id":"c1","method":"ping"}`)
+		assert.Equal(t, "c1", response.ID)
+		assert.Equal(t, WebSocketResponseAck, response.Type)
+		assert.Equal(t, map[string]interface{}{"pong": true}, response.Result)
+	})
+
+	t.Run("error del servicio", func(t *testing.T) {
+		response := call(`{"id":"c2","method":"send_text","params":{"phone":"5215512345678","message":"Hola"}}`)
+		assert.Equal(t, "c2", response.ID)
+		assert.Equal(t, WebSocketResponseError, response.Type)
+		require.NotNil(t, response.Error)
+		assert.Equal(t, http.StatusNotFound, response.Error.Code)
+	})
+
+	t.Run("parámetros faltantes", func(t *testing.T) {
+		response := call(`{"id":"c3","method":"react","params":{"emoji":"👍"}}`)
+		assert.Equal(t, WebSocketResponseError, response.Type)
+		assert.Equal(t, http.StatusBadRequest, response.Error.Code)
+	})
+
+	t.Run("método desconocido y frame inválido", func(t *testing.T) {
+		response := call(`{"id":"c4","method":"nope","params":{}}`)
+		assert.Equal(t, "c4", response.ID)
+		assert.Contains(t, response.Error.Details, "nope")
+
+		response = call(`no es json`)
+		assert.Equal(t, WebSocketResponseError, response.Type)
+		assert.Empty(t, response.ID)
+	})
+}
+
+func TestWebSocketService_Replay(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ws := NewWebSocketService()
+	ws.SetEventLog(repository.NewEventStreamRepository(&repository.RedisClient{Client: redisClient}, 100, time.Hour))
+	go ws.Run()
+
+	r := chi.NewRouter()
+	r.Get("/instances/{instanceID}/ws", ws.HandleConnection)
+	server := httptest.NewServer(r)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/instances/ventas/ws"
+
+	emit := func(text string) {
+		ws.BroadcastEvent("message", map[string]interface{}{"instance_id": "ventas", "text": text})
+	}
+	read := func(conn *websocket.Conn) WebSocketMessage {
+		var msg WebSocketMessage
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		require.NoError(t, conn.ReadJSON(&msg))
+		return msg
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		ws.mutex.RLock()
+		defer ws.mutex.RUnlock()
+		return len(ws.rooms["ventas"]) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	emit("uno")
+	emit("dos")
+	assert.Equal(t, int64(1), read(conn).Seq)
+	assert.Equal(t, int64(2), read(conn).Seq)
+	conn.Close()
+
+	// Eventos emitidos mientras el cliente está desconectado
+	emit("tres")
+	emit("cuatro")
+
+	t.Run("reanuda desde last_event_id", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(url+"?last_event_id=2", nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		msg := read(conn)
+		assert.Equal(t, int64(3), msg.Seq)
+		assert.Equal(t, "tres", msg.Payload.(map[string]interface{})["text"])
+		assert.Equal(t, int64(4), read(conn).Seq)
+
+		emit("cinco")
+		assert.Equal(t, int64(5), read(conn).Seq)
+	})
+
+	t.Run("aviso de hueco si el log no alcanza", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(url+"?last_event_id=99", nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		gap := read(conn)
+		assert.Equal(t, WebSocketEventReplayGap, gap.Type)
+		assert.Equal(t, int64(1), read(conn).Seq)
+	})
+}
+
+func TestWebSocketService_EventBus(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+	redisRepo := &repository.RedisClient{Client: redisClient}
+
+	// Dos réplicas que comparten Redis
+	dial := func(ws *WebSocketService) *websocket.Conn {
+		r := chi.NewRouter()
+		r.Get("/instances/{instanceID}/ws", ws.HandleConnection)
+		server := httptest.NewServer(r)
+		t.Cleanup(server.Close)
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/instances/ventas/ws", nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		require.Eventually(t, func() bool {
+			ws.mutex.RLock()
+			defer ws.mutex.RUnlock()
+			return len(ws.rooms["ventas"]) == 1
+		}, time.Second, 10*time.Millisecond)
+		return conn
+	}
+
+	replicaA := NewWebSocketService()
+	replicaA.SetEventBus(repository.NewRedisEventBus(redisRepo))
+	go replicaA.Run()
+	replicaB := NewWebSocketService()
+	replicaB.SetEventBus(repository.NewRedisEventBus(redisRepo))
+	go replicaB.Run()
+
+	require.Eventually(t, func() bool {
+		return mr.PubSubNumSub(repository.EventBusChannel)[repository.EventBusChannel] == 2
+	}, time.Second, 10*time.Millisecond)
+
+	connA := dial(replicaA)
+	connB := dial(replicaB)
+
+	replicaA.BroadcastEvent("message", map[string]interface{}{"instance_id": "ventas", "text": "hola", "event_id": "evt-1"})
+
+	for _, conn := range []*websocket.Conn{connA, connB} {
+		var msg WebSocketMessage
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		require.NoError(t, conn.ReadJSON(&msg))
+		assert.Equal(t, "evt-1", msg.ID)
+	}
+
+	// La réplica de origen no recibe su propio evento una segunda vez
+	var extra WebSocketMessage
+	require.NoError(t, connA.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+	assert.Error(t, connA.ReadJSON(&extra))
+}
+
+func TestWebSocketService_SlowConsumer(t *testing.T) {
+	ws := NewWebSocketService()
+	go ws.Run()
+
+	r := chi.NewRouter()
+	r.Get("/instances/{instanceID}/ws", ws.HandleConnection)
+	server := httptest.NewServer(r)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// Un cliente que nunca lee en "lenta" y uno normal en "rapida"
+	slow, _, err := websocket.DefaultDialer.Dial(url+"/instances/lenta/ws", nil)
+	require.NoError(t, err)
+	defer slow.Close()
+	fast, _, err := websocket.DefaultDialer.Dial(url+"/instances/rapida/ws", nil)
+	require.NoError(t, err)
+	defer fast.Close()
+
+	require.Eventually(t, func() bool {
+		return ws.Stats().WebSocket == 2
+	}, time.Second, 10*time.Millisecond)
+
+	text := strings.Repeat("x", 64*1024)
+	for i := 0; i < 2*wsSendBuffer; i++ {
+		ws.BroadcastEvent("message", map[string]interface{}{"instance_id": "lenta", "text": text})
+	}
+
+	require.Eventually(t, func() bool {
+		return ws.Stats().Evicted == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Los eventos de otras instancias siguen fluyendo
+	ws.BroadcastEvent("message", map[string]interface{}{"instance_id": "rapida", "text": "hola"})
+	var msg WebSocketMessage
+	require.NoError(t, fast.SetReadDeadline(time.Now().Add(2*time.Second)))
+	require.NoError(t, fast.ReadJSON(&msg))
+	assert.Equal(t, "hola", msg.Payload.(map[string]interface{})["text"])
+
+	stats := ws.Stats()
+	assert.Equal(t, 1, stats.WebSocket)
+	assert.Equal(t, map[string]int{"rapida": 1}, stats.Instances)
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	sseBuffer            = 256              // Eventos en espera por stream antes de desconectarlo
+	sseKeepaliveInterval = 15 * time.Second // Comentario periódico para que los proxies no corten la conexión
+	sseRetry             = 3000             // ms que espera EventSource antes de reconectar
+)
+
+// sseClient stream Server-Sent Events de un room. El broadcast nunca escribe directo en la
+// respuesta HTTP: deja los eventos en un canal que consume el handler de la petición.
+type sseClient struct {
+	events    chan BroadcastMessage
+	types     map[string]bool // Filtro ?events=; vacío recibe todos
+	done      chan struct{}
+	closeOnce sync.Once
+
+	replayBuffer
+}
+
+func (c *sseClient) send(message BroadcastMessage) error {
+	if !c.wants(message) {
+		return nil
+	}
+	select {
+	case <-c.done:
+		return errSubscriberClosed
+	default:
+	}
+	select {
+	case c.events <- message:
+		return nil
+	default:
+		return errSlowSubscriber
+	}
+}
+
+func (c *sseClient) sendWait(message BroadcastMessage) error {
+	if !c.wants(message) {
+		return nil
+	}
+	timer := time.NewTimer(wsWriteWait)
+	defer timer.Stop()
+	select {
+	case c.events <- message:
+		return nil
+	case <-c.done:
+		return errSubscriberClosed
+	case <-timer.C:
+		return errSlowSubscriber
+	}
+}
+
+func (c *sseClient) wants(message BroadcastMessage) bool {
+	return len(c.types) == 0 || message.Type == WebSocketEventReplayGap || c.types[message.Type]
+}
+
+func (c *sseClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// HandleSSE transmite los eventos de una instancia como text/event-stream, para clientes que no
+// pueden usar WebSocket (proxies corporativos, EventSource, curl).
+// Admite Last-Event-ID (o ?last_event_id=) para reanudar y ?events=message,receipt para filtrar.
+func (s *WebSocketService) HandleSSE(w http.ResponseWriter, r *http.Request) {
+	instanceID := chi.URLParam(r, "instanceID")
+	if instanceID == "" {
+		http.Error(w, "Instance ID required", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming no soportado", http.StatusInternalServerError)
+		return
+	}
+
+	client := &sseClient{
+		events: make(chan BroadcastMessage, sseBuffer),
+		types:  parseEventTypes(r.URL.Query().Get("events")),
+		done:   make(chan struct{}),
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Desactiva el buffering de nginx
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+	flusher.Flush()
+
+	lastEventID, resume := parseLastEventID(r)
+	resume = resume && s.eventLog != nil
+	client.replaying = resume
+
+	s.register <- ClientRegistration{Client: client, InstanceID: instanceID}
+	defer func() {
+		s.unregister <- ClientRegistration{Client: client, InstanceID: instanceID}
+	}()
+	if resume {
+		go s.replay(client, instanceID, lastEventID)
+	}
+
+	keepalive := time.NewTicker(sseKeepaliveInterval)
+	defer keepalive.Stop()
+
+	// El middleware Timeout del router vence el contexto de todas las peticiones; en un stream
+	// solo interesa la desconexión del cliente, que también se detecta al fallar la escritura.
+	requestDone := r.Context().Done()
+	for {
+		select {
+		case message := <-client.events:
+			if err := writeSSE(w, message); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-client.done:
+			log.Warn().Str("instance_id", instanceID).Msg("Stream SSE desconectado")
+			return
+		case <-requestDone:
+			if r.Context().Err() != context.DeadlineExceeded {
+				return
+			}
+			requestDone = nil
+		}
+	}
+}
+
+// writeSSE escribe un evento: id es el seq (para Last-Event-ID) y data el mismo JSON que en WebSocket
+func writeSSE(w http.ResponseWriter, message BroadcastMessage) error {
+	var b strings.Builder
+	if message.Seq > 0 {
+		fmt.Fprintf(&b, "id: %d\n", message.Seq)
+	}
+	if message.Type != "" {
+		fmt.Fprintf(&b, "event: %s\n", message.Type)
+	}
+	fmt.Fprintf(&b, "data: %s\n\n", message.Data)
+	_, err := fmt.Fprint(w, b.String())
+	return err
+}
+
+func parseEventTypes(value string) map[string]bool {
+	types := make(map[string]bool)
+	for _, t := range strings.Split(value, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types[t] = true
+		}
+	}
+	return types
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"kero-kero/internal/repository"
+	"kero-kero/internal/testutil"
+)
+
+func TestWebSocketService_HandleSSE(t *testing.T) {
+	mr, redisClient := testutil.NewMockRedis(t)
+	defer testutil.CleanupRedis(t, mr, redisClient)
+
+	ws := NewWebSocketService()
+	ws.SetEventLog(repository.NewEventStreamRepository(&repository.RedisClient{Client: redisClient}, 100, time.Hour))
+	go ws.Run()
+
+	r := chi.NewRouter()
+	r.Get("/instances/{instanceID}/events", ws.HandleSSE)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	emit := func(eventType, text string) {
+		ws.BroadcastEvent(eventType, map[string]interface{}{"instance_id": "ventas", "text": text})
+	}
+
+	// open abre el stream y devuelve una función que lee el siguiente evento (líneas hasta la vacía)
+	open := func(t *testing.T, query string, header http.Header) (func() []string, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/instances/ventas/events"+query, nil)
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		reader := bufio.NewReader(resp.Body)
+		next := func() []string {
+			var lines []string
+			for {
+				line, err := reader.ReadString('\n')
+				require.NoError(t, err)
+				line = strings.TrimSuffix(line, "\n")
+				if line == "" {
+					if len(lines) > 0 && !strings.HasPrefix(lines[0], "retry:") {
+						return lines
+					}
+					lines = nil
+					continue
+				}
+				lines = append(lines, line)
+			}
+		}
+		return next, func() {
+			cancel()
+			resp.Body.Close()
+		}
+	}
+	waitSubscribers := func(n int) {
+		require.Eventually(t, func() bool {
+			ws.mutex.RLock()
+			defer ws.mutex.RUnlock()
+			return len(ws.rooms["ventas"]) == n
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	t.Run("filtra por tipo de evento", func(t *testing.T) {
+		next, closeStream := open(t, "?events=message", nil)
+		defer closeStream()
+		waitSubscribers(1)
+
+		emit("status", "conectado")
+		emit("message", "hola")
+
+		lines := next()
+		assert.Equal(t, "id: 2", lines[0])
+		assert.Equal(t, "event: message", lines[1])
+		assert.Contains(t, lines[2], `"text":"hola"`)
+		assert.Contains(t, lines[2], `"seq":2`)
+	})
+
+	t.Run("reanuda con Last-Event-ID", func(t *testing.T) {
+		waitSubscribers(0)
+		emit("message", "mientras tanto")
+
+		next, closeStream := open(t, "", http.Header{"Last-Event-ID": {"2"}})
+		defer closeStream()
+
+		lines := next()
+		assert.Equal(t, "id: 3", lines[0])
+		assert.Contains(t, lines[2], "mientras tanto")
+
+		emit("receipt", "leído")
+		assert.Equal(t, "event: receipt", next()[1])
+	})
+}
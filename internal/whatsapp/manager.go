@@ -33,6 +33,7 @@ type Manager struct {
 	webhookSvc    WebhookServiceInterface
 	wsService     WebSocketServiceInterface
 	automationSvc AutomationServiceInterface
+	dedupTTL      time.Duration // Ventana del seen-set de eventos entrantes
 }
 
 // WebhookServiceInterface interfaz para evitar dependencia circular
@@ -63,6 +64,7 @@ func NewManager(
 		instanceRepo: instanceRepo,
 		msgRepo:      msgRepo,
 		redisClient:  redisClient,
+		dedupTTL:     10 * time.Minute,
 	}
 }
 
@@ -81,6 +83,31 @@ func (m *Manager) SetAutomationService(svc AutomationServiceInterface) {
 	m.automationSvc = svc
 }
 
+// SetEventDedupTTL configura cuánto se recuerda un evento entrante para descartar duplicados
+func (m *Manager) SetEventDedupTTL(ttl time.Duration) {
+	if ttl > 0 {
+		m.dedupTTL = ttl
+	}
+}
+
+// occurrenceKey arma la clave de origen de un evento que no trae ID propio en WhatsApp
+// (estado de conexión, presencia). Incluye el instante en que se procesa, así que debe
+// calcularse una sola vez por evento y reutilizarse en el webhook y en el WebSocket.
+func occurrenceKey(parts ...string) string {
+	return strings.Join(append(parts, fmt.Sprint(time.Now().UnixNano())), ":")
+}
+
+// isDuplicateEvent indica si WhatsApp ya entregó este evento (p. ej. tras una reconexión).
+// Si Redis falla se procesa el evento: es preferible un duplicado a perderlo.
+func (m *Manager) isDuplicateEvent(ctx context.Context, instanceID, eventType, sourceID string) bool {
+	first, err := m.redisClient.MarkEventSeen(ctx, instanceID, eventType, sourceID, m.dedupTTL)
+	if err != nil {
+		log.Warn().Err(err).Str("instance_id", instanceID).Str("event", eventType).Msg("Error consultando seen-set de eventos")
+		return false
+	}
+	return !first
+}
+
 // LoadInstances carga las instancias existentes desde la base de datos
 func (m *Manager) LoadInstances(ctx context.Context) error {
 	log.Info().Msg("Cargando instancias existentes")
@@ -287,6 +314,7 @@ func (m *Manager) handleEvent(ctx context.Context, instanceID string, evt interf
 			if m.wsService != nil {
 				m.wsService.BroadcastEvent("qr", map[string]interface{}{
 					"instance_id": instanceID,
+					"event_id":    models.NewEventID(instanceID, "qr", qrCode),
 					"qr":          qrCode,
 				})
 			}
@@ -333,8 +361,10 @@ func (m *Manager) handleEvent(ctx context.Context, instanceID string, evt interf
 		log.Info().Str("instance_id", instanceID).Msg("Instancia conectada")
 
 		// Enviar webhook de estado
+		eventID := models.NewEventID(instanceID, "status", occurrenceKey("connected"))
 		if m.webhookSvc != nil {
 			m.webhookSvc.SendEvent(ctx, instanceID, &models.WebhookEvent{
+				ID:    eventID,
 				Event: "status",
 				Data: models.StatusEvent{
 					Status: "connected",
@@ -345,6 +375,7 @@ func (m *Manager) handleEvent(ctx context.Context, instanceID string, evt interf
 		if m.wsService != nil {
 			m.wsService.BroadcastEvent("status", map[string]interface{}{
 				"instance_id": instanceID,
+				"event_id":    eventID,
 				"status":      "connected",
 			})
 		}
@@ -359,8 +390,10 @@ func (m *Manager) handleEvent(ctx context.Context, instanceID string, evt interf
 		log.Info().Str("instance_id", instanceID).Msg("Instancia desconectada")
 
 		// Enviar webhook de estado
+		eventID := models.NewEventID(instanceID, "status", occurrenceKey("disconnected"))
 		if m.webhookSvc != nil {
 			m.webhookSvc.SendEvent(ctx, instanceID, &models.WebhookEvent{
+				ID:    eventID,
 				Event: "status",
 				Data: models.StatusEvent{
 					Status: "disconnected",
@@ -371,6 +404,7 @@ func (m *Manager) handleEvent(ctx context.Context, instanceID string, evt interf
 		if m.wsService != nil {
 			m.wsService.BroadcastEvent("status", map[string]interface{}{
 				"instance_id": instanceID,
+				"event_id":    eventID,
 				"status":      "disconnected",
 			})
 		}
@@ -386,6 +420,7 @@ func (m *Manager) handleEvent(ctx context.Context, instanceID string, evt interf
 		// Enviar webhook de estado
 		if m.webhookSvc != nil {
 			m.webhookSvc.SendEvent(ctx, instanceID, &models.WebhookEvent{
+				ID:    models.NewEventID(instanceID, "status", occurrenceKey("logged_out")),
 				Event: "status",
 				Data: models.StatusEvent{
 					Status: "logged_out",
@@ -412,7 +447,10 @@ func (m *Manager) handleEvent(ctx context.Context, instanceID string, evt interf
 				if v.Data.SyncType != nil {
 					syncType = v.Data.SyncType.String()
 				}
+				// Cada bloque del historial trae su orden y su progreso: juntos identifican el aviso.
+				sourceID := fmt.Sprintf("%s:%d:%d", syncType, v.Data.GetChunkOrder(), v.Data.GetProgress())
 				m.webhookSvc.SendEvent(bgCtx, instanceID, &models.WebhookEvent{
+					ID:    models.NewEventID(instanceID, "sync_progress", sourceID),
 					Event: "sync_progress",
 					Data: models.SyncEvent{
 						Percentage: int(*v.Data.Progress),
@@ -536,6 +574,12 @@ func (m *Manager) handleEvent(ctx context.Context, instanceID string, evt interf
 		// Guardar mensaje en base de datos
 		bgCtx := context.Background()
 
+		if m.isDuplicateEvent(bgCtx, instanceID, "message", v.Info.ID) {
+			log.Debug().Str("instance_id", instanceID).Str("msg_id", v.Info.ID).Msg("Mensaje duplicado descartado")
+			return
+		}
+		eventID := models.NewEventID(instanceID, "message", v.Info.ID)
+
 		// Determinar contenido y tipo
 		var content string
 		var msgType string = "unknown"
@@ -736,6 +780,7 @@ func (m *Manager) handleEvent(ctx context.Context, instanceID string, evt interf
 			}
 
 			m.webhookSvc.SendEvent(ctx, instanceID, &models.WebhookEvent{
+				ID:    eventID,
 				Event: "message",
 				Data:  messageEvent,
 			})
@@ -800,26 +845,56 @@ func (m *Manager) handleEvent(ctx context.Context, instanceID string, evt interf
 			if m.wsService != nil {
 				m.wsService.BroadcastEvent("message", map[string]interface{}{
 					"instance_id": instanceID,
+					"event_id":    eventID,
 					"data":        messageEvent,
 				})
 			}
 		}
 
 	case *events.Receipt:
-		// Enviar webhook de confirmación de lectura/entrega
+		// Enviar confirmación de lectura/entrega por webhook y WebSocket
+		if m.webhookSvc == nil && m.wsService == nil {
+			return
+		}
+		ids := make([]string, len(v.MessageIDs))
+		for i, id := range v.MessageIDs {
+			ids[i] = string(id)
+		}
+		sourceID := string(v.Type) + ":" + v.Sender.String() + ":" + strings.Join(ids, ",")
+		if m.isDuplicateEvent(context.Background(), instanceID, "receipt", sourceID) {
+			return
+		}
+		eventID := models.NewEventID(instanceID, "receipt", sourceID)
+
+		receipt := models.ReceiptEvent{
+			From:      v.Sender.String(),
+			Type:      string(v.Type),
+			Timestamp: v.Timestamp.Unix(),
+			IDs:       ids,
+		}
+		if len(ids) > 0 {
+			receipt.MessageID = ids[0]
+		}
 		if m.webhookSvc != nil {
 			m.webhookSvc.SendEvent(ctx, instanceID, &models.WebhookEvent{
+				ID:    eventID,
 				Event: "receipt",
-				Data: models.ReceiptEvent{
-					From:      v.Sender.String(),
-					Type:      string(v.Type),
-					Timestamp: v.Timestamp.Unix(),
-				},
+				Data:  receipt,
+			})
+		}
+
+		if m.wsService != nil {
+			m.wsService.BroadcastEvent("receipt", map[string]interface{}{
+				"instance_id": instanceID,
+				"event_id":    eventID,
+				"data":        receipt,
 			})
 		}
 
 	case *events.ChatPresence:
 		// Enviar evento de presencia (escribiendo, grabando audio, etc.)
+		eventID := models.NewEventID(instanceID, "presence",
+			occurrenceKey(v.MessageSource.Chat.String(), string(v.State), string(v.Media)))
 		if m.wsService != nil {
 			presenceType := "available"
 			if v.State == types.ChatPresenceComposing {
@@ -830,6 +905,7 @@ func (m *Manager) handleEvent(ctx context.Context, instanceID string, evt interf
 
 			m.wsService.BroadcastEvent("presence", map[string]interface{}{
 				"instance_id": instanceID,
+				"event_id":    eventID,
 				"data": map[string]interface{}{
 					"from": v.MessageSource.Chat.String(),
 					"type": presenceType,
@@ -840,6 +916,7 @@ func (m *Manager) handleEvent(ctx context.Context, instanceID string, evt interf
 		// Enviar webhook de presencia
 		if m.webhookSvc != nil {
 			m.webhookSvc.SendEvent(ctx, instanceID, &models.WebhookEvent{
+				ID:    eventID,
 				Event: "presence",
 				Data: map[string]interface{}{
 					"from":  v.MessageSource.Chat.String(),
@@ -910,6 +987,7 @@ func (m *Manager) handleEvent(ctx context.Context, instanceID string, evt interf
 				}
 
 				m.webhookSvc.SendEvent(bgCtx, instanceID, &models.WebhookEvent{
+					ID:    models.NewEventID(instanceID, "call", v.CallID),
 					Event: "call",
 					Data: models.CallEvent{
 						From:      v.From.String(),
@@ -3,7 +3,10 @@ package errors
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // AppError representa un error de la aplicación
@@ -12,6 +15,9 @@ type AppError struct {
 	Message  string `json:"message"`
 	Details  string `json:"details,omitempty"`
 	RawError error  `json:"-"` // Error original para debugging interno
+
+	// Segundos tras los que conviene reintentar (429/503); también se envía como header Retry-After
+	RetryAfter int `json:"retry_after,omitempty"`
 }
 
 // Error implementa la interfaz error
@@ -50,6 +56,7 @@ var (
 	ErrNotAuthenticated   = &AppError{Code: http.StatusUnauthorized, Message: "Instancia no autenticada"}
 	ErrDatabaseLocked     = &AppError{Code: http.StatusServiceUnavailable, Message: "Base de datos ocupada, intente nuevamente"}
 	ErrRateLimitReached   = &AppError{Code: http.StatusTooManyRequests, Message: "Límite de mensajes alcanzado, reintentando asíncronamente"}
+	ErrSendLimitReached   = &AppError{Code: http.StatusTooManyRequests, Message: "Límite de envío de la instancia alcanzado"}
 )
 
 // New crea un nuevo error personalizado
@@ -63,26 +70,42 @@ func New(code int, message string) *AppError {
 // WithDetails añade detalles explicativos al error
 func (e *AppError) WithDetails(details string) *AppError {
 	return &AppError{
-		Code:     e.Code,
-		Message:  e.Message,
-		Details:  details,
-		RawError: e.RawError,
+		Code:       e.Code,
+		Message:    e.Message,
+		Details:    details,
+		RawError:   e.RawError,
+		RetryAfter: e.RetryAfter,
+	}
+}
+
+// WithRetryAfter indica cuándo conviene reintentar (redondeado hacia arriba a segundos)
+func (e *AppError) WithRetryAfter(d time.Duration) *AppError {
+	return &AppError{
+		Code:       e.Code,
+		Message:    e.Message,
+		Details:    e.Details,
+		RawError:   e.RawError,
+		RetryAfter: int(math.Ceil(d.Seconds())),
 	}
 }
 
 // Wrap envuelve un error original manteniendo el código y mensaje
 func (e *AppError) Wrap(err error) *AppError {
 	return &AppError{
-		Code:     e.Code,
-		Message:  e.Message,
-		Details:  err.Error(),
-		RawError: err,
+		Code:       e.Code,
+		Message:    e.Message,
+		Details:    err.Error(),
+		RawError:   err,
+		RetryAfter: e.RetryAfter,
 	}
 }
 
 // WriteJSON escribe el error como JSON en la respuesta HTTP
 func WriteJSON(w http.ResponseWriter, err *AppError) {
 	w.Header().Set("Content-Type", "application/json")
+	if err.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(err.RetryAfter))
+	}
 	w.WriteHeader(err.Code)
 
 	response := ErrorResponse{
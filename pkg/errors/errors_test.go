@@ -2,7 +2,9 @@ package errors
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -80,3 +82,15 @@ func TestNew(t *testing.T) {
 	assert.Equal(t, "I'm a teapot", err.Message)
 	assert.Empty(t, err.Details)
 }
+
+func TestAppError_WithRetryAfter(t *testing.T) {
+	err := ErrSendLimitReached.WithDetails("límite por minuto").WithRetryAfter(1500 * time.Millisecond)
+	assert.Equal(t, 2, err.RetryAfter)
+	assert.Equal(t, 2, err.WithDetails("otro").RetryAfter)
+
+	w := httptest.NewRecorder()
+	WriteJSON(w, err)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), `"retry_after":2`)
+}
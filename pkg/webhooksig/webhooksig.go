@@ -0,0 +1,147 @@
+// Package webhooksig firma y verifica los webhooks que envía Kero-Kero.
+//
+// Cada petición lleva el header X-Kero-Signature con el formato:
+//
+//	t=<unix>,id=<delivery_id>,v1=<hex>[,v1=<hex>]
+//
+// Cada v1 es el HMAC-SHA256 (hex) de "<t>.<delivery_id>.<body>" con uno de los
+// secretos activos de la suscripción. Durante una rotación se envían dos firmas v1,
+// así el receptor puede verificar con el secreto viejo o el nuevo.
+//
+// Uso en un receptor:
+//
+//	body, _ := io.ReadAll(r.Body)
+//	sig, err := webhooksig.Verify(body, r.Header.Get(webhooksig.Header), webhooksig.DefaultTolerance, secret)
+//	if err != nil {
+//		http.Error(w, "firma inválida", http.StatusUnauthorized)
+//		return
+//	}
+//	// sig.DeliveryID es estable entre reintentos: úsalo para descartar duplicados
+package webhooksig
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Header es el header HTTP con la firma versionada
+	Header = "X-Kero-Signature"
+	// Version es el esquema de firma actual
+	Version = "v1"
+	// DefaultTolerance es la antigüedad máxima aceptada para el timestamp firmado
+	DefaultTolerance = 5 * time.Minute
+)
+
+var (
+	ErrMissingHeader    = errors.New("webhooksig: header de firma ausente")
+	ErrInvalidHeader    = errors.New("webhooksig: header de firma mal formado")
+	ErrTimestampExpired = errors.New("webhooksig: timestamp fuera de la tolerancia")
+	ErrNoValidSignature = errors.New("webhooksig: ninguna firma coincide")
+)
+
+// Signature contenido del header de firma
+type Signature struct {
+	Timestamp  time.Time
+	DeliveryID string
+	V1         []string // Firmas hex, una por secreto activo
+}
+
+// Sign calcula la firma v1 de un cuerpo con un secreto
+func Sign(secret string, timestamp time.Time, deliveryID string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	h.Write([]byte("."))
+	h.Write([]byte(deliveryID))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HeaderValue construye el valor del header con una firma por cada secreto.
+// Los secretos vacíos se ignoran.
+func HeaderValue(timestamp time.Time, deliveryID string, body []byte, secrets ...string) string {
+	parts := []string{
+		"t=" + strconv.FormatInt(timestamp.Unix(), 10),
+		"id=" + deliveryID,
+	}
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		parts = append(parts, Version+"="+Sign(secret, timestamp, deliveryID, body))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseHeader interpreta el valor del header de firma.
+// Los esquemas desconocidos se ignoran para permitir agregar versiones nuevas.
+func ParseHeader(header string) (*Signature, error) {
+	if header == "" {
+		return nil, ErrMissingHeader
+	}
+
+	sig := &Signature{}
+	hasTimestamp := false
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, ErrInvalidHeader
+		}
+		switch key {
+		case "t":
+			ts, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, ErrInvalidHeader
+			}
+			sig.Timestamp = time.Unix(ts, 0)
+			hasTimestamp = true
+		case "id":
+			sig.DeliveryID = value
+		case Version:
+			sig.V1 = append(sig.V1, value)
+		}
+	}
+
+	if !hasTimestamp || sig.DeliveryID == "" {
+		return nil, ErrInvalidHeader
+	}
+	return sig, nil
+}
+
+// Verify valida el header de firma de un webhook recibido.
+// Acepta la petición si alguna firma v1 coincide con alguno de los secretos y el
+// timestamp no es más antiguo (ni más futuro) que tolerance. Con tolerance <= 0 no se
+// valida el timestamp.
+func Verify(body []byte, header string, tolerance time.Duration, secrets ...string) (*Signature, error) {
+	sig, err := ParseHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	if tolerance > 0 {
+		age := time.Since(sig.Timestamp)
+		if age > tolerance || age < -tolerance {
+			return nil, ErrTimestampExpired
+		}
+	}
+
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		expected := Sign(secret, sig.Timestamp, sig.DeliveryID, body)
+		for _, candidate := range sig.V1 {
+			if hmac.Equal([]byte(expected), []byte(candidate)) {
+				return sig, nil
+			}
+		}
+	}
+
+	return nil, ErrNoValidSignature
+}
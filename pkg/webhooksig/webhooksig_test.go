@@ -0,0 +1,74 @@
+package webhooksig
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"event":"message"}`)
+	now := time.Now()
+
+	t.Run("firma válida", func(t *testing.T) {
+		header := HeaderValue(now, "delivery-1", body, "secreto")
+
+		sig, err := Verify(body, header, DefaultTolerance, "secreto")
+		require.NoError(t, err)
+		assert.Equal(t, "delivery-1", sig.DeliveryID)
+		assert.Equal(t, now.Unix(), sig.Timestamp.Unix())
+	})
+
+	t.Run("rotación con dos secretos activos", func(t *testing.T) {
+		header := HeaderValue(now, "delivery-2", body, "nuevo", "viejo")
+
+		_, err := Verify(body, header, DefaultTolerance, "viejo")
+		assert.NoError(t, err)
+		_, err = Verify(body, header, DefaultTolerance, "nuevo")
+		assert.NoError(t, err)
+		_, err = Verify(body, header, DefaultTolerance, "otro")
+		assert.ErrorIs(t, err, ErrNoValidSignature)
+	})
+
+	t.Run("cuerpo o delivery ID alterados", func(t *testing.T) {
+		header := HeaderValue(now, "delivery-3", body, "secreto")
+
+		_, err := Verify([]byte(`{"event":"otro"}`), header, DefaultTolerance, "secreto")
+		assert.ErrorIs(t, err, ErrNoValidSignature)
+
+		tampered := HeaderValue(now, "delivery-4", body) + ",v1=" + Sign("secreto", now, "delivery-3", body)
+		_, err = Verify(body, tampered, DefaultTolerance, "secreto")
+		assert.ErrorIs(t, err, ErrNoValidSignature)
+	})
+
+	t.Run("timestamp vencido", func(t *testing.T) {
+		old := now.Add(-10 * time.Minute)
+		header := HeaderValue(old, "delivery-5", body, "secreto")
+
+		_, err := Verify(body, header, DefaultTolerance, "secreto")
+		assert.ErrorIs(t, err, ErrTimestampExpired)
+
+		_, err = Verify(body, header, 0, "secreto")
+		assert.NoError(t, err, "tolerance 0 no valida el timestamp")
+	})
+
+	t.Run("header ausente o mal formado", func(t *testing.T) {
+		_, err := Verify(body, "", DefaultTolerance, "secreto")
+		assert.ErrorIs(t, err, ErrMissingHeader)
+
+		_, err = Verify(body, "v1=abc", DefaultTolerance, "secreto")
+		assert.ErrorIs(t, err, ErrInvalidHeader)
+
+		_, err = Verify(body, "t=abc,id=x,v1=abc", DefaultTolerance, "secreto")
+		assert.ErrorIs(t, err, ErrInvalidHeader)
+	})
+}
+
+func TestParseHeader_IgnoresUnknownSchemes(t *testing.T) {
+	sig, err := ParseHeader("t=1700000000,id=abc,v0=old,v1=aa,v2=future,v1=bb")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aa", "bb"}, sig.V1)
+	assert.Equal(t, int64(1700000000), sig.Timestamp.Unix())
+}